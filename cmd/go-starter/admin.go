@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"noxecane/go-starter/pkg/audit"
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/invitations"
+	"noxecane/go-starter/pkg/notification"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/webhooks"
+	"noxecane/go-starter/pkg/workspaces"
+
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/uptrace/bun"
+)
+
+// cliAgent stands in for the user agent on audit events recorded from the command line.
+const cliAgent = "go-starter cli"
+
+func createWorkspace(ctx context.Context, args []string) error {
+	fs := flags("create-workspace", "-name <company name> -email <owner email>")
+	name := fs.String("name", "", "the company name")
+	email := fs.String("email", "", "the email address of the owner, who gets invited to set up their account")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	*name = strings.TrimSpace(*name)
+	*email = strings.ToLower(strings.TrimSpace(*email))
+
+	err := ozzo.Errors{
+		"name":  ozzo.Validate(*name, ozzo.Required),
+		"email": ozzo.Validate(*email, ozzo.Required, is.Email),
+	}.Filter()
+	if err != nil {
+		return err
+	}
+
+	env, log, err := loadEnv()
+	if err != nil {
+		return err
+	}
+
+	app, disconnect, err := connect(ctx, &env, log)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	var wk *workspaces.Workspace
+	var owner *users.User
+	err = app.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		if wk, err = workspaces.NewRepo(tx).Create(ctx, *name, *email); err != nil {
+			return err
+		}
+
+		owner, err = users.NewRepo(tx).Create(ctx, wk.ID, users.UserRequest{
+			EmailAddress: *email,
+			Role:         users.RoleOwner,
+		})
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created workspace %d (%s) owned by user %d\n", wk.ID, wk.Slug, owner.ID)
+
+	return invite(ctx, app, wk, owner, env.ClientOwnerPage.String())
+}
+
+func createUser(ctx context.Context, args []string) error {
+	fs := flags("create-user", "-workspace <id or slug> -email <email> [-role member|admin]")
+	wkRef := fs.String("workspace", "", "the ID or slug of the workspace")
+	email := fs.String("email", "", "the email address of the user, who gets invited to set up their account")
+	role := fs.String("role", users.RoleMember, "the user's role in the workspace, member or admin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	*email = strings.ToLower(strings.TrimSpace(*email))
+
+	err := ozzo.Errors{
+		"workspace": ozzo.Validate(*wkRef, ozzo.Required),
+		"email":     ozzo.Validate(*email, ozzo.Required, is.Email),
+		"role":      ozzo.Validate(*role, ozzo.Required, ozzo.In(users.RoleMember, users.RoleAdmin)),
+	}.Filter()
+	if err != nil {
+		return err
+	}
+
+	env, log, err := loadEnv()
+	if err != nil {
+		return err
+	}
+
+	app, disconnect, err := connect(ctx, &env, log)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	wk, err := findWorkspace(ctx, workspaces.NewRepo(app.DB), *wkRef)
+	if err != nil {
+		return err
+	}
+
+	var user *users.User
+	err = app.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		if user, err = users.NewRepo(tx).Create(ctx, wk.ID, users.UserRequest{EmailAddress: *email, Role: *role}); err != nil {
+			return err
+		}
+
+		e := cliEvent(wk.ID, audit.ActionUserInvited, audit.TargetUser, user.ID)
+		e.Changes = map[string]audit.Change{
+			"role": {After: *role},
+		}
+		if err := audit.NewRepo(tx).Record(ctx, e); err != nil {
+			return err
+		}
+
+		return webhooks.NewRepo(tx).Enqueue(ctx, wk.ID, webhooks.EventUserInvited, user.Ref())
+	})
+	if err != nil {
+		if errors.Is(err, users.ErrExistingEmail) {
+			return fmt.Errorf("%s is already a member of %s", *email, wk.Slug)
+		}
+		return err
+	}
+
+	fmt.Printf("added user %d to workspace %d (%s) as %s\n", user.ID, wk.ID, wk.Slug, user.Role)
+
+	return invite(ctx, app, wk, user, env.ClientUserPage.String())
+}
+
+func resetPassword(ctx context.Context, args []string) error {
+	fs := flags("reset-password", "-email <email> [-password <password>]")
+	email := fs.String("email", "", "the email address of the user")
+	password := fs.String("password", "", "the new password, a random one is generated and printed if left out")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	*email = strings.ToLower(strings.TrimSpace(*email))
+
+	generated := *password == ""
+	if generated {
+		raw := make([]byte, 12)
+		if _, err := rand.Read(raw); err != nil {
+			return err
+		}
+		*password = base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	err := ozzo.Errors{
+		"email":    ozzo.Validate(*email, ozzo.Required, is.Email),
+		"password": ozzo.Validate(*password, ozzo.Length(8, 64)),
+	}.Filter()
+	if err != nil {
+		return err
+	}
+
+	env, log, err := loadEnv()
+	if err != nil {
+		return err
+	}
+
+	// only postgres is needed here
+	pool, _, db, err := setupDB(&env)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Err(err).Msg("failed to disconnect from postgres cleanly")
+		}
+		pool.Close()
+	}()
+
+	var user *users.User
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		uRepo := users.NewRepo(tx)
+
+		var err error
+		if user, err = uRepo.ResetPassword(ctx, *email, *password); err != nil || user == nil {
+			return err
+		}
+
+		members, err := uRepo.Memberships(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		// no diff, we don't want password hashes in the audit log
+		aRepo := audit.NewRepo(tx)
+		for _, m := range members {
+			if err := aRepo.Record(ctx, cliEvent(m.Workspace, audit.ActionPasswordChanged, audit.TargetUser, user.ID)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return fmt.Errorf("there's no user with a password set for %s", *email)
+	}
+
+	if generated {
+		fmt.Printf("password for user %d reset to %s\n", user.ID, *password)
+	} else {
+		fmt.Printf("password for user %d reset\n", user.ID)
+	}
+
+	return nil
+}
+
+func sendTestMail(ctx context.Context, args []string) error {
+	fs := flags("send-test-mail", "-to <email> [-queue]")
+	to := fs.String("to", "", "the email address to send the mail to")
+	queue := fs.Bool("queue", false, "send the mail through the job queue, to check the workers too")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := ozzo.Validate(*to, ozzo.Required, is.Email); err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+
+	env, log, err := loadEnv()
+	if err != nil {
+		return err
+	}
+
+	opts := mailOpts(&env, nil)
+	if *queue {
+		app, disconnect, err := connect(ctx, &env, log)
+		if err != nil {
+			return err
+		}
+		defer disconnect()
+
+		opts.Queue = app.Jobs
+	}
+
+	mailer := notification.New(opts)
+
+	err = mailer.Send(ctx, notification.TemplateMail{
+		Sender:        notification.SenderNotify,
+		Subject:       fmt.Sprintf("Test mail from %s", env.Name),
+		ReceiverEmail: *to,
+		Template:      "test",
+		TemplateData: struct {
+			Name   string
+			AppEnv string
+			SentAt string
+		}{
+			env.Name,
+			env.AppEnv,
+			time.Now().Format(time.RFC1123),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if *queue {
+		fmt.Printf("queued test mail to %s\n", *to)
+	} else {
+		fmt.Printf("sent test mail to %s\n", *to)
+	}
+
+	return nil
+}
+
+// findWorkspace looks up an active workspace by its ID or slug.
+func findWorkspace(ctx context.Context, wRepo *workspaces.Repo, ref string) (*workspaces.Workspace, error) {
+	var wk *workspaces.Workspace
+	var err error
+
+	if id, perr := strconv.ParseUint(ref, 10, 64); perr == nil {
+		wk, err = wRepo.Get(ctx, uint(id))
+	} else {
+		wk, err = wRepo.GetBySlug(ctx, ref)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if wk == nil || wk.IsDeleted() {
+		return nil, fmt.Errorf("workspace %q does not exist", ref)
+	}
+
+	return wk, nil
+}
+
+// invite lets the user know they've been added to the workspace, sending them an
+// invitation to set up their account unless they already have one. Mails are sent
+// straight away rather than left for a worker.
+func invite(ctx context.Context, app *config.App, wk *workspaces.Workspace, user *users.User, route string) error {
+	mailer := notification.New(mailOpts(app.Env, nil))
+
+	if len(user.Password) > 0 {
+		iv := invitations.Invitation{
+			Workspace:    wk.ID,
+			CompanyName:  wk.CompanyName,
+			Slug:         wk.Slug,
+			EmailAddress: string(user.EmailAddress),
+		}
+
+		if err := invitations.SendMembership(ctx, mailer, app.Env.ClientLoginPage.String(), iv); err != nil {
+			return fmt.Errorf("could not send membership mail: %w", err)
+		}
+
+		fmt.Printf("%s already has an account, they've been told about the workspace\n", user.EmailAddress)
+		return nil
+	}
+
+	iv, err := invitations.NewStore(app.Tokens).Create(ctx, wk, string(user.EmailAddress))
+	if err != nil {
+		return err
+	}
+
+	// print it first, so it can still be used if the mail doesn't go out
+	fmt.Printf("invitation token for %s: %s\n", user.EmailAddress, iv.Token)
+
+	if err := invitations.SendInvitation(ctx, mailer, route, iv); err != nil {
+		return fmt.Errorf("could not send invitation mail: %w", err)
+	}
+
+	return nil
+}
+
+func cliEvent(wkID uint, action, targetType string, targetID uint) *audit.Event {
+	return &audit.Event{
+		Workspace:  wkID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatUint(uint64(targetID), 10),
+		UserAgent:  cliAgent,
+	}
+}
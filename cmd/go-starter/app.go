@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/invitations"
+	"noxecane/go-starter/pkg/jobs"
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/metrics"
+	"noxecane/go-starter/pkg/notification"
+	"noxecane/go-starter/pkg/pii"
+	"noxecane/go-starter/pkg/querylog"
+	"noxecane/go-starter/pkg/tracing"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/webhooks"
+	"noxecane/go-starter/pkg/workspaces"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	"github.com/rs/zerolog"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/extra/bunotel"
+)
+
+// setupDB connects to postgres, making sure the schema is at the version this build
+// expects when migrations aren't run on startup. Every query is measured and traced.
+func setupDB(env *config.Env) (*pgxpool.Pool, *sql.DB, *bun.DB, error) {
+	pool, err := config.SetupPool(*env)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sqlDB, db, err := config.OpenDB(*env, pool)
+	if err != nil {
+		if sqlDB != nil {
+			sqlDB.Close()
+		}
+		pool.Close()
+		return nil, nil, nil, err
+	}
+
+	if !env.AutoMigrate {
+		if err := config.CheckSchema(sqlDB); err != nil {
+			sqlDB.Close()
+			pool.Close()
+			return nil, nil, nil, err
+		}
+	}
+
+	db.AddQueryHook(metrics.QueryHook{})
+	db.AddQueryHook(bunotel.NewQueryHook(bunotel.WithDBName(env.PostgresDatabase)))
+
+	return pool, sqlDB, db, nil
+}
+
+// setupPII loads the keys personal data is encrypted with. Users from before
+// encryption are encrypted along with the migrations that made room for it, when the
+// app runs them, otherwise it won't start until `pii reencrypt` has been run.
+func setupPII(ctx context.Context, env *config.Env, db *bun.DB, log zerolog.Logger) error {
+	if err := pii.Setup(ctx, db, env.PIIKeys); err != nil {
+		return err
+	}
+
+	if !env.AutoMigrate {
+		plaintext, err := users.HasPlaintext(ctx, db)
+		if err != nil {
+			return err
+		} else if plaintext {
+			return errors.New("some users haven't been encrypted yet, run `pii reencrypt`")
+		}
+
+		return nil
+	}
+
+	n, err := users.Reencrypt(ctx, db, reencryptBatch, true)
+	if n > 0 {
+		log.Info().Int("users", n).Msg("encrypted users from before encryption")
+	}
+
+	return err
+}
+
+// connect sets up tracing and the connections to postgres and redis, returning a
+// function to close them.
+func connect(ctx context.Context, env *config.Env, log zerolog.Logger) (*config.App, func(), error) {
+	flushSpans, err := tracing.Setup(ctx, tracing.Opts{
+		Service:     env.Name,
+		Environment: env.AppEnv,
+		Exporter:    env.TracingExporter,
+		SampleRatio: env.TracingSampleRatio,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// connect to postgresql
+	pool, _, db, err := setupDB(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Info().Msg("successfully connected to postgres")
+
+	db.AddQueryHook(querylog.NewHook(log, env.SlowQueryThreshold))
+
+	if err := setupPII(ctx, env, db, log); err != nil {
+		db.Close()
+		pool.Close()
+		return nil, nil, err
+	}
+
+	// setup redis connection
+	startupCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	redisClient, err := config.SetupRedis(startupCtx, *env)
+	if err != nil {
+		db.Close()
+		pool.Close()
+		redisClient.Close()
+		return nil, nil, err
+	}
+	log.Info().Msg("successfully connected to redis")
+
+	// commands carry tokens and sessions, so leave them out of spans
+	if err := redisotel.InstrumentTracing(redisClient, redisotel.WithDBStatement(false)); err != nil {
+		db.Close()
+		pool.Close()
+		redisClient.Close()
+		return nil, nil, err
+	}
+
+	app := &config.App{
+		DB:     db,
+		Pool:   pool,
+		Env:    env,
+		Redis:  redisClient,
+		Tokens: keyring.NewTokenStore(redisClient, env.SigningKeys),
+		Jobs:   jobs.NewQueue(redisClient),
+	}
+	app.Auth = keyring.NewSessionStore(env.SigningKeys, env.Scheme, env.SessionTimeout, app.Tokens)
+
+	disconnect := func() {
+		if err := db.Close(); err != nil {
+			log.Err(err).Msg("failed to disconnect from postgres cleanly")
+		}
+		pool.Close()
+
+		if err := redisClient.Close(); err != nil {
+			log.Err(err).Msg("failed to disconnect from redis cleanly")
+		}
+
+		shutCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		if err := flushSpans(shutCtx); err != nil {
+			log.Err(err).Msg("failed to flush spans")
+		}
+	}
+
+	return app, disconnect, nil
+}
+
+// mailOpts configures mails to be sent by the job queue, or straight away when it's nil.
+func mailOpts(env *config.Env, queue *jobs.Queue) notification.MailOpts {
+	return notification.MailOpts{
+		Key:             env.SendgridKey,
+		Sender:          env.MailSender,
+		NotifyEmail:     env.NotifyEmail,
+		PostmasterEmail: env.PostmasterEmail,
+		Queue:           queue,
+	}
+}
+
+func newDispatcher(app *config.App) *webhooks.Dispatcher {
+	return webhooks.NewDispatcher(webhooks.NewRepo(app.DB), app.Env.WebhookTimeout, app.Env.WebhookAllowPrivate)
+}
+
+// newWorker creates a worker for every job the app runs in the background.
+func newWorker(app *config.App, log zerolog.Logger) (*jobs.Worker, error) {
+	env := app.Env
+
+	dispatcher := newDispatcher(app)
+
+	worker := jobs.NewWorker(app.Jobs, log, jobs.WorkerOpts{Concurrency: env.WorkerConcurrency})
+
+	jobs.Handle(worker, notification.MailJob, notification.DeliverMail(mailOpts(env, nil)))
+
+	// permanently remove workspaces past their grace period
+	if err := worker.Recurring("workspaces.purge", env.WorkspacePurgeSchedule, workspaces.PurgeExpired(workspaces.NewRepo(app.DB), invitations.NewStore(app.Tokens), log)); err != nil {
+		return nil, err
+	}
+
+	// remove invited users who never accepted, once their invitation is long gone
+	cleanup := users.CleanupPlaceholders(app.DB, invitations.TTL+env.InvitationGracePeriod, log)
+	if err := worker.Recurring("users.cleanup_placeholders", env.InvitationCleanupSchedule, cleanup); err != nil {
+		return nil, err
+	}
+
+	// send queued webhook deliveries
+	if err := worker.Recurring("webhooks.dispatch", env.WebhookSchedule, dispatcher.DispatchAll); err != nil {
+		return nil, err
+	}
+
+	return worker, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const configUsage = `<command>
+
+commands:
+  print  check the config and print every variable the app reads, with secrets redacted`
+
+func runConfig(_ context.Context, args []string) error {
+	fs := flags("config", configUsage)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	if sub := fs.Arg(0); sub != "print" {
+		fs.Usage()
+		return fmt.Errorf("unknown config command %q", sub)
+	}
+
+	env, _, err := loadEnv()
+	if err != nil {
+		return err
+	}
+
+	return env.Print(os.Stdout)
+}
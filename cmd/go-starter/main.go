@@ -2,131 +2,100 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"net/http"
-	"time"
+	"os"
+	"strings"
 
 	"noxecane/go-starter/pkg/config"
-	"noxecane/go-starter/pkg/notification"
-	"noxecane/go-starter/pkg/rest"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/noxecane/anansi"
-	"github.com/noxecane/anansi/sessions"
-	"github.com/noxecane/anansi/tokens"
-	"github.com/noxecane/anansi/webpack"
-	"github.com/redis/go-redis/v9"
-	"github.com/uptrace/bun"
+	"github.com/rs/zerolog"
 )
 
-func main() {
-	var err error
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
 
-	var env config.Env
-	if err = anansi.LoadEnv(&env); err != nil {
-		panic(err)
+func commands() []command {
+	return []command{
+		{"serve", "serve the API, the default when no command is given", serve},
+		{"worker", "run background jobs without serving the API", runWorker},
+		{"migrate", "manage database migrations: up|down|status|force|create", runMigrate},
+		{"create-workspace", "create a workspace and invite its owner", createWorkspace},
+		{"create-user", "invite a user to a workspace", createUser},
+		{"reset-password", "set a new password for a user", resetPassword},
+		{"send-test-mail", "send a mail to check the mail setup", sendTestMail},
+		{"config", "check the config and print it with secrets redacted", runConfig},
+		{"pii", "manage the keys personal data is encrypted with: rotate|reencrypt", runPII},
 	}
+}
 
-	log := anansi.NewLogger(env.Name)
-
-	ctx, cancel := anansi.WithCancel(context.Background())
-	defer cancel()
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	// connect to postgresql
-	var db *bun.DB
-	if _, db, err = config.SetupDB(env); err != nil {
-		panic(err)
+	if name == "help" {
+		usage()
+		return
 	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			log.Err(err).Msg("failed to disconnect from postgres cleanly")
+
+	for _, cmd := range commands() {
+		if cmd.name != name {
+			continue
 		}
-	}()
-	log.Info().Msg("successfully connected to postgres")
-
-	// setup redis connection
-	var redisClient *redis.Client
-	startupCtx, cancel := context.WithTimeout(ctx, time.Second*5)
-	defer cancel()
-	if redisClient, err = config.SetupRedis(startupCtx, env); err != nil {
-		panic(err)
-	}
-	defer func() {
-		if err := redisClient.Close(); err != nil {
-			log.Err(err).Msg("failed to disconnect from redis cleanly")
+
+		ctx, cancel := anansi.WithCancel(context.Background())
+		err := cmd.run(ctx, args)
+		cancel()
+
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
 		}
-	}()
-	log.Info().Msg("successfully connected to redis")
 
-	var sessionTimeout time.Duration
-	if sessionTimeout, err = time.ParseDuration(env.SessionTimeout); err != nil {
-		panic(err)
-	}
-	app := &config.App{
-		DB:     db,
-		Env:    &env,
-		Redis:  redisClient,
-		Tokens: tokens.NewStore(redisClient, env.Secret),
+		return
 	}
 
-	app.Auth = sessions.NewStore(env.Secret, env.Scheme, sessionTimeout, app.Tokens)
-
-	// API router
-	router := chi.NewRouter()
-
-	webpack.Webpack(router, log, webpack.WebpackOpts{
-		Environment: env.AppEnv,
-		CORSOrigins: []string{
-			"https://*.noxecane",
-			"http://localhost:8080",
-		},
-	})
-
-	router.NotFound(func(w http.ResponseWriter, _ *http.Request) {
-		http.Error(w, "Whoops!! This route doesn't exist", http.StatusNotFound)
-	})
-
-	// dependency factory
-	noty := notification.New(notification.MailOpts{
-		Key:             env.SendgridKey,
-		Sender:          env.MailSender,
-		NotifyEmail:     env.NotifyEmail,
-		PostmasterEmail: env.PostmasterEmail,
-	})
-
-	// setup routes
-	rest.Invitations(router, app, noty)
-
-	// mount API on app router
-	appRouter := chi.NewRouter()
-	appRouter.Mount("/api/v1", router)
-	appRouter.Get("/", config.HealthChecker(app))
-	appRouter.NotFound(func(w http.ResponseWriter, _ *http.Request) {
-		http.Error(w, "Whoops!! This route doesn't exist", http.StatusNotFound)
-	})
-
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", env.Port),
-		Handler: appRouter,
-	}
+	usage()
+	os.Exit(2)
+}
 
-	go func() {
-		l := log.With().Logger()
-		<-ctx.Done()
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: go-starter <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "run go-starter <command> -h for a command's flags")
+}
 
-		// shutdown server in 5s
-		shutCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-		defer cancel()
+// loadEnv reads the config every command shares from the environment.
+func loadEnv() (config.Env, zerolog.Logger, error) {
+	env, err := config.LoadEnv()
+	if err != nil {
+		return env, zerolog.Nop(), err
+	}
 
-		if err := server.Shutdown(shutCtx); err != nil {
-			l.Err(err).Msg("could not shut down server cleanly...")
-		}
-	}()
+	return env, anansi.NewLogger(env.Name), nil
+}
 
-	log.Info().Msgf("serving api at http://127.0.0.1:%d", env.Port)
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		log.Err(err).Msg("could not start the server")
+// flags creates the flag set for a command, failing with its errors instead of exiting.
+func flags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: go-starter %s %s\n", name, args)
+		fs.PrintDefaults()
 	}
 
-	<-ctx.Done()
+	return fs
 }
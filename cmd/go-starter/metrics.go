@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/metrics"
+
+	"github.com/rs/zerolog"
+)
+
+// serveMetrics serves /metrics on the admin port until ctx is done, so they're never
+// exposed along with the API.
+func serveMetrics(ctx context.Context, app *config.App, log zerolog.Logger) {
+	metrics.Registry.MustRegister(metrics.Postgres(app.Pool), metrics.Redis(app.Redis))
+
+	if app.Env.MetricsPort == 0 {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", app.Env.MetricsPort),
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		if err := server.Shutdown(shutCtx); err != nil {
+			log.Err(err).Msg("could not shut down metrics server cleanly...")
+		}
+	}()
+
+	go func() {
+		log.Info().Msgf("serving metrics at http://127.0.0.1:%d/metrics", app.Env.MetricsPort)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Err(err).Msg("could not serve metrics")
+		}
+	}()
+}
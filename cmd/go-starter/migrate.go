@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"noxecane/go-starter/pkg/config"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+const migrateUsage = `<command> [args]
+
+commands:
+  up [-dry-run] [n]         apply all pending migrations, or only the next n
+  down [-dry-run] [-all|n]  roll back the last n migrations, 1 by default, or all of them
+  status                    show the current version and pending migrations
+  force <v>                 set the version without running anything, to recover from a failed migration
+  create <name>             create empty up and down migrations
+
+up and down print the SQL they'd run instead with -dry-run`
+
+func runMigrate(ctx context.Context, args []string) error {
+	fs := flags("migrate", migrateUsage)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	sub, args := fs.Arg(0), fs.Args()[1:]
+
+	// creating migrations doesn't need a database
+	if sub == "create" {
+		if len(args) != 1 {
+			return errors.New("usage: go-starter migrate create <name>")
+		}
+		return createMigration(args[0])
+	}
+
+	var dryRun, all bool
+	switch sub {
+	case "up":
+		subFs := flags("migrate up", "[-dry-run] [n]")
+		subFs.BoolVar(&dryRun, "dry-run", false, "print the pending migrations and their SQL without running them")
+		if err := subFs.Parse(args); err != nil {
+			return err
+		}
+		args = subFs.Args()
+	case "down":
+		subFs := flags("migrate down", "[-dry-run] [-all | n]")
+		subFs.BoolVar(&dryRun, "dry-run", false, "print the migrations to roll back and their SQL without running them")
+		subFs.BoolVar(&all, "all", false, "roll back every migration")
+		if err := subFs.Parse(args); err != nil {
+			return err
+		}
+		args = subFs.Args()
+	}
+
+	env, _, err := loadEnv()
+	if err != nil {
+		return err
+	}
+
+	// we're here to run them by hand
+	env.AutoMigrate = false
+
+	sqlDB, _, err := config.SetupDB(env)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	mig, err := config.NewMigrator(sqlDB)
+	if err != nil {
+		return err
+	}
+	defer mig.Close()
+
+	// let a migration finish rather than leave the database dirty
+	go func() {
+		<-ctx.Done()
+		mig.GracefulStop <- true
+	}()
+
+	// only one migration runs at a time, whether it's from here or a replica starting up
+	locked := func(fn func() error) error {
+		err := config.WithMigrationLock(ctx, sqlDB, env.MigrationLockTimeout, fn)
+		return reportMigration(mig, err)
+	}
+
+	switch sub {
+	case "up":
+		n, err := optionalCount(args, 0)
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			return printPending(mig, n)
+		}
+
+		return locked(func() error {
+			if n == 0 {
+				return mig.Up()
+			}
+			return mig.Steps(n)
+		})
+	case "down":
+		n := -1
+		if !all {
+			if n, err = optionalCount(args, 1); err != nil {
+				return err
+			}
+		}
+
+		if dryRun {
+			return printRollback(mig, n)
+		}
+
+		return locked(func() error {
+			if n == -1 {
+				return mig.Down()
+			}
+			return mig.Steps(-n)
+		})
+	case "status":
+		return migrationStatus(mig)
+	case "force":
+		if len(args) != 1 {
+			return errors.New("usage: go-starter migrate force <version>")
+		}
+
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+
+		return locked(func() error {
+			return mig.Force(v)
+		})
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command %q", sub)
+	}
+}
+
+// optionalCount reads the number of migrations to run, if one was given.
+func optionalCount(args []string, def int) (int, error) {
+	switch len(args) {
+	case 0:
+		return def, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid number of migrations %q", args[0])
+		}
+		return n, nil
+	default:
+		return 0, errors.New("expected at most one number of migrations")
+	}
+}
+
+func reportMigration(mig *migrate.Migrate, err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Println("no change")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return migrationStatus(mig)
+}
+
+func migrationStatus(mig *migrate.Migrate) error {
+	version, dirty, err := config.SchemaVersion(mig)
+	if err != nil {
+		return err
+	}
+
+	available, err := config.Migrations()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[uint]string)
+	for _, m := range available {
+		names[m.Version] = m.Name
+	}
+
+	if version == 0 {
+		fmt.Println("version: none")
+	} else {
+		fmt.Printf("version: %d %s\n", version, names[version])
+	}
+
+	if dirty {
+		fmt.Println("dirty: the last migration failed, fix it and run `go-starter migrate force <version>`")
+	}
+
+	pending := pendingMigrations(available, version)
+	if len(pending) == 0 {
+		fmt.Println("pending: none")
+		return nil
+	}
+
+	fmt.Println("pending:")
+	for _, m := range pending {
+		fmt.Printf("  %d %s\n", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+func pendingMigrations(available []config.Migration, version uint) []config.Migration {
+	var pending []config.Migration
+	for _, m := range available {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending
+}
+
+// printPending prints the next n pending migrations, or all of them if n is 0.
+func printPending(mig *migrate.Migrate, n int) error {
+	version, _, err := config.SchemaVersion(mig)
+	if err != nil {
+		return err
+	}
+
+	available, err := config.Migrations()
+	if err != nil {
+		return err
+	}
+
+	pending := pendingMigrations(available, version)
+	if n > 0 && n < len(pending) {
+		pending = pending[:n]
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("-- no pending migrations")
+		return nil
+	}
+
+	for _, m := range pending {
+		if err := printMigration(m, m.Up); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printRollback prints the last n applied migrations' down SQL, or all of them if n
+// is -1.
+func printRollback(mig *migrate.Migrate, n int) error {
+	version, _, err := config.SchemaVersion(mig)
+	if err != nil {
+		return err
+	}
+
+	available, err := config.Migrations()
+	if err != nil {
+		return err
+	}
+
+	var applied []config.Migration
+	for i := len(available) - 1; i >= 0; i-- {
+		if available[i].Version <= version {
+			applied = append(applied, available[i])
+		}
+	}
+
+	if n >= 0 && n < len(applied) {
+		applied = applied[:n]
+	}
+
+	if len(applied) == 0 {
+		fmt.Println("-- no migrations to roll back")
+		return nil
+	}
+
+	for _, m := range applied {
+		if err := printMigration(m, m.Down); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func printMigration(m config.Migration, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("-- %d %s (%s)\n", m.Version, m.Name, filepath.Base(path))
+	fmt.Println(strings.TrimSpace(string(raw)))
+	fmt.Println()
+
+	return nil
+}
+
+func createMigration(name string) error {
+	name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+	if !migrationName.MatchString(name) {
+		return fmt.Errorf("invalid migration name %q, use letters, numbers and underscores", name)
+	}
+
+	version, err := config.ExpectedVersion()
+	if err != nil {
+		return err
+	}
+	version++
+
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(config.MigrationsDir(), fmt.Sprintf("%06d_%s.%s.sql", version, name, direction))
+
+		// never overwrite an existing migration
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+
+		_, err = f.WriteString("begin;\n\n\n\ncommit;\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(path)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"noxecane/go-starter/pkg/pii"
+	"noxecane/go-starter/pkg/users"
+)
+
+// reencryptBatch is how many users are encrypted in each transaction.
+const reencryptBatch = 500
+
+const piiUsage = `<command>
+
+commands:
+  rotate     add a data key, which every instance has to be restarted to know before it's activated
+  reencrypt  activate new data keys, rewrap every key with the first of PII_KEYS and encrypt
+             every user with the active data key`
+
+func runPII(ctx context.Context, args []string) error {
+	fs := flags("pii", piiUsage)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	sub := fs.Arg(0)
+	if sub != "rotate" && sub != "reencrypt" {
+		fs.Usage()
+		return fmt.Errorf("unknown pii command %q", sub)
+	}
+
+	env, _, err := loadEnv()
+	if err != nil {
+		return err
+	}
+
+	// the schema has to be up to date already, migrating is for `migrate up`
+	env.AutoMigrate = false
+
+	pool, sqlDB, db, err := setupDB(&env)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	defer sqlDB.Close()
+
+	if err := pii.Setup(ctx, db, env.PIIKeys); err != nil {
+		return err
+	}
+
+	if sub == "rotate" {
+		if err := pii.Rotate(ctx, db, env.PIIKeys); err != nil {
+			return err
+		}
+
+		fmt.Println("added a data key, restart every instance then run `pii reencrypt`")
+		return nil
+	}
+
+	activated, err := pii.Activate(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	// pick up the activated key
+	if err := pii.Setup(ctx, db, env.PIIKeys); err != nil {
+		return err
+	}
+
+	rewrapped, err := pii.Rewrap(ctx, db, env.PIIKeys)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := users.Reencrypt(ctx, db, reencryptBatch, false)
+	fmt.Printf("activated %d data keys, rewrapped %d keys and encrypted %d users\n", activated, rewrapped, encrypted)
+
+	return err
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/health"
+	"noxecane/go-starter/pkg/idempotency"
+	"noxecane/go-starter/pkg/metrics"
+	"noxecane/go-starter/pkg/notification"
+	"noxecane/go-starter/pkg/openapi"
+	"noxecane/go-starter/pkg/problem"
+	"noxecane/go-starter/pkg/querylog"
+	"noxecane/go-starter/pkg/ratelimit"
+	"noxecane/go-starter/pkg/rest"
+	"noxecane/go-starter/pkg/secure"
+	"noxecane/go-starter/pkg/tracing"
+	"noxecane/go-starter/pkg/workspaces"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/noxecane/anansi/webpack"
+)
+
+func serve(ctx context.Context, args []string) error {
+	fs := flags("serve", "[-worker=false]")
+	withWorker := fs.Bool("worker", true, "also run background jobs, turn off when running go-starter worker separately")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	env, log, err := loadEnv()
+	if err != nil {
+		return err
+	}
+
+	app, disconnect, err := connect(ctx, &env, log)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	// API router
+	router := chi.NewRouter()
+
+	webpack.Webpack(router, log, webpack.WebpackOpts{Environment: env.AppEnv})
+
+	router.Use(tracing.HTTP)
+
+	router.Use(ratelimit.Middleware(ratelimit.NewLimiter(app.Redis), ratelimit.Opts{
+		Rules:     env.RateLimits,
+		Workspace: rest.SessionWorkspace(app.Auth),
+		FailOpen:  env.RateLimitFailOpen,
+	}))
+
+	// catch handlers running a query per item, failing outright in tests
+	router.Use(querylog.Budget(env.QueryBudget, env.AppEnv == "test"))
+
+	// retries with an Idempotency-Key get the first response back, errors included
+	router.Use(idempotency.Middleware(app.Redis, env.IdempotencyTTL))
+
+	// errors are sent as problem details from here on, tracing and metrics still see
+	// their status
+	router.Use(problem.Recoverer(env.AppEnv, rest.Problems))
+	router.Use(rest.Tenant(workspaces.NewRepo(app.DB), env.WorkspaceDomain))
+
+	router.NotFound(problem.Handler(problem.RouteNotFound))
+	router.MethodNotAllowed(problem.Handler(problem.MethodNotAllowed))
+
+	// dependency factory
+	noty := notification.New(mailOpts(&env, app.Jobs))
+
+	dispatcher := newDispatcher(app)
+
+	// setup routes
+	if err := rest.Routes(router, app, noty, dispatcher); err != nil {
+		var drift *openapi.DriftError
+		if !errors.As(err, &drift) {
+			return err
+		}
+		log.Warn().Err(err).Msg("the API spec is out of date")
+	}
+
+	checks := healthChecks(app)
+
+	// mount API on app router
+	appRouter := chi.NewRouter()
+	appRouter.Use(metrics.HTTP)
+
+	// before webpack's RealIP, which believes X-Forwarded-For from anyone
+	appRouter.Use(secure.RealIP(env.TrustedProxies))
+	appRouter.Use(secure.CORS(env.AppEnv, env.CORSOrigins))
+	appRouter.Use(secure.Headers(secure.Policy{
+		HSTS:           env.HSTSMaxAge,
+		CSP:            env.ContentSecurityPolicy,
+		ReferrerPolicy: env.ReferrerPolicy,
+	}))
+	appRouter.Mount("/api/v1", router)
+	appRouter.Get("/healthz", health.Live())
+	appRouter.Get("/readyz", health.Ready(checks))
+	appRouter.NotFound(problem.Handler(problem.RouteNotFound))
+
+	// stop the worker too if the server can't start
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	serveMetrics(ctx, app, log)
+
+	workerDone := make(chan struct{})
+	if *withWorker {
+		worker, err := newWorker(app, log)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer close(workerDone)
+			worker.Run(ctx)
+		}()
+	} else {
+		close(workerDone)
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", env.Port),
+		Handler: appRouter,
+	}
+
+	go func() {
+		l := log.With().Logger()
+		<-ctx.Done()
+
+		// keep serving for a while after failing readiness, so load balancers can
+		// stop sending us requests first
+		checks.Shutdown()
+		time.Sleep(env.ShutdownDelay)
+
+		// shutdown server in 5s
+		shutCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		if err := server.Shutdown(shutCtx); err != nil {
+			l.Err(err).Msg("could not shut down server cleanly...")
+		}
+	}()
+
+	log.Info().Msgf("serving api at http://127.0.0.1:%d", env.Port)
+	err = server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+
+	cancel()
+
+	// let running jobs finish before closing connections
+	<-workerDone
+
+	return err
+}
+
+// healthChecks registers the dependencies the API needs to serve requests.
+func healthChecks(app *config.App) *health.Registry {
+	checks := health.NewRegistry(app.Env.HealthCheckTimeout)
+	checks.Register(health.Checker{Name: "postgres", Check: health.Postgres(app.DB)})
+	checks.Register(health.Checker{Name: "redis", Check: health.Redis(app.Redis)})
+
+	// mails wait in the job queue until they can be sent, so we can serve without them
+	checks.Register(health.Checker{Name: "mail", Check: health.Dial(notification.TransportAddr), Optional: true})
+
+	return checks
+}
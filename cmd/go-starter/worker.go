@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+)
+
+func runWorker(ctx context.Context, args []string) error {
+	fs := flags("worker", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	env, log, err := loadEnv()
+	if err != nil {
+		return err
+	}
+
+	app, disconnect, err := connect(ctx, &env, log)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+
+	worker, err := newWorker(app, log)
+	if err != nil {
+		return err
+	}
+
+	serveMetrics(ctx, app, log)
+
+	log.Info().Msg("running background jobs")
+
+	// returns once running jobs have finished
+	worker.Run(ctx)
+
+	return nil
+}
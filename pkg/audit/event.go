@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	ActionUserInvited          = "user.invited"
+	ActionInvitationAccepted   = "invitation.accepted"
+	ActionInvitationExpired    = "invitation.expired"
+	ActionPasswordChanged      = "user.password_changed"
+	ActionUserRoleChanged      = "user.role_changed"
+	ActionUserRemoved          = "user.removed"
+	ActionOwnershipTransferred = "workspace.ownership_transferred"
+	ActionWorkspaceRenamed     = "workspace.renamed"
+	ActionSlugChanged          = "workspace.slug_changed"
+	ActionWorkspaceDeleted     = "workspace.deleted"
+	ActionWorkspaceRestored    = "workspace.restored"
+	ActionTeamCreated          = "team.created"
+	ActionTeamUpdated          = "team.updated"
+	ActionTeamDeleted          = "team.deleted"
+	ActionTeamMemberAdded      = "team.member_added"
+	ActionTeamRoleChanged      = "team.role_changed"
+	ActionTeamMemberRemoved    = "team.member_removed"
+	ActionWebhookCreated       = "webhook.created"
+	ActionWebhookUpdated       = "webhook.updated"
+	ActionWebhookDeleted       = "webhook.deleted"
+)
+
+const (
+	TargetUser      = "user"
+	TargetWorkspace = "workspace"
+	TargetTeam      = "team"
+	TargetWebhook   = "webhook"
+)
+
+// Event is a record of something done in a workspace. Events can't be changed once
+// they're recorded.
+type Event struct {
+	bun.BaseModel `bun:"table:audit_events"`
+
+	ID         uint64            `bun:",pk" json:"id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Workspace  uint              `json:"workspace"`
+	Actor      *uint             `json:"actor"`
+	Action     string            `json:"action"`
+	TargetType string            `json:"target_type"`
+	TargetID   string            `json:"target_id"`
+	IPAddress  string            `json:"ip_address"`
+	UserAgent  string            `json:"user_agent"`
+	Changes    map[string]Change `bun:"type:jsonb" json:"changes,omitempty"`
+}
+
+// Change is the value of a field before and after an event.
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff compares the JSON forms of before and after, returning the fields that differ.
+// Either side can be nil for things that were created or removed.
+func Diff(before, after interface{}) map[string]Change {
+	b := toFields(before)
+	a := toFields(after)
+
+	changes := make(map[string]Change)
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			changes[k] = Change{Before: v, After: a[k]}
+		}
+	}
+
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = Change{Before: nil, After: v}
+		}
+	}
+
+	return changes
+}
+
+func toFields(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if v == nil {
+		return fields
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	// non-objects have no fields to compare
+	_ = json.Unmarshal(raw, &fields)
+
+	return fields
+}
@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var csvHeader = []string{
+	"id", "created_at", "workspace", "actor", "action",
+	"target_type", "target_id", "ip_address", "user_agent", "changes",
+}
+
+// WriteCSV writes the events as CSV with a header row. Changes are kept as JSON.
+// Values a spreadsheet would run as formulas are quoted with a leading '.
+func WriteCSV(w io.Writer, events []Event) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		var actor string
+		if e.Actor != nil {
+			actor = strconv.FormatUint(uint64(*e.Actor), 10)
+		}
+
+		var changes string
+		if len(e.Changes) > 0 {
+			raw, err := json.Marshal(e.Changes)
+			if err != nil {
+				return err
+			}
+			changes = string(raw)
+		}
+
+		err := cw.Write([]string{
+			strconv.FormatUint(e.ID, 10),
+			e.CreatedAt.Format(time.RFC3339),
+			strconv.FormatUint(uint64(e.Workspace), 10),
+			actor,
+			csvCell(e.Action),
+			csvCell(e.TargetType),
+			csvCell(e.TargetID),
+			csvCell(e.IPAddress),
+			csvCell(e.UserAgent),
+			csvCell(changes),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// csvCell keeps v from being taken for a formula, as anyone can set some values, like
+// the user agent.
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+
+	return v
+}
+
+// WriteJSON writes the events as a JSON array.
+func WriteJSON(w io.Writer, events []Event) error {
+	return json.NewEncoder(w).Encode(events)
+}
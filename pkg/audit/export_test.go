@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestWriteCSV(t *testing.T) {
+	events := []Event{{
+		ID:        1,
+		Workspace: 1,
+		Action:    "user.update",
+		TargetID:  "-1",
+		IPAddress: "127.0.0.1",
+		UserAgent: "=HYPERLINK(\"http://example.com\")",
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, events); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("Expected a header and 1 row, got %d rows", len(rows))
+	}
+
+	row := rows[1]
+	if row[6] != "'-1" {
+		t.Errorf("Expected the target ID to be quoted, got %q", row[6])
+	}
+
+	if row[8] != "'=HYPERLINK(\"http://example.com\")" {
+		t.Errorf("Expected the user agent to be quoted, got %q", row[8])
+	}
+
+	if row[4] != "user.update" || row[7] != "127.0.0.1" {
+		t.Errorf("Expected safe values to be left alone, got %q and %q", row[4], row[7])
+	}
+}
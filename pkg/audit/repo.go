@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Filter narrows down the events listed for a workspace. Zero values are ignored.
+type Filter struct {
+	Action     string
+	Actor      uint
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	// Before only lists events older than the event with this ID
+	Before uint64
+	Limit  int
+}
+
+type Repo struct {
+	db bun.IDB
+}
+
+func NewRepo(db bun.IDB) *Repo {
+	return &Repo{db}
+}
+
+// Record saves the event. Use a Repo created from the transaction making the change so
+// the event is only kept if the change is.
+func (r *Repo) Record(ctx context.Context, e *Event) error {
+	_, err := r.db.
+		NewInsert().
+		Model(e).
+		ExcludeColumn("id", "created_at").
+		Returning("*").
+		Exec(ctx)
+
+	return err
+}
+
+// List returns the workspace's events matching the filter, newest first.
+func (r *Repo) List(ctx context.Context, wkID uint, f Filter) ([]Event, error) {
+	events := []Event{}
+
+	q := r.db.
+		NewSelect().
+		Model(&events).
+		Where("workspace = ?", wkID).
+		Order("id DESC")
+
+	if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+
+	if f.Actor != 0 {
+		q = q.Where("actor = ?", f.Actor)
+	}
+
+	if f.TargetType != "" {
+		q = q.Where("target_type = ?", f.TargetType)
+	}
+
+	if f.TargetID != "" {
+		q = q.Where("target_id = ?", f.TargetID)
+	}
+
+	if !f.Since.IsZero() {
+		q = q.Where("created_at >= ?", f.Since)
+	}
+
+	if !f.Until.IsZero() {
+		q = q.Where("created_at < ?", f.Until)
+	}
+
+	if f.Before != 0 {
+		q = q.Where("id < ?", f.Before)
+	}
+
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+
+	err := q.Scan(ctx)
+
+	return events, err
+}
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"noxecane/go-starter/pkg/config"
+
+	"github.com/noxecane/anansi"
+	"github.com/uptrace/bun"
+)
+
+var testDB *bun.DB
+
+func afterEach(t *testing.T) {
+	// truncate skips the append-only trigger, which only fires for row deletes
+	if _, err := testDB.NewTruncateTable().Table("audit_events").Exec(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	var err error
+	var sqlDB *sql.DB
+
+	var env config.Env
+	if err = anansi.LoadEnv(&env); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(env.Name)
+
+	if sqlDB, testDB, err = config.SetupDB(env); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to postgres")
+
+	code := m.Run()
+
+	if err := sqlDB.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from postgres cleanly")
+	}
+
+	os.Exit(code)
+}
+
+func TestRepoRecord(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	actor := uint(1)
+	e := &Event{
+		Workspace:  1,
+		Actor:      &actor,
+		Action:     ActionWorkspaceRenamed,
+		TargetType: TargetWorkspace,
+		TargetID:   "1",
+		Changes:    Diff(map[string]string{"company_name": "Old"}, map[string]string{"company_name": "New"}),
+	}
+
+	if err := repo.Record(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.ID == 0 || e.CreatedAt.IsZero() {
+		t.Error("Expected event to be populated after record")
+	}
+
+	if _, err := testDB.NewUpdate().Model(e).Set("action = ?", ActionSlugChanged).WherePK().Exec(ctx); err == nil {
+		t.Error("Expected update of an audit event to fail")
+	}
+
+	if _, err := testDB.NewDelete().Model(e).WherePK().Exec(ctx); err == nil {
+		t.Error("Expected delete of an audit event to fail")
+	}
+}
+
+func TestRepoList(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	actions := []string{ActionUserInvited, ActionUserInvited, ActionTeamCreated}
+	for _, a := range actions {
+		if err := repo.Record(ctx, &Event{Workspace: 1, Action: a, TargetType: TargetUser, TargetID: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repo.Record(ctx, &Event{Workspace: 2, Action: ActionUserInvited, TargetType: TargetUser, TargetID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := repo.List(ctx, 1, Filter{Action: ActionUserInvited})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 invite events, got %d", len(events))
+	}
+
+	if events[0].ID < events[1].ID {
+		t.Error("Expected events to be listed newest first")
+	}
+
+	page, err := repo.List(ctx, 1, Filter{Before: events[0].ID, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page) != 1 || page[0].ID != events[1].ID {
+		t.Errorf("Expected next page to start at %d, got %v", events[1].ID, page)
+	}
+}
@@ -0,0 +1,19 @@
+// Package backoff spaces out retries of work that keeps failing.
+package backoff
+
+import "time"
+
+// Doubling is how long to wait before retrying something that has failed attempts
+// times, starting at first and doubling up to max.
+func Doubling(attempts int, first, max time.Duration) time.Duration {
+	wait := first
+	for i := 1; i < attempts && wait < max; i++ {
+		wait *= 2
+	}
+
+	if wait > max {
+		return max
+	}
+
+	return wait
+}
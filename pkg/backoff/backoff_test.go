@@ -0,0 +1,26 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDoubling(t *testing.T) {
+	cases := []struct {
+		attempts int
+		wait     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{5, 2 * time.Minute},
+		{100, 2 * time.Minute},
+	}
+
+	for _, tc := range cases {
+		if wait := Doubling(tc.attempts, 10*time.Second, 2*time.Minute); wait != tc.wait {
+			t.Errorf("Expected %d attempts to wait %v, got %v", tc.attempts, tc.wait, wait)
+		}
+	}
+}
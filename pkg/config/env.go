@@ -1,35 +1,107 @@
 package config
 
-// Env is the expected config values from the process's environment
+import (
+	"net/url"
+	"time"
+
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/ratelimit"
+	"noxecane/go-starter/pkg/secure"
+)
+
+// Env is the expected config values from the process's environment. Fields tagged
+// secret are redacted when the config is printed.
 type Env struct {
 	AppEnv string `default:"dev" split_words:"true"`
 	Name   string `required:"true"`
 	Port   int    `required:"true"`
 	Scheme string `required:"true"`
-	Secret []byte `required:"true"`
+	Secret []byte `secret:"true"`
+
+	// SigningKeys sign tokens and cursors, the first signing and the rest only
+	// verifying, so secrets can be rotated. Without it SECRET is the only key.
+	SigningKeys keyring.Keyring `split_words:"true"`
+
+	// PIIKeys are the master keys wrapping the keys personal data is encrypted with,
+	// the first wrapping new ones
+	PIIKeys keyring.Keyring `required:"true" split_words:"true"`
+
+	// CORSOrigins are the origins browsers can call the API from, dev allows any when
+	// there are none. TrustedProxies are the CIDRs of the proxies in front of the API,
+	// whose X-Forwarded-For is believed.
+	CORSOrigins    []string       `split_words:"true"`
+	TrustedProxies secure.Proxies `split_words:"true"`
+
+	// HSTSMaxAge of 0 leaves out Strict-Transport-Security, the CSP is only sent with
+	// HTML
+	HSTSMaxAge            time.Duration `default:"8760h" split_words:"true"`
+	ContentSecurityPolicy string        `default:"default-src 'none'; frame-ancestors 'none'" split_words:"true"`
+	ReferrerPolicy        string        `default:"no-referrer" split_words:"true"`
+
+	// MetricsPort serves prometheus metrics away from the API, 0 turns them off
+	MetricsPort int `default:"9090" split_words:"true"`
+
+	// TracingExporter is one of none, otlp or stdout
+	TracingExporter    string  `default:"none" split_words:"true"`
+	TracingSampleRatio float64 `default:"1" split_words:"true"`
+
+	PostgresHost       string        `required:"true" split_words:"true"`
+	PostgresPort       int           `required:"true" split_words:"true"`
+	PostgresPoolSize   int           `required:"true" split_words:"true"`
+	PostgresSecureMode bool          `required:"true" split_words:"true"`
+	PostgresUser       string        `required:"true" split_words:"true"`
+	PostgresPassword   string        `required:"true" split_words:"true" secret:"true"`
+	PostgresDatabase   string        `required:"true" split_words:"true"`
+	PostgresDebug      bool          `default:"false" split_words:"true"`
+	SlowQueryThreshold time.Duration `default:"200ms" split_words:"true"`
+	QueryBudget        int           `default:"50" split_words:"true"`
+	AutoMigrate        bool          `default:"true" split_words:"true"`
 
-	PostgresHost       string `required:"true" split_words:"true"`
-	PostgresPort       int    `required:"true" split_words:"true"`
-	PostgresPoolSize   int    `required:"true" split_words:"true"`
-	PostgresSecureMode bool   `required:"true" split_words:"true"`
-	PostgresUser       string `required:"true" split_words:"true"`
-	PostgresPassword   string `required:"true" split_words:"true"`
-	PostgresDatabase   string `required:"true" split_words:"true"`
-	PostgresDebug      bool   `default:"false" split_words:"true"`
+	MigrationLockTimeout time.Duration `default:"1m" split_words:"true"`
 
 	RedisHost     string `required:"true" split_words:"true"`
 	RedisPort     int    `required:"true" split_words:"true"`
-	RedisPassword string `default:"" split_words:"true"`
+	RedisPassword string `default:"" split_words:"true" secret:"true"`
 
-	SendgridKey     string `required:"true" split_words:"true"`
+	// IdempotencyTTL is how long responses are kept for retries with the same key
+	IdempotencyTTL time.Duration `default:"24h" split_words:"true"`
+
+	// RateLimits are the requests allowed by API key, workspace and IP, overridden by
+	// route group like invitations.ip. RateLimitFailOpen lets requests through when
+	// redis is down.
+	RateLimits        ratelimit.Rules `default:"key=600/1m,workspace=3000/1m,ip=600/1m,invitations.ip=60/1m" split_words:"true"`
+	RateLimitFailOpen bool            `default:"true" split_words:"true"`
+
+	SendgridKey     string `required:"true" split_words:"true" secret:"true"`
 	MailSender      string `required:"true" split_words:"true"`
 	NotifyEmail     string `required:"true" split_words:"true"`
 	PostmasterEmail string `required:"true" split_words:"true"`
 
-	SessionTimeout  string `required:"true" split_words:"true"`
-	HeadlessTimeout string `required:"true" split_words:"true"`
+	SessionTimeout  time.Duration `required:"true" split_words:"true"`
+	HeadlessTimeout time.Duration `required:"true" split_words:"true"`
+
+	HealthCheckTimeout time.Duration `default:"2s" split_words:"true"`
+	ShutdownDelay      time.Duration `default:"0s" split_words:"true"`
+
+	WorkspaceDomain        string        `default:"" split_words:"true"`
+	WorkspaceGracePeriod   time.Duration `default:"720h" split_words:"true"`
+	WorkspacePurgeSchedule string        `default:"@hourly" split_words:"true"`
+
+	InvitationGracePeriod     time.Duration `default:"168h" split_words:"true"`
+	InvitationCleanupSchedule string        `default:"@hourly" split_words:"true"`
+
+	WebhookSchedule string        `default:"@every 5s" split_words:"true"`
+	WebhookTimeout  time.Duration `default:"10s" split_words:"true"`
+	// WebhookAllowPrivate lets endpoints be on private and loopback addresses, for
+	// receivers running locally during development
+	WebhookAllowPrivate bool `default:"false" split_words:"true"`
+
+	WorkerConcurrency int `default:"4" split_words:"true"`
+
+	ClientOwnerPage url.URL `required:"true" split_words:"true"`
+	ClientUserPage  url.URL `required:"true" split_words:"true"`
+	ClientResetPage url.URL `required:"true" split_words:"true"`
+	ClientLoginPage url.URL `required:"true" split_words:"true"`
 
-	ClientOwnerPage string `required:"true" split_words:"true"`
-	ClientUserPage  string `required:"true" split_words:"true"`
-	ClientResetPage string `required:"true" split_words:"true"`
+	ClientTransferPage url.URL `required:"true" split_words:"true"`
 }
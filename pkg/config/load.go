@@ -0,0 +1,278 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"noxecane/go-starter/pkg/jobs"
+	"noxecane/go-starter/pkg/keyring"
+
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+)
+
+// FileSuffix names the variable holding the path of a file to read another's value
+// from, like SECRET_FILE for SECRET.
+const FileSuffix = "_FILE"
+
+const redacted = "[redacted]"
+
+// EnvError lists every variable that's missing or invalid, by name.
+type EnvError struct {
+	Errors ozzo.Errors
+}
+
+func (e *EnvError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("invalid config:")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\n  %s: %v", key, e.Errors[key])
+	}
+
+	return b.String()
+}
+
+// LoadEnv reads Env from the environment, after loading .env if there is one. Any
+// variable can be read from a file instead, like a mounted kubernetes secret, by
+// setting its name with FileSuffix to the file's path. Every variable that's missing
+// or invalid is reported at once, in an *EnvError.
+func LoadEnv() (Env, error) {
+	var env Env
+
+	if err := loadDotEnv(); err != nil {
+		return env, err
+	}
+
+	errs := ozzo.Errors{}
+	keys := make(map[string]string)
+
+	v := reflect.ValueOf(&env).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+
+		// one field at a time, so a bad variable doesn't hide the ones after it
+		one := reflect.New(reflect.StructOf([]reflect.StructField{field}))
+
+		key := envKey(one.Interface())
+		keys[field.Name] = key
+
+		if err := readFile(key); err != nil {
+			errs[key+FileSuffix] = err
+			continue
+		}
+
+		if err := envconfig.Process("", one.Interface()); err != nil {
+			errs[key] = loadError(err)
+			continue
+		}
+
+		v.Field(i).Set(one.Elem().Field(0))
+	}
+
+	// validation is by field name, and only reported for variables that could be read
+	var verrs ozzo.Errors
+	if err := env.Validate(); errors.As(err, &verrs) {
+		for name, err := range verrs {
+			if _, failed := errs[keys[name]]; !failed {
+				errs[keys[name]] = err
+			}
+		}
+	} else if err != nil {
+		return env, err
+	}
+
+	if len(errs) > 0 {
+		return env, &EnvError{errs}
+	}
+
+	// SECRET is the only key until SIGNING_KEYS takes over
+	if env.SigningKeys.Len() == 0 {
+		ring, err := keyring.New(keyring.Key{ID: keyring.LegacyID, Secret: env.Secret})
+		if err != nil {
+			return env, err
+		}
+		env.SigningKeys = ring
+	}
+
+	return env, nil
+}
+
+// loadDotEnv sets the variables in .env that aren't in the environment already,
+// either by themselves or as a file.
+func loadDotEnv() error {
+	vars, err := godotenv.Read()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for key, value := range vars {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+
+		if _, file := os.LookupEnv(key + FileSuffix); file {
+			continue
+		}
+
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// envKey is the variable envconfig reads the only field of spec from.
+func envKey(spec interface{}) string {
+	var b bytes.Buffer
+	_ = envconfig.Usagef("", spec, &b, "{{range .}}{{usage_key .}}{{end}}")
+
+	return b.String()
+}
+
+// readFile sets key to the contents of the file named by key with FileSuffix, unless
+// key is set already.
+func readFile(key string) error {
+	path, ok := os.LookupEnv(key + FileSuffix)
+	if !ok {
+		return nil
+	}
+
+	if _, set := os.LookupEnv(key); set {
+		return fmt.Errorf("can't be set along with %s", key)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// editors and kubectl like to end files with a newline
+	return os.Setenv(key, strings.TrimRight(string(raw), "\r\n"))
+}
+
+func loadError(err error) error {
+	var perr *envconfig.ParseError
+	if !errors.As(err, &perr) {
+		// envconfig only fails otherwise for missing variables
+		return errors.New("is required")
+	}
+
+	switch perr.TypeName {
+	case "time.Duration":
+		return fmt.Errorf("%q is not a duration like 30s or 1h", perr.Value)
+	case "int":
+		return fmt.Errorf("%q is not a whole number", perr.Value)
+	case "float64":
+		return fmt.Errorf("%q is not a number", perr.Value)
+	case "bool":
+		return fmt.Errorf("%q is not true or false", perr.Value)
+	default:
+		return perr.Err
+	}
+}
+
+// Validate checks the values that envconfig can't, returning ozzo.Errors by field
+// name.
+func (e *Env) Validate() error {
+	return ozzo.ValidateStruct(e,
+		ozzo.Field(&e.Port, ozzo.Required, ozzo.Max(65535)),
+		ozzo.Field(&e.Secret, ozzo.When(e.SigningKeys.Len() == 0, ozzo.Required.Error("is required without SIGNING_KEYS")), ozzo.Length(keyring.MinSecretLength, 0)),
+		ozzo.Field(&e.MetricsPort, ozzo.Min(0), ozzo.Max(65535)),
+		ozzo.Field(&e.TracingExporter, ozzo.In("none", "otlp", "stdout")),
+		ozzo.Field(&e.TracingSampleRatio, ozzo.Min(0.0), ozzo.Max(1.0)),
+		ozzo.Field(&e.HSTSMaxAge, ozzo.Min(0)),
+		ozzo.Field(&e.PostgresPort, ozzo.Max(65535)),
+		ozzo.Field(&e.PostgresPoolSize, ozzo.Required),
+		ozzo.Field(&e.SlowQueryThreshold, ozzo.Required),
+		ozzo.Field(&e.MigrationLockTimeout, ozzo.Required),
+		ozzo.Field(&e.RedisPort, ozzo.Max(65535)),
+		ozzo.Field(&e.IdempotencyTTL, ozzo.Required),
+		ozzo.Field(&e.NotifyEmail, is.EmailFormat),
+		ozzo.Field(&e.PostmasterEmail, is.EmailFormat),
+		ozzo.Field(&e.SessionTimeout, ozzo.Required),
+		ozzo.Field(&e.HeadlessTimeout, ozzo.Required),
+		ozzo.Field(&e.HealthCheckTimeout, ozzo.Required),
+		ozzo.Field(&e.ShutdownDelay, ozzo.Min(0)),
+		ozzo.Field(&e.WorkspaceGracePeriod, ozzo.Required),
+		ozzo.Field(&e.WorkspacePurgeSchedule, ozzo.By(schedule)),
+		ozzo.Field(&e.InvitationGracePeriod, ozzo.Min(0)),
+		ozzo.Field(&e.InvitationCleanupSchedule, ozzo.By(schedule)),
+		ozzo.Field(&e.WebhookSchedule, ozzo.By(schedule)),
+		ozzo.Field(&e.WebhookTimeout, ozzo.Required),
+		ozzo.Field(&e.WorkerConcurrency, ozzo.Required),
+		ozzo.Field(&e.ClientOwnerPage, ozzo.By(pageURL)),
+		ozzo.Field(&e.ClientUserPage, ozzo.By(pageURL)),
+		ozzo.Field(&e.ClientResetPage, ozzo.By(pageURL)),
+		ozzo.Field(&e.ClientLoginPage, ozzo.By(pageURL)),
+		ozzo.Field(&e.ClientTransferPage, ozzo.By(pageURL)),
+	)
+}
+
+func schedule(value interface{}) error {
+	_, err := jobs.ParseSchedule(value.(string))
+	return err
+}
+
+// pageURL checks the client's pages are full URLs, they're sent out in mails.
+func pageURL(value interface{}) error {
+	u := value.(url.URL)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be a full http or https URL")
+	}
+
+	return nil
+}
+
+// Print writes every variable and its value, as the app sees it, with secrets
+// redacted.
+func (e *Env) Print(w io.Writer) error {
+	v := reflect.ValueOf(e).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := envKey(reflect.New(reflect.StructOf([]reflect.StructField{field})).Interface())
+
+		value := formatValue(v.Field(i))
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = redacted
+		}
+
+		if _, err := fmt.Fprintf(w, "%s=%s\n", key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	if s, ok := v.Addr().Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	switch value := v.Interface().(type) {
+	case []byte:
+		return string(value)
+	case []string:
+		return strings.Join(value, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
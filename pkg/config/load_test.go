@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsetenv clears key for the test, putting it back after.
+func unsetenv(t *testing.T, key string) {
+	t.Setenv(key, "")
+	os.Unsetenv(key)
+}
+
+func TestLoadEnvErrors(t *testing.T) {
+	unsetenv(t, "NAME")
+	t.Setenv("SESSION_TIMEOUT", "soon")
+	t.Setenv("PORT", "eighty")
+	t.Setenv("CLIENT_LOGIN_PAGE", "/login")
+	t.Setenv("WEBHOOK_SCHEDULE", "@sometimes")
+	t.Setenv("RATE_LIMITS", "ip=many")
+
+	_, err := LoadEnv()
+
+	var envErr *EnvError
+	if !errors.As(err, &envErr) {
+		t.Fatalf("Expected an EnvError, got %v", err)
+	}
+
+	for _, key := range []string{"NAME", "SESSION_TIMEOUT", "PORT", "CLIENT_LOGIN_PAGE", "WEBHOOK_SCHEDULE", "RATE_LIMITS"} {
+		if envErr.Errors[key] == nil {
+			t.Errorf("Expected %s to be reported, got %v", key, err)
+		}
+	}
+
+	if len(envErr.Errors) != 6 {
+		t.Errorf("Expected only the bad variables to be reported, got %v", err)
+	}
+}
+
+func TestLoadEnvFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sendgrid_key")
+	if err := os.WriteFile(path, []byte("key-from-a-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	unsetenv(t, "SENDGRID_KEY")
+	t.Setenv("SENDGRID_KEY_FILE", path)
+	t.Setenv("WORKSPACE_GRACE_PERIOD", "48h")
+
+	env, err := LoadEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if env.SendgridKey != "key-from-a-file" {
+		t.Errorf("Expected the key to be read from the file, got %q", env.SendgridKey)
+	}
+
+	if env.WorkspaceGracePeriod != 48*time.Hour {
+		t.Errorf("Expected durations to be parsed, got %v", env.WorkspaceGracePeriod)
+	}
+
+	var out strings.Builder
+	if err := env.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "SENDGRID_KEY="+redacted+"\n") || strings.Contains(out.String(), "key-from-a-file") {
+		t.Errorf("Expected the key to be redacted, got\n%s", out.String())
+	}
+
+	if !strings.Contains(out.String(), "WORKSPACE_GRACE_PERIOD=48h0m0s\n") {
+		t.Errorf("Expected the grace period to be printed, got\n%s", out.String())
+	}
+
+	// one or the other
+	t.Setenv("SENDGRID_KEY", "key-from-the-environment")
+
+	var envErr *EnvError
+	if _, err := LoadEnv(); !errors.As(err, &envErr) || envErr.Errors["SENDGRID_KEY_FILE"] == nil {
+		t.Errorf("Expected setting both to be rejected, got %v", err)
+	}
+}
+
+func TestLoadEnvSigningKeys(t *testing.T) {
+	t.Setenv("SECRET", "a-secret-from-before-keys")
+	unsetenv(t, "SIGNING_KEYS")
+
+	env, err := LoadEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key := env.SigningKeys.Active(); env.SigningKeys.Len() != 1 || key.ID != "default" || string(key.Secret) != "a-secret-from-before-keys" {
+		t.Errorf("Expected SECRET to be the only key, got %v", env.SigningKeys)
+	}
+
+	t.Setenv("SIGNING_KEYS", "2024-10:the-newest-secret,default:a-secret-from-before-keys")
+
+	if env, err = LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.SigningKeys.Len() != 2 || env.SigningKeys.Active().ID != "2024-10" {
+		t.Errorf("Expected SIGNING_KEYS to be used, got %v", env.SigningKeys)
+	}
+
+	var out strings.Builder
+	if err := env.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "SIGNING_KEYS=2024-10:[redacted],default:[redacted]\n") || strings.Contains(out.String(), "the-newest-secret") {
+		t.Errorf("Expected the keys to be printed without their secrets, got\n%s", out.String())
+	}
+
+	unsetenv(t, "SECRET")
+	unsetenv(t, "SIGNING_KEYS")
+
+	var envErr *EnvError
+	if _, err := LoadEnv(); !errors.As(err, &envErr) || envErr.Errors["SECRET"] == nil {
+		t.Errorf("Expected a secret to be required, got %v", err)
+	}
+}
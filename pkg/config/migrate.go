@@ -0,0 +1,242 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+var ErrMigrationLocked = errors.New("timed out waiting for another migration to finish")
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.up\.sql$`)
+
+// migrationLockID is the advisory lock held while migrating, so replicas starting
+// together take turns instead of racing.
+var migrationLockID = func() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("noxecane/go-starter migrations"))
+	return int64(h.Sum64())
+}()
+
+// Migration is a pair of up and down SQL files in MigrationsDir.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaError is returned when the database isn't at the version this build of the
+// app expects.
+type SchemaError struct {
+	Version  uint
+	Expected uint
+	Dirty    bool
+}
+
+func (e *SchemaError) Error() string {
+	switch {
+	case e.Dirty:
+		return fmt.Sprintf("migration %d failed part way, fix it and run `migrate force`", e.Version)
+	case e.Version > e.Expected:
+		return fmt.Sprintf("schema is at version %d, ahead of the %d this build expects", e.Version, e.Expected)
+	default:
+		return fmt.Sprintf("schema is at version %d, behind the %d this build expects, run `migrate up`", e.Version, e.Expected)
+	}
+}
+
+// MigrationsDir is where the SQL migrations live.
+func MigrationsDir() string {
+	return filepath.Join(GetPackagePath(), "sql")
+}
+
+// Migrations lists the migrations in MigrationsDir, oldest first.
+func Migrations() ([]Migration, error) {
+	dir := MigrationsDir()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mx []Migration
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if match == nil {
+			continue
+		}
+
+		v, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		mx = append(mx, Migration{
+			Version: uint(v),
+			Name:    match[2],
+			Up:      filepath.Join(dir, e.Name()),
+			Down:    filepath.Join(dir, fmt.Sprintf("%s_%s.down.sql", match[1], match[2])),
+		})
+	}
+
+	sort.Slice(mx, func(i, j int) bool {
+		return mx[i].Version < mx[j].Version
+	})
+
+	return mx, nil
+}
+
+// ExpectedVersion is the version of the latest migration, the one this build needs.
+func ExpectedVersion() (uint, error) {
+	mx, err := Migrations()
+	if err != nil || len(mx) == 0 {
+		return 0, err
+	}
+
+	return mx[len(mx)-1].Version, nil
+}
+
+// NewMigrator creates a migrator for the migrations in MigrationsDir. It holds on to one
+// of the pool's connections until it's closed, closing it leaves the pool open.
+func NewMigrator(db *sql.DB) (*migrate.Migrate, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := postgres.WithConnection(context.Background(), conn, &postgres.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	uri := fmt.Sprintf("file:///%s", MigrationsDir())
+	mig, err := migrate.NewWithDatabaseInstance(uri, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+
+	return mig, nil
+}
+
+// SchemaVersion returns the version the database is at, 0 if it's never been migrated.
+func SchemaVersion(mig *migrate.Migrate) (uint, bool, error) {
+	version, dirty, err := mig.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+// CheckSchema returns a SchemaError unless the database is at the version this build
+// expects.
+func CheckSchema(db *sql.DB) error {
+	mig, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	defer mig.Close()
+
+	return checkSchema(mig, false)
+}
+
+func checkSchema(mig *migrate.Migrate, allowBehind bool) error {
+	version, dirty, err := SchemaVersion(mig)
+	if err != nil {
+		return err
+	}
+
+	expected, err := ExpectedVersion()
+	if err != nil {
+		return err
+	}
+
+	if dirty || version > expected || (version < expected && !allowBehind) {
+		return &SchemaError{Version: version, Expected: expected, Dirty: dirty}
+	}
+
+	return nil
+}
+
+// Migrate applies pending migrations, waiting up to timeout for any other migration to
+// finish first. It refuses to touch a schema that's ahead of this build.
+func Migrate(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	return WithMigrationLock(ctx, db, timeout, func() error {
+		mig, err := NewMigrator(db)
+		if err != nil {
+			return err
+		}
+		defer mig.Close()
+
+		if err := checkSchema(mig, true); err != nil {
+			return err
+		}
+
+		if err = mig.Up(); err != nil && err != migrate.ErrNoChange {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// WithMigrationLock runs fn while holding the migration lock, returning
+// ErrMigrationLocked if it can't be had within timeout.
+func WithMigrationLock(ctx context.Context, db *sql.DB, timeout time.Duration, fn func() error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", migrationLockID).Scan(&locked); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrMigrationLocked
+			}
+			return err
+		}
+
+		if locked {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrMigrationLocked
+			}
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	defer func() {
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+		if err != nil {
+			// the lock goes with the session, so don't give it back to the pool
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	return fn()
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/noxecane/anansi"
+	"github.com/uptrace/bun"
+)
+
+// scratchDB is a database of its own for migrating up and down, so other tests' data
+// isn't dropped along with the tables.
+var scratchDB *sql.DB
+
+func TestMain(m *testing.M) {
+	var err error
+	var adminDB *bun.DB
+
+	var env Env
+	if err = anansi.LoadEnv(&env); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(env.Name)
+
+	env.AutoMigrate = false
+	if _, adminDB, err = SetupDB(env); err != nil {
+		panic(err)
+	}
+
+	scratch := env.PostgresDatabase + "_migrations"
+	dropScratch := func() {
+		if _, err := adminDB.ExecContext(context.TODO(), "DROP DATABASE IF EXISTS ? WITH (FORCE)", bun.Ident(scratch)); err != nil {
+			log.Err(err).Msg("Failed to drop the scratch database")
+		}
+	}
+
+	dropScratch()
+	if _, err := adminDB.ExecContext(context.TODO(), "CREATE DATABASE ?", bun.Ident(scratch)); err != nil {
+		panic(err)
+	}
+
+	env.PostgresDatabase = scratch
+	if scratchDB, _, err = SetupDB(env); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to the scratch database")
+
+	code := m.Run()
+
+	if err := scratchDB.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from the scratch database cleanly")
+	}
+
+	dropScratch()
+
+	if err := adminDB.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from postgres cleanly")
+	}
+
+	os.Exit(code)
+}
+
+func TestMigrationsUpDownUp(t *testing.T) {
+	mig, err := NewMigrator(scratchDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mx, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mx) == 0 {
+		t.Fatal("Expected to find migrations")
+	}
+
+	for _, m := range mx {
+		if _, err := os.Stat(m.Down); err != nil {
+			t.Fatalf("Expected migration %d to have a down migration: %v", m.Version, err)
+		}
+
+		// a down that doesn't undo its up breaks the second up
+		for i, step := range []int{1, -1, 1} {
+			if err := mig.Steps(step); err != nil {
+				t.Fatalf("Expected step %d of migration %d %s to succeed, got %v", i+1, m.Version, m.Name, err)
+			}
+		}
+
+		version, dirty, err := SchemaVersion(mig)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if version != m.Version || dirty {
+			t.Fatalf("Expected to be at version %d after migrating, got %d (dirty: %v)", m.Version, version, dirty)
+		}
+	}
+
+	if err := CheckSchema(scratchDB); err != nil {
+		t.Errorf("Expected fully migrated schema to pass the check, got %v", err)
+	}
+
+	// and back down to nothing
+	if err := mig.Down(); err != nil {
+		t.Fatalf("Expected to roll back every migration, got %v", err)
+	}
+
+	// CI's pool only has two connections, and Migrate needs both
+	if _, err := mig.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var schemaErr *SchemaError
+	if err := CheckSchema(scratchDB); !errors.As(err, &schemaErr) || schemaErr.Version != 0 {
+		t.Errorf("Expected empty schema to be behind, got %v", err)
+	}
+
+	if err := Migrate(context.TODO(), scratchDB, time.Second); err != nil {
+		t.Fatalf("Expected to migrate all the way up, got %v", err)
+	}
+
+	if err := CheckSchema(scratchDB); err != nil {
+		t.Errorf("Expected migrated schema to pass the check, got %v", err)
+	}
+}
+
+func TestCheckSchemaAhead(t *testing.T) {
+	if err := Migrate(context.TODO(), scratchDB, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ExpectedVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mig, err := NewMigrator(scratchDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as if a newer build had migrated further
+	err = mig.Force(int(expected) + 1)
+	mig.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schemaErr *SchemaError
+	if err := CheckSchema(scratchDB); !errors.As(err, &schemaErr) || schemaErr.Version != expected+1 {
+		t.Errorf("Expected schema to be ahead, got %v", err)
+	}
+
+	if err := Migrate(context.TODO(), scratchDB, time.Second); !errors.As(err, &schemaErr) {
+		t.Errorf("Expected migrating a schema that's ahead to be refused, got %v", err)
+	}
+
+	if mig, err = NewMigrator(scratchDB); err != nil {
+		t.Fatal(err)
+	}
+	defer mig.Close()
+
+	if err := mig.Force(int(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithMigrationLock(t *testing.T) {
+	ctx := context.TODO()
+
+	release := make(chan struct{})
+	held := make(chan struct{})
+	done := make(chan error, 1)
+
+	go func() {
+		done <- WithMigrationLock(ctx, scratchDB, time.Second, func() error {
+			close(held)
+			<-release
+			return nil
+		})
+	}()
+
+	<-held
+
+	err := WithMigrationLock(ctx, scratchDB, 100*time.Millisecond, func() error {
+		return fmt.Errorf("should not run while the lock is held")
+	})
+	if !errors.Is(err, ErrMigrationLocked) {
+		t.Errorf("Expected to time out waiting for the lock, got %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ran := false
+	err = WithMigrationLock(ctx, scratchDB, time.Second, func() error {
+		ran = true
+		return nil
+	})
+	if err != nil || !ran {
+		t.Errorf("Expected to take the lock once it's released, got %v", err)
+	}
+}
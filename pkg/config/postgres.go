@@ -8,10 +8,6 @@ import (
 	"path/filepath"
 	"runtime"
 
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
@@ -34,29 +30,18 @@ func GetPackagePath() string {
 	return ""
 }
 
-func migrateDB(dir string, db *sql.DB) error {
-	var mig *migrate.Migrate
-	var driver database.Driver
-	var err error
-
-	if driver, err = postgres.WithInstance(db, &postgres.Config{}); err != nil {
-		return err
-	}
-
-	//
-	uri := fmt.Sprintf("file:///%s", dir)
-	if mig, err = migrate.NewWithDatabaseInstance(uri, "postgres", driver); err != nil {
-		return err
-	}
-
-	if err = mig.Up(); err != nil && err != migrate.ErrNoChange {
-		return err
+func SetupDB(env Env) (*sql.DB, *bun.DB, error) {
+	pool, err := SetupPool(env)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return OpenDB(env, pool)
 }
 
-func SetupDB(env Env) (*sql.DB, *bun.DB, error) {
+// SetupPool creates the pgx connection pool, for when it's needed on its own, say to
+// report its stats.
+func SetupPool(env Env) (*pgxpool.Pool, error) {
 	sslMode := "allow"
 	if env.PostgresSecureMode {
 		sslMode = "require"
@@ -69,14 +54,15 @@ func SetupDB(env Env) (*sql.DB, *bun.DB, error) {
 	)
 	config, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
-		return nil, nil, err
-	}
-	dbpool, err := pgxpool.NewWithConfig(context.Background(), config)
-	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	sqldb := stdlib.OpenDBFromPool(dbpool, stdlib.OptionBeforeConnect(func(ctx context.Context, cc *pgx.ConnConfig) error {
+	return pgxpool.NewWithConfig(context.Background(), config)
+}
+
+// OpenDB opens the database on top of pool, migrating it if the env says so.
+func OpenDB(env Env, pool *pgxpool.Pool) (*sql.DB, *bun.DB, error) {
+	sqldb := stdlib.OpenDBFromPool(pool, stdlib.OptionBeforeConnect(func(ctx context.Context, cc *pgx.ConnConfig) error {
 		if !env.PostgresSecureMode {
 			cc.TLSConfig = &tls.Config{InsecureSkipVerify: true}
 		}
@@ -92,7 +78,11 @@ func SetupDB(env Env) (*sql.DB, *bun.DB, error) {
 	sqldb.SetMaxOpenConns(maxOpenConns)
 	sqldb.SetMaxIdleConns(maxOpenConns)
 
-	if err := migrateDB(filepath.Join(GetPackagePath(), "sql"), sqldb); err != nil {
+	if !env.AutoMigrate {
+		return sqldb, db, nil
+	}
+
+	if err := Migrate(context.Background(), sqldb, env.MigrationLockTimeout); err != nil {
 		return sqldb, db, err
 	}
 
@@ -0,0 +1,178 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// Check reports whether a dependency can be used, failing once ctx is done.
+type Check func(ctx context.Context) error
+
+// Checker is a named check on one of the app's dependencies.
+type Checker struct {
+	Name  string
+	Check Check
+	// Timeout overrides the registry's timeout for this check
+	Timeout time.Duration
+	// Optional checks show up in the details without failing readiness
+	Optional bool
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Optional  bool    `json:"optional,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Registry holds the checks the app is only ready when passing.
+type Registry struct {
+	timeout  time.Duration
+	mu       sync.RWMutex
+	checkers []Checker
+	stopping atomic.Bool
+}
+
+// NewRegistry creates a registry whose checks fail after timeout unless they set their
+// own.
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{timeout: timeout}
+}
+
+// Register adds a check readiness depends on.
+func (r *Registry) Register(c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checkers = append(r.checkers, c)
+}
+
+// Shutdown fails readiness from now on, so traffic moves elsewhere while the server
+// drains.
+func (r *Registry) Shutdown() {
+	r.stopping.Store(true)
+}
+
+// Run runs every check at once, each within its own timeout.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checkers := append([]Checker(nil), r.checkers...)
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checkers))
+
+	var wg sync.WaitGroup
+	for i, c := range checkers {
+		wg.Add(1)
+		go func(i int, c Checker) {
+			defer wg.Done()
+			results[i] = r.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, res := range results {
+		if res.Status != StatusOK && !res.Optional {
+			report.Status = StatusFailing
+		}
+	}
+
+	if r.stopping.Load() {
+		report.Status = StatusFailing
+	}
+
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, c Checker) Result {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = r.timeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	// checks that ignore the context still can't hold up the probe
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := Result{
+		Name:      c.Name,
+		Status:    StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Optional:  c.Optional,
+	}
+
+	if err != nil {
+		res.Status = StatusFailing
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// Live reports that the process is up, without looking at its dependencies.
+func Live() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		// we don't have a plan for when writes fail
+		_, _ = w.Write([]byte(StatusOK))
+	}
+}
+
+// Ready runs the registry's checks, failing with 503 if any required check fails or the
+// server is shutting down. Add ?verbose for the result of each check as JSON.
+func Ready(r *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		report := r.Run(req.Context())
+
+		code := http.StatusOK
+		if report.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+
+		if _, verbose := req.URL.Query()["verbose"]; verbose {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			_ = json.NewEncoder(w).Encode(report)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(report.Status))
+	}
+}
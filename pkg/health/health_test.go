@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func ok(context.Context) error { return nil }
+
+func ready(t *testing.T, r *Registry, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	Ready(r).ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return w
+}
+
+func TestReady(t *testing.T) {
+	r := NewRegistry(time.Second)
+	r.Register(Checker{Name: "postgres", Check: ok})
+	r.Register(Checker{Name: "mail", Check: func(context.Context) error { return errors.New("unreachable") }, Optional: true})
+
+	if w := ready(t, r, "/readyz"); w.Code != http.StatusOK || w.Body.String() != StatusOK {
+		t.Errorf("Expected optional failures to leave the app ready, got %d %q", w.Code, w.Body.String())
+	}
+
+	r.Register(Checker{Name: "redis", Check: func(context.Context) error { return errors.New("connection refused") }})
+
+	w := ready(t, r, "/readyz?verbose")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a failing check to fail readiness, got %d", w.Code)
+	}
+
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != StatusFailing || len(report.Checks) != 3 {
+		t.Fatalf("Expected a failing report of 3 checks, got %+v", report)
+	}
+
+	redis := report.Checks[2]
+	if redis.Name != "redis" || redis.Status != StatusFailing || redis.Error != "connection refused" {
+		t.Errorf("Expected redis check to report its error, got %+v", redis)
+	}
+
+	if mail := report.Checks[1]; !mail.Optional || mail.Status != StatusFailing {
+		t.Errorf("Expected mail check to be reported as optional and failing, got %+v", mail)
+	}
+}
+
+func TestReadyTimeout(t *testing.T) {
+	r := NewRegistry(50 * time.Millisecond)
+
+	// ignores its context, like a driver stuck on a dead connection
+	r.Register(Checker{Name: "stuck", Check: func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}})
+	r.Register(Checker{Name: "slow", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, Timeout: 10 * time.Millisecond})
+
+	start := time.Now()
+	report := r.Run(context.TODO())
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected checks to give up after their timeout, took %v", elapsed)
+	}
+
+	for _, res := range report.Checks {
+		if res.Status != StatusFailing || res.Error != context.DeadlineExceeded.Error() {
+			t.Errorf("Expected %s check to time out, got %+v", res.Name, res)
+		}
+	}
+
+	if slow := report.Checks[1]; slow.LatencyMs >= 50 {
+		t.Errorf("Expected slow check to use its own timeout, took %vms", slow.LatencyMs)
+	}
+}
+
+func TestReadyShutdown(t *testing.T) {
+	r := NewRegistry(time.Second)
+	r.Register(Checker{Name: "postgres", Check: ok})
+
+	r.Shutdown()
+
+	if w := ready(t, r, "/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness to fail while shutting down, got %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	Live().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected liveness to hold while shutting down, got %d", w.Code)
+	}
+}
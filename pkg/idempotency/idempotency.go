@@ -0,0 +1,284 @@
+// Package idempotency lets clients retry requests that change things without doing
+// them twice, by sending the same Idempotency-Key header with every attempt.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"noxecane/go-starter/pkg/problem"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+
+	// maxKeyLength is plenty for a UUID or anything else sensible
+	maxKeyLength = 255
+
+	// maxBodySize is as much of a body as is kept in memory to fingerprint
+	maxBodySize = 1 << 20
+)
+
+// pendingTTL is how long a key stays locked by a request that never finished,
+// because its server died. Requests still running keep extending it.
+var pendingTTL = time.Minute
+
+// extendScript locks the key for another ARGV[2] milliseconds, as long as it still
+// holds the pending record in ARGV[1].
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var (
+	ErrInvalidKey = problem.New(http.StatusBadRequest, "invalid_idempotency_key", "The Idempotency-Key header can't be longer than 255 characters")
+	ErrInProgress = problem.New(http.StatusConflict, "request_in_progress", "A request with this Idempotency-Key is still in progress")
+	ErrKeyReused  = problem.New(http.StatusUnprocessableEntity, "idempotency_key_reused", "This Idempotency-Key was used for a different request")
+	ErrTooLarge   = problem.New(http.StatusRequestEntityTooLarge, "body_too_large", "Requests with an Idempotency-Key can't be larger than 1MB")
+)
+
+// record is what's kept of a request under its key, the response once it's done.
+type record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Middleware makes POST, PUT, PATCH and DELETE requests that carry an Idempotency-Key
+// idempotent. The response to the first request with a key is kept for ttl and
+// replayed to any retry, marked by the Idempotent-Replayed header. Retries that come
+// while the first is still running are rejected with a 409, and reusing a key for a
+// different request with a 422.
+//
+// Keys are scoped to the Authorization header, so clients can't see each other's
+// responses. Requests that panic or fail with a 5xx don't keep theirs, so they can be
+// retried, which means it has to sit outside the recoverer to keep 4xx responses.
+func Middleware(client *redis.Client, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" || !mutating(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxKeyLength {
+				problem.Write(r, w, ErrInvalidKey)
+				return
+			}
+
+			var tooLarge *http.MaxBytesError
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			if errors.As(err, &tooLarge) {
+				problem.Write(r, w, ErrTooLarge)
+				return
+			} else if err != nil {
+				problem.Write(r, w, problem.BadRequest.Wrap(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			rKey := redisKey(r, key)
+			fingerprint := fingerprint(r, body)
+
+			pending, err := json.Marshal(record{Fingerprint: fingerprint})
+			if err != nil {
+				problem.Write(r, w, problem.Internal.Wrap(err))
+				return
+			}
+
+			existing, err := lock(ctx, client, rKey, pending)
+			if err != nil {
+				problem.Write(r, w, problem.Internal.Wrap(err))
+				return
+			}
+
+			if existing != nil {
+				switch {
+				case existing.Fingerprint != fingerprint:
+					problem.Write(r, w, ErrKeyReused)
+				case !existing.Done:
+					problem.Write(r, w, ErrInProgress)
+				default:
+					replay(w, existing)
+				}
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			buf := new(bytes.Buffer)
+			ww.Tee(buf)
+
+			kept := false
+			defer func() {
+				if kept {
+					return
+				}
+
+				// let the next attempt try again, even if the request was cancelled
+				if err := client.Del(context.WithoutCancel(ctx), rKey).Err(); err != nil {
+					zerolog.Ctx(r.Context()).Err(err).Str("idempotency_key", key).Msg("failed to unlock idempotency key")
+				}
+			}()
+
+			stop := hold(ctx, client, rKey, pending)
+			defer stop()
+
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			if status >= http.StatusInternalServerError {
+				return
+			}
+
+			done := record{
+				Fingerprint: fingerprint,
+				Done:        true,
+				Status:      status,
+				Header:      keptHeaders(ww.Header()),
+				Body:        buf.Bytes(),
+			}
+
+			raw, err := json.Marshal(done)
+			if err == nil {
+				err = client.Set(context.WithoutCancel(ctx), rKey, raw, ttl).Err()
+			}
+
+			if err != nil {
+				zerolog.Ctx(r.Context()).Err(err).Str("idempotency_key", key).Msg("failed to save idempotent response")
+				return
+			}
+
+			kept = true
+		})
+	}
+}
+
+// lock claims the key for this request with its pending record, returning what's
+// already there if another request got to it first.
+func lock(ctx context.Context, client *redis.Client, key string, pending []byte) (*record, error) {
+	// the other request's record can expire between the two calls
+	for {
+		ok, err := client.SetNX(ctx, key, pending, pendingTTL).Result()
+		if err != nil {
+			return nil, err
+		} else if ok {
+			return nil, nil
+		}
+
+		raw, err := client.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var existing record
+		if err := json.Unmarshal(raw, &existing); err != nil {
+			return nil, err
+		}
+
+		return &existing, nil
+	}
+}
+
+// hold keeps the key locked while the request runs, however long it takes, until the
+// function it returns is called. Once the response has replaced the pending record
+// the key is left alone.
+func hold(ctx context.Context, client *redis.Client, key string, pending []byte) func() {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(pendingTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := extendScript.Run(ctx, client, []string{key}, pending, pendingTTL.Milliseconds()).Err()
+				if err != nil && !errors.Is(err, context.Canceled) {
+					zerolog.Ctx(ctx).Err(err).Msg("failed to extend idempotency key")
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func replay(w http.ResponseWriter, rec *record) {
+	for k, vs := range rec.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.Header().Set(ReplayedHeader, "true")
+
+	w.WriteHeader(rec.Status)
+	_, _ = w.Write(rec.Body)
+}
+
+// keptHeaders are the headers that describe the body, anything else belongs to the
+// original response.
+func keptHeaders(h http.Header) http.Header {
+	kept := make(http.Header)
+	for _, k := range []string{"Content-Type", "Content-Disposition", "Location"} {
+		if v := h.Values(k); len(v) > 0 {
+			kept[k] = v
+		}
+	}
+
+	return kept
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func redisKey(r *http.Request, key string) string {
+	scope := sha256.Sum256([]byte(r.Header.Get("Authorization")))
+	return "idempotency:" + hex.EncodeToString(scope[:]) + ":" + key
+}
+
+// fingerprint tells requests apart, so a key can't be reused for another one.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,227 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/noxecane/anansi"
+	"github.com/redis/go-redis/v9"
+)
+
+var testRedis *redis.Client
+
+type testEnv struct {
+	Name          string `default:"go-starter"`
+	RedisHost     string `required:"true" split_words:"true"`
+	RedisPort     int    `required:"true" split_words:"true"`
+	RedisPassword string `default:"" split_words:"true"`
+}
+
+func afterEach(t *testing.T) {
+	ctx := context.TODO()
+
+	keys, err := testRedis.Keys(ctx, "idempotency:*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) > 0 {
+		if err := testRedis.Del(ctx, keys...).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	var e testEnv
+	if err := anansi.LoadEnv(&e); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(e.Name)
+
+	testRedis = redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", e.RedisHost, e.RedisPort),
+		Password: e.RedisPassword,
+	})
+	if err := testRedis.Ping(context.TODO()).Err(); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to redis")
+
+	code := m.Run()
+
+	if err := testRedis.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from redis cleanly")
+	}
+
+	os.Exit(code)
+}
+
+func send(h http.Handler, method, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/invitations", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer alice")
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	return res
+}
+
+func TestMiddleware(t *testing.T) {
+	defer afterEach(t)
+
+	var calls int32
+	h := Middleware(testRedis, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	}))
+
+	first := send(h, http.MethodPost, "k1", `{"email":"a@b.c"}`)
+	retry := send(h, http.MethodPost, "k1", `{"email":"a@b.c"}`)
+
+	if calls != 1 {
+		t.Fatalf("Expected the handler to run once, ran %d times", calls)
+	}
+
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected the first response to be replayed, got %d %s", retry.Code, retry.Body)
+	}
+
+	if retry.Header().Get(ReplayedHeader) != "true" || retry.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the replay to be marked and keep its content type, got %v", retry.Header())
+	}
+
+	if res := send(h, http.MethodPost, "k1", `{"email":"x@y.z"}`); res.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected reusing the key for another request to fail, got %d", res.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/invitations?notify=false", strings.NewReader(`{"email":"a@b.c"}`))
+	req.Header.Set("Authorization", "Bearer alice")
+	req.Header.Set(Header, "k1")
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected reusing the key with another query to fail, got %d", res.Code)
+	}
+
+	send(h, http.MethodPost, "", `{}`)
+	send(h, http.MethodGet, "k2", ``)
+	if calls != 3 {
+		t.Errorf("Expected requests without keys or that don't change anything to run, ran %d times", calls)
+	}
+}
+
+func TestMiddlewareInProgress(t *testing.T) {
+	defer afterEach(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := Middleware(testRedis, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- send(h, http.MethodPost, "k1", `{}`)
+	}()
+
+	<-started
+	if res := send(h, http.MethodPost, "k1", `{}`); res.Code != http.StatusConflict {
+		t.Errorf("Expected a concurrent retry to be rejected, got %d", res.Code)
+	}
+
+	close(release)
+	if res := <-done; res.Code != http.StatusOK {
+		t.Errorf("Expected the first request to finish, got %d", res.Code)
+	}
+}
+
+func TestMiddlewareSlowRequest(t *testing.T) {
+	defer afterEach(t)
+
+	prev := pendingTTL
+	pendingTTL = 300 * time.Millisecond
+	defer func() { pendingTTL = prev }()
+
+	started := make(chan struct{})
+	h := Middleware(testRedis, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		time.Sleep(time.Second)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- send(h, http.MethodPost, "k1", `{}`)
+	}()
+
+	// the lock has been held for longer than pendingTTL
+	<-started
+	time.Sleep(700 * time.Millisecond)
+	if res := send(h, http.MethodPost, "k1", `{}`); res.Code != http.StatusConflict {
+		t.Errorf("Expected a retry of a slow request to be rejected, got %d", res.Code)
+	}
+
+	if res := <-done; res.Code != http.StatusOK {
+		t.Errorf("Expected the first request to finish, got %d", res.Code)
+	}
+
+	// the response is kept for the full ttl, not the pending one
+	req := httptest.NewRequest(http.MethodPost, "/invitations", nil)
+	req.Header.Set("Authorization", "Bearer alice")
+	if ttl := testRedis.TTL(context.TODO(), redisKey(req, "k1")).Val(); ttl < time.Second {
+		t.Errorf("Expected the response to be kept for a minute, got %v", ttl)
+	}
+}
+
+func TestMiddlewareFailures(t *testing.T) {
+	defer afterEach(t)
+
+	var calls int32
+	h := Middleware(testRedis, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	func() {
+		defer func() { _ = recover() }()
+		send(h, http.MethodPost, "k1", `{}`)
+	}()
+
+	send(h, http.MethodPost, "k1", `{}`)
+	send(h, http.MethodPost, "k1", `{}`)
+
+	if calls != 3 {
+		t.Errorf("Expected requests that panic or fail with a 5xx to be retried, ran %d times", calls)
+	}
+
+	if res := send(h, http.MethodPost, strings.Repeat("k", 256), `{}`); res.Code != http.StatusBadRequest {
+		t.Errorf("Expected overly long keys to be rejected, got %d", res.Code)
+	}
+
+	if res := send(h, http.MethodPost, "k2", strings.Repeat("x", maxBodySize+1)); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected overly large bodies to be rejected, got %d", res.Code)
+	}
+
+	if calls != 3 {
+		t.Errorf("Expected rejected requests not to run, ran %d times", calls)
+	}
+}
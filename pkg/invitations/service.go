@@ -1,23 +1,26 @@
 package invitations
 
 import (
+	"context"
 	"fmt"
 
 	"noxecane/go-starter/pkg/notification"
 )
 
-func SendInvitation(mailer notification.Mailer, route string, iv Invitation) error {
+func SendInvitation(ctx context.Context, mailer notification.Mailer, route string, iv Invitation) error {
 	data := struct {
 		Route       string
 		Token       string
 		CompanyName string
+		Slug        string
 	}{
 		route,
 		iv.Token,
 		iv.CompanyName,
+		iv.Slug,
 	}
 
-	return mailer.Send(notification.TemplateMail{
+	return mailer.Send(ctx, notification.TemplateMail{
 		Sender:        notification.SenderPostmaster,
 		Subject:       fmt.Sprintf("Invitation to %s", iv.CompanyName),
 		ReceiverName:  "",
@@ -26,3 +29,26 @@ func SendInvitation(mailer notification.Mailer, route string, iv Invitation) err
 		TemplateData:  data,
 	})
 }
+
+// SendMembership lets a user who already has an account know they've been added to
+// another workspace.
+func SendMembership(ctx context.Context, mailer notification.Mailer, route string, iv Invitation) error {
+	data := struct {
+		Route       string
+		CompanyName string
+		Slug        string
+	}{
+		route,
+		iv.CompanyName,
+		iv.Slug,
+	}
+
+	return mailer.Send(ctx, notification.TemplateMail{
+		Sender:        notification.SenderPostmaster,
+		Subject:       fmt.Sprintf("You've been added to %s", iv.CompanyName),
+		ReceiverName:  "",
+		ReceiverEmail: iv.EmailAddress,
+		Template:      "membership",
+		TemplateData:  data,
+	})
+}
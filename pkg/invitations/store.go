@@ -2,16 +2,24 @@ package invitations
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"noxecane/go-starter/pkg/metrics"
+	"noxecane/go-starter/pkg/workspaces"
+
 	"github.com/noxecane/anansi/tokens"
 )
 
 var ErrExpired = tokens.ErrTokenNotFound
 
+// TTL is how long an invitation can be accepted for.
+const TTL = time.Hour * 48
+
 type Invitation struct {
 	Workspace    uint   `json:"workspace"`
 	CompanyName  string `json:"company_name"`
+	Slug         string `json:"slug"`
 	EmailAddress string `json:"email_address"`
 	Token        string `json:"token"`
 }
@@ -24,19 +32,22 @@ func NewStore(tStore tokens.Store) *Store {
 	return &Store{tStore}
 }
 
-func (s *Store) Create(ctx context.Context, wkpID uint, wkpName string, email string) (Invitation, error) {
+func (s *Store) Create(ctx context.Context, wk *workspaces.Workspace, email string) (Invitation, error) {
 	iv := Invitation{
-		Workspace:    wkpID,
-		CompanyName:  wkpName,
+		Workspace:    wk.ID,
+		CompanyName:  wk.CompanyName,
+		Slug:         wk.Slug,
 		EmailAddress: email,
 	}
 
 	var err error
-	iv.Token, err = s.tStore.Commission(ctx, time.Hour*48, email, iv)
+	iv.Token, err = s.tStore.Commission(ctx, TTL, key(wk.ID, email), iv)
 	if err != nil {
 		return Invitation{}, err
 	}
 
+	metrics.InvitationsCreated.Inc()
+
 	return iv, err
 }
 
@@ -54,6 +65,13 @@ func (s *Store) View(ctx context.Context, token string) (Invitation, error) {
 	return iv, err
 }
 
-func (s *Store) Revoke(ctx context.Context, key string) error {
-	return s.tStore.Revoke(ctx, key)
+// Revoke ends the workspace's invitation to email, leaving those from other workspaces.
+func (s *Store) Revoke(ctx context.Context, wkID uint, email string) error {
+	return s.tStore.Revoke(ctx, key(wkID, email))
+}
+
+// key is what an invitation is made for, so every workspace can invite the same
+// email without replacing the others' invitations.
+func key(wkID uint, email string) string {
+	return fmt.Sprintf("invitation:%d:%s", wkID, email)
 }
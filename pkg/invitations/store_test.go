@@ -0,0 +1,94 @@
+package invitations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"noxecane/go-starter/pkg/workspaces"
+
+	"github.com/noxecane/anansi"
+	"github.com/noxecane/anansi/tokens"
+	"github.com/redis/go-redis/v9"
+)
+
+var testRedis *redis.Client
+
+type testEnv struct {
+	Name          string `default:"go-starter"`
+	RedisHost     string `required:"true" split_words:"true"`
+	RedisPort     int    `required:"true" split_words:"true"`
+	RedisPassword string `default:"" split_words:"true"`
+}
+
+func TestMain(m *testing.M) {
+	var e testEnv
+	if err := anansi.LoadEnv(&e); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(e.Name)
+
+	testRedis = redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", e.RedisHost, e.RedisPort),
+		Password: e.RedisPassword,
+	})
+	if err := testRedis.Ping(context.TODO()).Err(); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to redis")
+
+	code := m.Run()
+
+	if err := testRedis.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from redis cleanly")
+	}
+
+	os.Exit(code)
+}
+
+func TestStoreWorkspaces(t *testing.T) {
+	ctx := context.TODO()
+	store := NewStore(tokens.NewStore(testRedis, []byte("the-invitations-secret")))
+	email := "grace@example.com"
+
+	first := &workspaces.Workspace{ID: 1, CompanyName: "First", Slug: "first"}
+	second := &workspaces.Workspace{ID: 2, CompanyName: "Second", Slug: "second"}
+
+	t.Cleanup(func() {
+		_ = store.Revoke(ctx, first.ID, email)
+		_ = store.Revoke(ctx, second.ID, email)
+	})
+
+	firstIv, err := store.Create(ctx, first, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondIv, err := store.Create(ctx, second, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// inviting the same email elsewhere leaves the first invitation alone
+	for _, iv := range []Invitation{firstIv, secondIv} {
+		viewed, err := store.View(ctx, iv.Token)
+		if err != nil || viewed.Workspace != iv.Workspace {
+			t.Errorf("Expected the invitation to workspace %d, got %+v %v", iv.Workspace, viewed, err)
+		}
+	}
+
+	if err := store.Revoke(ctx, first.ID, email); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.View(ctx, firstIv.Token); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected the revoked invitation to be gone, got %v", err)
+	}
+
+	if viewed, err := store.View(ctx, secondIv.Token); err != nil || viewed.Workspace != second.ID {
+		t.Errorf("Expected the other workspace's invitation to survive, got %+v %v", viewed, err)
+	}
+}
@@ -0,0 +1,273 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyQueue  = "jobs:queue"
+	keyActive = "jobs:active"
+	keyDead   = "jobs:dead"
+	keyData   = "jobs:data:"
+
+	defaultMaxAttempts = 5
+
+	// deadTTL is how long jobs that ran out of attempts are kept for looking into
+	deadTTL = 7 * 24 * time.Hour
+)
+
+// fetchScript returns jobs whose visibility timeout has run out to the queue, then
+// moves the next due job to the active set until its new deadline.
+var fetchScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for _, id in ipairs(expired) do
+  redis.call('ZREM', KEYS[2], id)
+  redis.call('ZADD', KEYS[1], ARGV[1], id)
+end
+
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 1)
+if #ids == 0 then
+  return false
+end
+
+redis.call('ZREM', KEYS[1], ids[1])
+redis.call('ZADD', KEYS[2], ARGV[2], ids[1])
+return ids[1]
+`)
+
+// Envelope is a job as it's stored in redis.
+type Envelope struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	EnqueuedAt  time.Time       `json:"enqueued_at"`
+	Error       string          `json:"error,omitempty"`
+}
+
+type options struct {
+	runAt       time.Time
+	maxAttempts int
+}
+
+// Option changes how a job is enqueued.
+type Option func(*options)
+
+// Delay runs the job after d instead of straight away.
+func Delay(d time.Duration) Option {
+	return func(o *options) {
+		o.runAt = time.Now().Add(d)
+	}
+}
+
+// At runs the job at t instead of straight away.
+func At(t time.Time) Option {
+	return func(o *options) {
+		o.runAt = t
+	}
+}
+
+// MaxAttempts sets how many times the job is tried before it's given up on.
+func MaxAttempts(n int) Option {
+	return func(o *options) {
+		o.maxAttempts = n
+	}
+}
+
+// Queue holds jobs waiting to be run by a Worker.
+type Queue struct {
+	redis *redis.Client
+}
+
+func NewQueue(client *redis.Client) *Queue {
+	return &Queue{client}
+}
+
+// Enqueue adds a job to the queue, returning its ID. Prefer Job.Enqueue which checks
+// the payload's type.
+func (q *Queue) Enqueue(ctx context.Context, name string, payload interface{}, opts ...Option) (string, error) {
+	o := options{runAt: time.Now(), maxAttempts: defaultMaxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	env := Envelope{
+		ID:          hex.EncodeToString(id),
+		Name:        name,
+		Payload:     raw,
+		MaxAttempts: o.maxAttempts,
+		EnqueuedAt:  time.Now(),
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = q.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, keyData+env.ID, data, 0)
+		p.ZAdd(ctx, keyQueue, redis.Z{Score: score(o.runAt), Member: env.ID})
+		return nil
+	})
+
+	return env.ID, err
+}
+
+// Dead returns jobs that ran out of attempts, most recent first. They're kept for
+// a week.
+func (q *Queue) Dead(ctx context.Context, limit int64) ([]Envelope, error) {
+	ids, err := q.redis.ZRevRange(ctx, keyDead, 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Envelope
+	for _, id := range ids {
+		env, err := q.get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if env != nil {
+			jobs = append(jobs, *env)
+		}
+	}
+
+	return jobs, nil
+}
+
+// fetch claims the next due job, hiding it from other workers for the visibility
+// timeout. Returns nil if nothing is due.
+func (q *Queue) fetch(ctx context.Context, visibility time.Duration) (*Envelope, error) {
+	now := time.Now()
+
+	id, err := fetchScript.Run(ctx, q.redis,
+		[]string{keyQueue, keyActive},
+		score(now), score(now.Add(visibility)),
+	).Text()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	env, err := q.get(ctx, id)
+	if err != nil {
+		return nil, err
+	} else if env == nil {
+		// the job's data is gone, so there's nothing to run or retry
+		return nil, q.redis.ZRem(ctx, keyActive, id).Err()
+	}
+
+	// count the attempt before it runs, so a worker dying mid-job still uses one up
+	env.Attempts++
+	if err := q.save(ctx, q.redis, env, 0); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+func (q *Queue) get(ctx context.Context, id string) (*Envelope, error) {
+	data, err := q.redis.Get(ctx, keyData+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	env := new(Envelope)
+	err = json.Unmarshal(data, env)
+
+	return env, err
+}
+
+// save stores the job's data, for ttl or for good when it's 0.
+func (q *Queue) save(ctx context.Context, c redis.Cmdable, env *Envelope, ttl time.Duration) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return c.Set(ctx, keyData+env.ID, data, ttl).Err()
+}
+
+// ack removes a job that has completed.
+func (q *Queue) ack(ctx context.Context, env *Envelope) error {
+	_, err := q.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZRem(ctx, keyActive, env.ID)
+		p.Del(ctx, keyData+env.ID)
+		return nil
+	})
+
+	return err
+}
+
+// retry puts a failed job back on the queue to run at runAt.
+func (q *Queue) retry(ctx context.Context, env *Envelope, runAt time.Time) error {
+	_, err := q.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if err := q.save(ctx, p, env, 0); err != nil {
+			return err
+		}
+		p.ZRem(ctx, keyActive, env.ID)
+		p.ZAdd(ctx, keyQueue, redis.Z{Score: score(runAt), Member: env.ID})
+		return nil
+	})
+
+	return err
+}
+
+// bury moves a job that has run out of attempts to the dead set, dropping the ones
+// buried longer than deadTTL ago whose data has expired.
+func (q *Queue) bury(ctx context.Context, env *Envelope) error {
+	now := time.Now()
+
+	_, err := q.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if err := q.save(ctx, p, env, deadTTL); err != nil {
+			return err
+		}
+		p.ZRem(ctx, keyActive, env.ID)
+		p.ZAdd(ctx, keyDead, redis.Z{Score: score(now), Member: env.ID})
+		p.ZRemRangeByScore(ctx, keyDead, "-inf", strconv.FormatFloat(score(now.Add(-deadTTL)), 'f', 0, 64))
+		return nil
+	})
+
+	return err
+}
+
+func score(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+// Job is a kind of job whose payloads are of type T.
+type Job[T any] struct {
+	Name string
+}
+
+func NewJob[T any](name string) Job[T] {
+	return Job[T]{name}
+}
+
+// Enqueue adds a job with the payload to the queue, returning its ID.
+func (j Job[T]) Enqueue(ctx context.Context, q *Queue, payload T, opts ...Option) (string, error) {
+	return q.Enqueue(ctx, j.Name, payload, opts...)
+}
@@ -0,0 +1,52 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	start := time.Date(2023, time.March, 15, 10, 7, 30, 0, time.UTC)
+
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"*/15 * * * *", time.Date(2023, time.March, 15, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2023, time.March, 15, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2023, time.March, 16, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2023, time.March, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2023, time.March, 15, 11, 0, 0, 0, time.UTC)},
+		{"@every 90s", start.Add(90 * time.Second)},
+	}
+
+	for _, c := range cases {
+		s, err := ParseSchedule(c.spec)
+		if err != nil {
+			t.Errorf("Expected %q to parse, got %v", c.spec, err)
+			continue
+		}
+
+		if next := s.Next(start); !next.Equal(c.next) {
+			t.Errorf("Expected %q to next run at %v, got %v", c.spec, c.next, next)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@every 1ms", "@weekdays"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if Backoff(1) != 10*time.Second || Backoff(3) != 40*time.Second {
+		t.Errorf("Expected backoff to start at 10s and double, got %v and %v", Backoff(1), Backoff(3))
+	}
+
+	if Backoff(20) != time.Hour {
+		t.Errorf("Expected backoff to be capped at an hour, got %v", Backoff(20))
+	}
+}
@@ -0,0 +1,309 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"noxecane/go-starter/pkg/backoff"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	keyLeader    = "jobs:leader"
+	keyRecurring = "jobs:recurring:"
+
+	firstRetry = 10 * time.Second
+	maxRetry   = time.Hour
+)
+
+// leadScript takes or keeps the leadership if it's free or already ours.
+var leadScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current == ARGV[1] then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+  return 1
+end
+
+if not current then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  return 1
+end
+
+return 0
+`)
+
+// resignScript gives up the leadership, but only if it's ours.
+var resignScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Handler runs a job, given its raw payload.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+type WorkerOpts struct {
+	// Concurrency is how many jobs run at once. Defaults to 4
+	Concurrency int
+	// PollInterval is how long to wait for new jobs when the queue is empty. Defaults to 1s
+	PollInterval time.Duration
+	// Visibility is how long a job can run before other workers assume it's been
+	// abandoned and run it again. Defaults to 5m
+	Visibility time.Duration
+}
+
+type recurring struct {
+	name     string
+	schedule Schedule
+}
+
+// Worker runs jobs from the queue. Every worker can run recurring jobs, but only the
+// one holding the leadership enqueues them, so they run once no matter how many
+// replicas are up.
+type Worker struct {
+	queue     *Queue
+	log       zerolog.Logger
+	opts      WorkerOpts
+	id        string
+	handlers  map[string]Handler
+	recurring []recurring
+}
+
+func NewWorker(q *Queue, log zerolog.Logger, opts WorkerOpts) *Worker {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+
+	if opts.Visibility <= 0 {
+		opts.Visibility = 5 * time.Minute
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	return &Worker{
+		queue:    q,
+		log:      log,
+		opts:     opts,
+		id:       hex.EncodeToString(id),
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register sets the handler for jobs with the given name. Prefer Handle which decodes
+// the payload.
+func (w *Worker) Register(name string, h Handler) {
+	w.handlers[name] = h
+}
+
+// Handle sets fn to run the job's payloads.
+func Handle[T any](w *Worker, j Job[T], fn func(ctx context.Context, payload T) error) {
+	w.Register(j.Name, func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return err
+		}
+
+		return fn(ctx, payload)
+	})
+}
+
+// Recurring runs fn on the schedule, see ParseSchedule for the specs supported.
+func (w *Worker) Recurring(name, spec string, fn func(ctx context.Context) error) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	if schedule.Next(time.Now()).IsZero() {
+		return fmt.Errorf("schedule %q never runs", spec)
+	}
+
+	w.recurring = append(w.recurring, recurring{name, schedule})
+	w.Register(name, func(ctx context.Context, _ json.RawMessage) error {
+		return fn(ctx)
+	})
+
+	return nil
+}
+
+// Run works through jobs until the context is cancelled, then waits for running jobs
+// to finish before returning.
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.schedule(ctx)
+	}()
+
+	slots := make(chan struct{}, w.opts.Concurrency)
+
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case slots <- struct{}{}:
+		}
+
+		env, err := w.queue.fetch(ctx, w.opts.Visibility)
+		if err != nil && ctx.Err() == nil {
+			w.log.Err(err).Msg("failed to fetch job")
+		}
+
+		if env == nil {
+			<-slots
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.opts.PollInterval):
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			// jobs that have started get to finish when we shut down
+			w.process(context.WithoutCancel(ctx), env)
+		}()
+	}
+}
+
+func (w *Worker) process(ctx context.Context, env *Envelope) {
+	log := w.log.With().Str("job", env.Name).Str("job_id", env.ID).Int("attempt", env.Attempts).Logger()
+
+	err := w.run(ctx, env)
+	if err == nil {
+		if err := w.queue.ack(ctx, env); err != nil {
+			log.Err(err).Msg("failed to acknowledge job")
+		}
+		return
+	}
+
+	env.Error = err.Error()
+
+	if env.Attempts >= env.MaxAttempts {
+		log.Err(err).Msg("job failed for the last time")
+		if err := w.queue.bury(ctx, env); err != nil {
+			log.Err(err).Msg("failed to bury job")
+		}
+		return
+	}
+
+	log.Warn().Err(err).Msg("job failed, retrying")
+	if err := w.queue.retry(ctx, env, time.Now().Add(Backoff(env.Attempts))); err != nil {
+		log.Err(err).Msg("failed to retry job")
+	}
+}
+
+func (w *Worker) run(ctx context.Context, env *Envelope) (err error) {
+	h, ok := w.handlers[env.Name]
+	if !ok {
+		return fmt.Errorf("no handler for %q jobs", env.Name)
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("job panicked: %v", v)
+		}
+	}()
+
+	// past the visibility timeout someone else will pick the job up anyway
+	ctx, cancel := context.WithTimeout(ctx, w.opts.Visibility)
+	defer cancel()
+
+	return h(ctx, env.Payload)
+}
+
+// schedule enqueues recurring jobs when they're due, for as long as this worker is
+// the leader.
+func (w *Worker) schedule(ctx context.Context) {
+	if len(w.recurring) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+
+	// leadership outlives a few missed ticks before another worker takes over
+	ttl := 5 * w.opts.PollInterval
+
+	for {
+		select {
+		case <-ctx.Done():
+			// let another worker take over straight away
+			_, err := resignScript.Run(context.WithoutCancel(ctx), w.queue.redis, []string{keyLeader}, w.id).Result()
+			if err != nil {
+				w.log.Err(err).Msg("failed to resign job scheduling leadership")
+			}
+			return
+		case now := <-ticker.C:
+			lead, err := leadScript.Run(ctx, w.queue.redis, []string{keyLeader}, w.id, ttl.Milliseconds()).Int()
+			if err != nil {
+				if ctx.Err() == nil {
+					w.log.Err(err).Msg("failed to check job scheduling leadership")
+				}
+				continue
+			}
+
+			if lead == 0 {
+				continue
+			}
+
+			for _, r := range w.recurring {
+				if err := w.enqueueDue(ctx, r, now); err != nil && ctx.Err() == nil {
+					w.log.Err(err).Str("job", r.name).Msg("failed to schedule recurring job")
+				}
+			}
+		}
+	}
+}
+
+func (w *Worker) enqueueDue(ctx context.Context, r recurring, now time.Time) error {
+	key := keyRecurring + r.name
+
+	next, err := w.queue.redis.Get(ctx, key).Int64()
+	if errors.Is(err, redis.Nil) {
+		// first time we've seen this job, wait for its first slot
+		return w.queue.redis.Set(ctx, key, r.schedule.Next(now).UnixMilli(), 0).Err()
+	} else if err != nil {
+		return err
+	}
+
+	if now.UnixMilli() < next {
+		return nil
+	}
+
+	// recurring jobs aren't retried, they run again in their next slot
+	if _, err := w.queue.Enqueue(ctx, r.name, strconv.FormatInt(next, 10), MaxAttempts(1)); err != nil {
+		return err
+	}
+
+	return w.queue.redis.Set(ctx, key, r.schedule.Next(now).UnixMilli(), 0).Err()
+}
+
+// Backoff is how long to wait before retrying a job that has failed attempts times,
+// doubling from 10s up to an hour.
+func Backoff(attempts int) time.Duration {
+	return backoff.Doubling(attempts, firstRetry, maxRetry)
+}
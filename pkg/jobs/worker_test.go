@@ -0,0 +1,301 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/noxecane/anansi"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+var testRedis *redis.Client
+
+// testEnv has the parts of config.Env the tests need, as config depends on this package.
+type testEnv struct {
+	Name          string `default:"go-starter"`
+	RedisHost     string `required:"true" split_words:"true"`
+	RedisPort     int    `required:"true" split_words:"true"`
+	RedisPassword string `default:"" split_words:"true"`
+}
+
+func afterEach(t *testing.T) {
+	ctx := context.TODO()
+
+	keys, err := testRedis.Keys(ctx, "jobs:*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) > 0 {
+		if err := testRedis.Del(ctx, keys...).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	var err error
+
+	var e testEnv
+	if err = anansi.LoadEnv(&e); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(e.Name)
+
+	testRedis = redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", e.RedisHost, e.RedisPort),
+		Password: e.RedisPassword,
+	})
+	if err = testRedis.Ping(context.TODO()).Err(); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to redis")
+
+	code := m.Run()
+
+	if err := testRedis.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from redis cleanly")
+	}
+
+	os.Exit(code)
+}
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+var greet = NewJob[greeting]("test.greet")
+
+func TestWorkerRun(t *testing.T) {
+	defer afterEach(t)
+
+	q := NewQueue(testRedis)
+	w := NewWorker(q, zerolog.Nop(), WorkerOpts{PollInterval: 10 * time.Millisecond})
+
+	names := make(chan string, 1)
+	Handle(w, greet, func(ctx context.Context, g greeting) error {
+		names <- g.Name
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(ctx)
+	}()
+
+	id, err := greet.Enqueue(ctx, q, greeting{"Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case name := <-names:
+		if name != "Ada" {
+			t.Errorf("Expected the job to get its payload, got %q", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the job to run")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the worker to stop once cancelled")
+	}
+
+	if env, err := q.get(context.TODO(), id); err != nil {
+		t.Fatal(err)
+	} else if env != nil {
+		t.Error("Expected completed job to be removed")
+	}
+}
+
+func TestWorkerRetry(t *testing.T) {
+	defer afterEach(t)
+
+	ctx := context.TODO()
+	q := NewQueue(testRedis)
+	w := NewWorker(q, zerolog.Nop(), WorkerOpts{})
+
+	Handle(w, greet, func(ctx context.Context, g greeting) error {
+		return errors.New("not today")
+	})
+
+	id, err := greet.Enqueue(ctx, q, greeting{"Grace"}, MaxAttempts(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := q.fetch(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.process(ctx, env)
+
+	runAt, err := testRedis.ZScore(ctx, keyQueue, id).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wait := time.Until(time.UnixMilli(int64(runAt))); wait < Backoff(1)-time.Second {
+		t.Errorf("Expected retry to back off by %v, got %v", Backoff(1), wait)
+	}
+
+	// skip the backoff
+	testRedis.ZAdd(ctx, keyQueue, redis.Z{Score: score(time.Now()), Member: id})
+
+	if env, err = q.fetch(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	w.process(ctx, env)
+
+	dead, err := q.Dead(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dead) != 1 || dead[0].ID != id || dead[0].Attempts != 2 || dead[0].Error != "not today" {
+		t.Errorf("Expected job to be buried after 2 attempts, got %+v", dead)
+	}
+
+	if ttl := testRedis.TTL(ctx, keyData+id).Val(); ttl <= 0 || ttl > deadTTL {
+		t.Errorf("Expected buried job to expire within %v, got %v", deadTTL, ttl)
+	}
+}
+
+func TestQueueFetch(t *testing.T) {
+	defer afterEach(t)
+
+	ctx := context.TODO()
+	q := NewQueue(testRedis)
+
+	if _, err := greet.Enqueue(ctx, q, greeting{"Later"}, Delay(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if env, err := q.fetch(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if env != nil {
+		t.Fatal("Expected delayed job to wait")
+	}
+
+	id, err := greet.Enqueue(ctx, q, greeting{"Now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := q.fetch(ctx, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if env == nil || env.ID != id {
+		t.Fatalf("Expected to fetch job %s", id)
+	}
+
+	// let the visibility timeout run out, as if the worker died
+	time.Sleep(20 * time.Millisecond)
+
+	if env, err = q.fetch(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if env == nil || env.ID != id || env.Attempts != 2 {
+		t.Errorf("Expected abandoned job to be fetched again on its second attempt, got %+v", env)
+	}
+
+	// jobs whose data has gone are dropped rather than fetched forever
+	testRedis.Del(ctx, keyData+id)
+	testRedis.ZAdd(ctx, keyActive, redis.Z{Score: score(time.Now()), Member: id})
+
+	if env, err = q.fetch(ctx, time.Minute); err != nil || env != nil {
+		t.Errorf("Expected nothing to fetch, got %+v %v", env, err)
+	}
+
+	for _, key := range []string{keyQueue, keyActive} {
+		if err := testRedis.ZScore(ctx, key, id).Err(); !errors.Is(err, redis.Nil) {
+			t.Errorf("Expected the job without data to be dropped from %s, got %v", key, err)
+		}
+	}
+}
+
+func TestWorkerLeadership(t *testing.T) {
+	defer afterEach(t)
+
+	ctx := context.TODO()
+	q := NewQueue(testRedis)
+
+	w1 := NewWorker(q, zerolog.Nop(), WorkerOpts{})
+	w2 := NewWorker(q, zerolog.Nop(), WorkerOpts{})
+
+	lead := func(w *Worker) int {
+		n, err := leadScript.Run(ctx, testRedis, []string{keyLeader}, w.id, time.Minute.Milliseconds()).Int()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if lead(w1) != 1 {
+		t.Fatal("Expected first worker to take the lead")
+	}
+
+	if lead(w2) != 0 {
+		t.Error("Expected second worker to wait while the first leads")
+	}
+
+	if lead(w1) != 1 {
+		t.Error("Expected leader to keep its lead")
+	}
+
+	if err := resignScript.Run(ctx, testRedis, []string{keyLeader}, w1.id).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if lead(w2) != 1 {
+		t.Error("Expected second worker to take over once the leader resigns")
+	}
+}
+
+func TestWorkerRecurring(t *testing.T) {
+	defer afterEach(t)
+
+	ctx := context.TODO()
+	q := NewQueue(testRedis)
+	w := NewWorker(q, zerolog.Nop(), WorkerOpts{})
+
+	if err := w.Recurring("test.tick", "@every 1m", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	r := w.recurring[0]
+
+	now := time.Now()
+	if err := w.enqueueDue(ctx, r, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testRedis.ZCard(ctx, keyQueue).Val(); n != 0 {
+		t.Fatalf("Expected recurring job to wait for its first slot, got %d queued", n)
+	}
+
+	if err := w.enqueueDue(ctx, r, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.enqueueDue(ctx, r, now.Add(time.Minute+time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testRedis.ZCard(ctx, keyQueue).Val(); n != 1 {
+		t.Errorf("Expected recurring job to be queued once for its slot, got %d", n)
+	}
+}
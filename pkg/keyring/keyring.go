@@ -0,0 +1,129 @@
+// Package keyring holds the secrets tokens and cursors are signed with, so they can
+// be rotated without logging everyone out. The first key signs, the rest are only
+// trusted for what they signed before.
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LegacyID is the ID of the key made from SECRET, and of tokens issued before they
+// carried the ID of their key.
+const LegacyID = "default"
+
+// MinSecretLength is the shortest secret a key can have.
+const MinSecretLength = 16
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Key is a secret and the ID it's known by in tokens.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring is an ordered set of keys, the first being the active one.
+type Keyring struct {
+	keys []Key
+}
+
+// New creates a keyring from keys, the first being the active one.
+func New(keys ...Key) (Keyring, error) {
+	if len(keys) == 0 {
+		return Keyring{}, errors.New("a keyring needs at least one key")
+	}
+
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if !validID.MatchString(k.ID) {
+			return Keyring{}, fmt.Errorf("invalid key ID %q, only letters, digits, - and _ are allowed", k.ID)
+		}
+
+		if seen[k.ID] {
+			return Keyring{}, fmt.Errorf("key %s is listed twice", k.ID)
+		}
+		seen[k.ID] = true
+
+		if len(k.Secret) < MinSecretLength {
+			return Keyring{}, fmt.Errorf("key %s is shorter than %d bytes", k.ID, MinSecretLength)
+		}
+	}
+
+	return Keyring{keys: keys}, nil
+}
+
+// Parse reads keys like "2024-10:new-secret,2024-04:old-secret", the first being
+// the active one. Secrets can't contain commas.
+func Parse(s string) (Keyring, error) {
+	var keys []Key
+	for i, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok {
+			// the pair could be a bare secret, so it's left out of the error
+			return Keyring{}, fmt.Errorf("key %d isn't like id:secret", i+1)
+		}
+
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+
+	return New(keys...)
+}
+
+// Decode lets the keyring be read straight from the environment.
+func (k *Keyring) Decode(value string) error {
+	ring, err := Parse(value)
+	if err != nil {
+		return err
+	}
+
+	*k = ring
+	return nil
+}
+
+// String lists the keys by ID, never their secrets.
+func (k Keyring) String() string {
+	ids := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		ids[i] = key.ID + ":[redacted]"
+	}
+
+	return strings.Join(ids, ",")
+}
+
+// Len is the number of keys in the keyring.
+func (k Keyring) Len() int {
+	return len(k.keys)
+}
+
+// Active is the key new tokens and cursors are signed with.
+func (k Keyring) Active() Key {
+	if len(k.keys) == 0 {
+		return Key{}
+	}
+
+	return k.keys[0]
+}
+
+// Find returns the key with the given ID, if it's still in the keyring.
+func (k Keyring) Find(id string) (Key, bool) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+
+	return Key{}, false
+}
+
+// Keys returns every key, the active one first.
+func (k Keyring) Keys() []Key {
+	return append([]Key(nil), k.keys...)
+}
@@ -0,0 +1,272 @@
+package keyring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noxecane/anansi"
+	"github.com/noxecane/anansi/jwt"
+	"github.com/noxecane/anansi/tokens"
+	"github.com/redis/go-redis/v9"
+)
+
+var testRedis *redis.Client
+
+type testEnv struct {
+	Name          string `default:"go-starter"`
+	RedisHost     string `required:"true" split_words:"true"`
+	RedisPort     int    `required:"true" split_words:"true"`
+	RedisPassword string `default:"" split_words:"true"`
+}
+
+type session struct {
+	User int `json:"user"`
+}
+
+func TestMain(m *testing.M) {
+	var e testEnv
+	if err := anansi.LoadEnv(&e); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(e.Name)
+
+	testRedis = redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", e.RedisHost, e.RedisPort),
+		Password: e.RedisPassword,
+	})
+	if err := testRedis.Ping(context.TODO()).Err(); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to redis")
+
+	code := m.Run()
+
+	if err := testRedis.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from redis cleanly")
+	}
+
+	os.Exit(code)
+}
+
+func mustParse(t *testing.T, s string) Keyring {
+	t.Helper()
+
+	ring, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ring
+}
+
+func TestParse(t *testing.T) {
+	ring := mustParse(t, "2024-10:a-new-secret-value, 2024-04:an-old-secret-value")
+
+	if ring.Len() != 2 || ring.Active().ID != "2024-10" || string(ring.Active().Secret) != "a-new-secret-value" {
+		t.Errorf("Expected the first key to be active, got %v", ring.Keys())
+	}
+
+	if key, ok := ring.Find("2024-04"); !ok || string(key.Secret) != "an-old-secret-value" {
+		t.Errorf("Expected to find the old key, got %v", key)
+	}
+
+	if s := ring.String(); s != "2024-10:[redacted],2024-04:[redacted]" {
+		t.Errorf("Expected only the IDs to be printed, got %s", s)
+	}
+
+	for _, s := range []string{
+		"",
+		"a-secret-without-an-id",
+		"bad.id:a-long-enough-secret",
+		"short:secret",
+		"same:a-long-enough-secret,same:another-long-secret",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		} else if strings.Contains(err.Error(), "secret-without") {
+			t.Errorf("Expected the secret to be left out of the error, got %v", err)
+		}
+	}
+}
+
+// TestRotation walks through rotating the secret the way the README describes.
+func TestRotation(t *testing.T) {
+	ctx := context.TODO()
+	ttl := time.Minute
+
+	t.Cleanup(func() {
+		for _, key := range []string{"user:1", "user:2", "user:3"} {
+			_ = NewTokenStore(testRedis, mustParse(t, "default:the-old-secret-value,new:the-new-secret-value")).Revoke(ctx, key)
+		}
+	})
+
+	// tokens issued with SECRET, before keys had IDs
+	legacy, err := tokens.NewStore(testRedis, []byte("the-old-secret-value")).Commission(ctx, ttl, "user:1", session{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0. SECRET becomes the key "default"
+	store := NewTokenStore(testRedis, mustParse(t, "default:the-old-secret-value"))
+	old, err := store.Commission(ctx, ttl, "user:2", session{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(old, "default.") {
+		t.Errorf("Expected the token to carry its key ID, got %s", old)
+	}
+
+	// 1. the new key is trusted everywhere before anything is signed with it
+	store = NewTokenStore(testRedis, mustParse(t, "default:the-old-secret-value,new:the-new-secret-value"))
+	for _, token := range []string{legacy, old} {
+		var s session
+		if err := store.Peek(ctx, token, &s); err != nil {
+			t.Errorf("Expected %s to still be valid, got %v", token, err)
+		}
+	}
+
+	// 2. the new key signs, the old one still verifies
+	store = NewTokenStore(testRedis, mustParse(t, "new:the-new-secret-value,default:the-old-secret-value"))
+	current, err := store.Commission(ctx, ttl, "user:3", session{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(current, "new.") {
+		t.Errorf("Expected the token to be signed with the new key, got %s", current)
+	}
+
+	for _, token := range []string{legacy, old, current} {
+		var s session
+		if err := store.Extend(ctx, token, ttl, &s); err != nil {
+			t.Errorf("Expected %s to still be valid, got %v", token, err)
+		}
+	}
+
+	// tokens are found by their key whichever secret made them
+	if err := store.Reset(ctx, "user:2", session{20}); err != nil {
+		t.Errorf("Expected the old token to be reset, got %v", err)
+	}
+
+	var reset session
+	if err := store.Peek(ctx, old, &reset); err != nil || reset.User != 20 {
+		t.Errorf("Expected the old token to be reset, got %v %v", reset, err)
+	}
+
+	// 3. once the old tokens would have expired, the old key goes
+	store = NewTokenStore(testRedis, mustParse(t, "new:the-new-secret-value"))
+	for _, token := range []string{legacy, old} {
+		var s session
+		if err := store.Peek(ctx, token, &s); !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected %s to be rejected without its key, got %v", token, err)
+		}
+	}
+
+	var s session
+	if err := store.Decommission(ctx, current, &s); err != nil || s.User != 3 {
+		t.Errorf("Expected the new token to be valid, got %v %v", s, err)
+	}
+
+	if err := store.Revoke(ctx, "user:3"); !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected a decommissioned token to be gone, got %v", err)
+	}
+
+	// tokens of a removed key can't be passed off as the active key's
+	for _, token := range []string{"new." + strings.TrimPrefix(old, "default."), "new." + legacy} {
+		if err := store.Peek(ctx, token, &s); !errors.Is(err, tokens.ErrTokenNotFound) {
+			t.Errorf("Expected %s to be rejected under the active key's ID, got %v", token, err)
+		}
+	}
+
+	// made up key IDs don't fall back to anything
+	if err := store.Peek(ctx, "forged."+strings.TrimPrefix(current, "new."), &s); !errors.Is(err, tokens.ErrTokenNotFound) {
+		t.Errorf("Expected unknown keys to be rejected, got %v", err)
+	}
+}
+
+func TestHeadlessRotation(t *testing.T) {
+	timeout := time.Minute
+	old := mustParse(t, "default:the-old-secret-value-of-32-bytes")
+	rotated := mustParse(t, "new:the-new-secret-value-of-32-bytes,default:the-old-secret-value-of-32-bytes")
+	removed := mustParse(t, "new:the-new-secret-value-of-32-bytes")
+
+	headless := func(ring Keyring) *http.Request {
+		token, err := jwt.Encode(ring.Active().Secret, timeout, session{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Headless "+token)
+		return r
+	}
+
+	oldReq := headless(old)
+	newReq := headless(rotated)
+
+	store := NewSessionStore(rotated, "Headless", timeout, NewTokenStore(testRedis, rotated))
+	for _, r := range []*http.Request{oldReq, newReq} {
+		var s session
+		if err := store.Load(r, &s); err != nil || s.User != 1 {
+			t.Errorf("Expected headless sessions from either key to be valid, got %v %v", s, err)
+		}
+	}
+
+	store = NewSessionStore(removed, "Headless", timeout, NewTokenStore(testRedis, removed))
+
+	var s session
+	if err := store.Load(oldReq, &s); !errors.Is(err, jwt.ErrInvalidToken) {
+		t.Errorf("Expected headless sessions to be rejected without their key, got %v", err)
+	}
+
+	if err := store.Load(newReq, &s); err != nil || s.User != 1 {
+		t.Errorf("Expected headless sessions from the active key to be valid, got %v %v", s, err)
+	}
+}
+
+func TestSessionPeek(t *testing.T) {
+	ctx := context.TODO()
+	ring := mustParse(t, "new:the-new-secret-value-of-32-bytes")
+	store := NewSessionStore(ring, "Headless", time.Minute, NewTokenStore(testRedis, ring))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	token, err := store.Save(r, "user:4", session{4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.tokens.Revoke(ctx, "user:4") })
+
+	// tokens are kept under the ID of their key
+	raw := redisKey("new", strings.TrimPrefix(token, "new."))
+	if err := testRedis.Expire(ctx, raw, 10*time.Second).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	var s session
+	if err := store.Peek(r, &s); err != nil || s.User != 4 {
+		t.Fatalf("Expected to peek at the session, got %v %v", s, err)
+	}
+
+	if ttl := testRedis.TTL(ctx, raw).Val(); ttl > 10*time.Second {
+		t.Errorf("Expected peeking to leave the session's expiry alone, got %s", ttl)
+	}
+
+	if err := store.Load(r, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl := testRedis.TTL(ctx, raw).Val(); ttl <= 10*time.Second {
+		t.Errorf("Expected loading to extend the session, got %s", ttl)
+	}
+}
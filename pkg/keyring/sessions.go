@@ -0,0 +1,104 @@
+package keyring
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/noxecane/anansi/jwt"
+	"github.com/noxecane/anansi/sessions"
+	"github.com/noxecane/anansi/tokens"
+)
+
+// SessionStore is a sessions.Store whose headless sessions can be made with any key
+// in the keyring, rather than only the one it was created with. New headless
+// sessions should still be encoded with the active key.
+type SessionStore struct {
+	*sessions.Store
+	tokens tokens.Store
+	ring   Keyring
+	scheme string
+}
+
+// NewSessionStore creates a SessionStore keeping bearer sessions in tStore for
+// timeout, and trusting headless sessions with the given scheme from any key in ring.
+func NewSessionStore(ring Keyring, scheme string, timeout time.Duration, tStore tokens.Store) *SessionStore {
+	return &SessionStore{
+		Store:  sessions.NewStore(ring.Active().Secret, scheme, timeout, tStore),
+		tokens: tStore,
+		ring:   ring,
+		scheme: strings.ToLower(scheme),
+	}
+}
+
+// Load loads the session from the Authorization header, extending it if it's a
+// bearer session.
+func (s *SessionStore) Load(r *http.Request, v interface{}) error {
+	err := s.LoadBearer(r, v)
+	if err == sessions.ErrUnsupportedScheme {
+		return s.LoadHeadless(r, v)
+	}
+
+	return err
+}
+
+// Peek loads the session from the Authorization header like Load, without extending
+// bearer sessions. It's for looking at requests that may not go on to use the session.
+func (s *SessionStore) Peek(r *http.Request, v interface{}) error {
+	scheme, token, err := authorization(r)
+	if err != nil {
+		return err
+	}
+
+	switch scheme {
+	case "bearer":
+		return s.tokens.Peek(r.Context(), token, v)
+	case s.scheme:
+		return s.decode(token, v)
+	default:
+		return sessions.ErrUnsupportedScheme
+	}
+}
+
+// LoadHeadless decodes a headless session with whichever key in the keyring made it.
+func (s *SessionStore) LoadHeadless(r *http.Request, v interface{}) error {
+	scheme, token, err := authorization(r)
+	if err != nil {
+		return err
+	}
+
+	if scheme != s.scheme {
+		return sessions.ErrUnsupportedScheme
+	}
+
+	return s.decode(token, v)
+}
+
+func (s *SessionStore) decode(token string, v interface{}) error {
+	err := jwt.ErrInvalidToken
+	for _, key := range s.ring.keys {
+		// only the wrong key makes the token invalid, anything else is final
+		if err = jwt.Decode(key.Secret, token, v); !errors.Is(err, jwt.ErrInvalidToken) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// authorization splits the Authorization header into its scheme and token, the way
+// sessions.Store does.
+func authorization(r *http.Request) (string, string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", "", sessions.ErrEmptyHeader
+	}
+
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return "", "", sessions.ErrHeaderFormat
+	}
+
+	return strings.ToLower(parts[0]), parts[1], nil
+}
@@ -0,0 +1,182 @@
+package keyring
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/noxecane/anansi/tokens"
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenStore commissions tokens with the active key, prefixed by its ID like
+// "2024-10.3f2a...". Tokens are kept in redis under the ID of the key that made them,
+// so they're only found with that ID, and only while the key is in the keyring.
+// Removing a key ends every token it made, even those still in redis.
+type tokenStore struct {
+	redis *redis.Client
+	ring  Keyring
+}
+
+// NewTokenStore creates a tokens.Store that signs with ring's active key. Tokens
+// without a key ID were issued with SECRET by anansi's store, and are trusted as long
+// as there's a key with LegacyID.
+func NewTokenStore(r *redis.Client, ring Keyring) tokens.Store {
+	return &tokenStore{redis: r, ring: ring}
+}
+
+func (ts *tokenStore) Commission(ctx context.Context, t time.Duration, key string, v interface{}) (string, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	active := ts.ring.Active()
+	token := sign(active, key)
+	if err := ts.redis.Set(ctx, redisKey(active.ID, token), encoded, t).Err(); err != nil {
+		return "", err
+	}
+
+	return active.ID + "." + token, nil
+}
+
+func (ts *tokenStore) Peek(ctx context.Context, token string, v interface{}) error {
+	key, err := ts.find(token)
+	if err != nil {
+		return err
+	}
+
+	return ts.get(ctx, key, v)
+}
+
+func (ts *tokenStore) Extend(ctx context.Context, token string, t time.Duration, v interface{}) error {
+	key, err := ts.find(token)
+	if err != nil {
+		return err
+	}
+
+	if err := ts.get(ctx, key, v); err != nil {
+		return err
+	}
+
+	ok, err := ts.redis.Expire(ctx, key, t).Result()
+	if err != nil {
+		return err
+	} else if !ok {
+		return tokens.ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// Reset changes the token made for key with every key in the keyring, as the key
+// it was made with isn't known.
+func (ts *tokenStore) Reset(ctx context.Context, key string, v interface{}) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, k := range ts.candidates(key) {
+		err := ts.redis.SetArgs(ctx, k, encoded, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		found = true
+	}
+
+	if !found {
+		return tokens.ErrTokenNotFound
+	}
+
+	return nil
+}
+
+func (ts *tokenStore) Decommission(ctx context.Context, token string, v interface{}) error {
+	key, err := ts.find(token)
+	if err != nil {
+		return err
+	}
+
+	if err := ts.get(ctx, key, v); err != nil {
+		return err
+	}
+
+	return ts.redis.Del(ctx, key).Err()
+}
+
+// Revoke removes the tokens made for key with every key in the keyring.
+func (ts *tokenStore) Revoke(ctx context.Context, key string) error {
+	n, err := ts.redis.Del(ctx, ts.candidates(key)...).Result()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return tokens.ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// find returns where token is kept, if the key it names is still in the keyring.
+func (ts *tokenStore) find(token string) (string, error) {
+	id, raw, ok := strings.Cut(token, ".")
+	if !ok {
+		// tokens from before key IDs were kept under their value alone
+		id, raw = LegacyID, token
+	}
+
+	if _, found := ts.ring.Find(id); !found || raw == "" {
+		return "", tokens.ErrTokenNotFound
+	}
+
+	if !ok {
+		return raw, nil
+	}
+
+	return redisKey(id, raw), nil
+}
+
+// candidates are everywhere the token made for key could be kept, one for every key
+// in the keyring and the legacy one.
+func (ts *tokenStore) candidates(key string) []string {
+	var keys []string
+	for _, k := range ts.ring.keys {
+		keys = append(keys, redisKey(k.ID, sign(k, key)))
+		if k.ID == LegacyID {
+			keys = append(keys, sign(k, key))
+		}
+	}
+
+	return keys
+}
+
+func (ts *tokenStore) get(ctx context.Context, key string, v interface{}) error {
+	encoded, err := ts.redis.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return tokens.ErrTokenNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(encoded, v)
+}
+
+// sign makes the token for key, the same way anansi's store does.
+func sign(k Key, key string) string {
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func redisKey(id, token string) string {
+	return "tokens:" + id + ":" + token
+}
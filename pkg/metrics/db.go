@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uptrace/bun"
+)
+
+var (
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Time taken by database queries, by operation and table.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation", "table"})
+
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "Database queries that failed, by operation and table.",
+	}, []string{"operation", "table"})
+)
+
+// QueryHook records the time taken by every query bun runs. Raw queries have no table
+// to speak of, so they're only labelled by operation.
+type QueryHook struct{}
+
+func (QueryHook) BeforeQuery(ctx context.Context, _ *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (QueryHook) AfterQuery(_ context.Context, e *bun.QueryEvent) {
+	op := strings.ToUpper(e.Operation())
+
+	var table string
+	if e.IQuery != nil {
+		table = e.IQuery.GetTableName()
+	}
+
+	queryDuration.WithLabelValues(op, table).Observe(time.Since(e.StartTime).Seconds())
+
+	// missing rows are answers, not failures
+	if e.Err != nil && !errors.Is(e.Err, sql.ErrNoRows) {
+		queryErrors.WithLabelValues(op, table).Inc()
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric the app exports, along with the Go runtime's and the
+// process's own.
+var Registry = prometheus.NewRegistry()
+
+var (
+	MailsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mails_sent_total",
+		Help: "Mails handed over for delivery, by template.",
+	}, []string{"template"})
+
+	MailsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mails_failed_total",
+		Help: "Attempts at sending a mail that failed, by template.",
+	}, []string{"template"})
+
+	InvitationsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "invitations_created_total",
+		Help: "Invitations created for users yet to set up their account.",
+	})
+
+	InvitationsAccepted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "invitations_accepted_total",
+		Help: "Invitations accepted by users setting up their account.",
+	})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limited_total",
+		Help: "Requests rejected for going over their rate limit, by route group and scope.",
+	}, []string{"group", "scope"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		queryDuration,
+		queryErrors,
+		MailsSent,
+		MailsFailed,
+		InvitationsCreated,
+		InvitationsAccepted,
+		RateLimited,
+	)
+}
+
+// Handler serves the metrics in Registry for prometheus to scrape.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
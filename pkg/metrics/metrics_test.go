@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uptrace/bun"
+)
+
+func TestHTTP(t *testing.T) {
+	users := chi.NewRouter()
+	users.Get("/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	router := chi.NewRouter()
+	router.Use(HTTP)
+	router.Mount("/api/v1/users", users)
+
+	for _, path := range []string{"/api/v1/users/1", "/api/v1/users/2", "/nowhere"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if n := testutil.ToFloat64(requestsTotal.WithLabelValues("GET", "/api/v1/users/{id}", "204")); n != 2 {
+		t.Errorf("Expected requests to be counted by their route, got %v", n)
+	}
+
+	if n := testutil.ToFloat64(requestsTotal.WithLabelValues("GET", "unmatched", "404")); n != 1 {
+		t.Errorf("Expected requests without a route to be counted as unmatched, got %v", n)
+	}
+
+	if n := testutil.CollectAndCount(requestDuration); n != 2 {
+		t.Errorf("Expected a latency series per route, got %d", n)
+	}
+}
+
+func TestQueryHook(t *testing.T) {
+	var hook QueryHook
+
+	ctx := hook.BeforeQuery(context.TODO(), nil)
+
+	hook.AfterQuery(ctx, &bun.QueryEvent{Query: "select 1", StartTime: time.Now()})
+	hook.AfterQuery(ctx, &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now(), Err: sql.ErrNoRows})
+	hook.AfterQuery(ctx, &bun.QueryEvent{Query: "DELETE FROM users", StartTime: time.Now(), Err: errors.New("boom")})
+
+	if n := testutil.CollectAndCount(queryDuration); n != 2 {
+		t.Errorf("Expected a series per operation, got %d", n)
+	}
+
+	expected := `
+# HELP db_query_errors_total Database queries that failed, by operation and table.
+# TYPE db_query_errors_total counter
+db_query_errors_total{operation="DELETE",table=""} 1
+`
+	if err := testutil.CollectAndCompare(queryErrors, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	MailsSent.WithLabelValues("invitation").Inc()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if !strings.Contains(w.Body.String(), `mails_sent_total{template="invitation"} 1`) {
+		t.Errorf("Expected registered metrics to be served, got\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	pgAcquiredConns = prometheus.NewDesc("pgxpool_acquired_conns", "Connections currently in use.", nil, nil)
+	pgIdleConns     = prometheus.NewDesc("pgxpool_idle_conns", "Connections currently idle.", nil, nil)
+	pgTotalConns    = prometheus.NewDesc("pgxpool_total_conns", "Connections currently open, including ones being set up.", nil, nil)
+	pgMaxConns      = prometheus.NewDesc("pgxpool_max_conns", "Most connections the pool will open.", nil, nil)
+	pgAcquires      = prometheus.NewDesc("pgxpool_acquires_total", "Connections acquired from the pool.", nil, nil)
+	pgEmptyAcquires = prometheus.NewDesc("pgxpool_empty_acquires_total", "Acquires that had to wait for a connection.", nil, nil)
+	pgAcquireTime   = prometheus.NewDesc("pgxpool_acquire_duration_seconds_total", "Time spent acquiring connections.", nil, nil)
+
+	redisHits       = prometheus.NewDesc("redis_pool_hits_total", "Times a free connection was found in the pool.", nil, nil)
+	redisMisses     = prometheus.NewDesc("redis_pool_misses_total", "Times no free connection was found in the pool.", nil, nil)
+	redisTimeouts   = prometheus.NewDesc("redis_pool_timeouts_total", "Times waiting for a connection timed out.", nil, nil)
+	redisTotalConns = prometheus.NewDesc("redis_pool_total_conns", "Connections currently open.", nil, nil)
+	redisIdleConns  = prometheus.NewDesc("redis_pool_idle_conns", "Connections currently idle.", nil, nil)
+	redisStaleConns = prometheus.NewDesc("redis_pool_stale_conns_total", "Stale connections removed from the pool.", nil, nil)
+)
+
+type postgresCollector struct {
+	pool *pgxpool.Pool
+}
+
+// Postgres reports the stats of the pgx pool each time metrics are scraped.
+func Postgres(pool *pgxpool.Pool) prometheus.Collector {
+	return postgresCollector{pool}
+}
+
+func (c postgresCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{pgAcquiredConns, pgIdleConns, pgTotalConns, pgMaxConns, pgAcquires, pgEmptyAcquires, pgAcquireTime} {
+		ch <- d
+	}
+}
+
+func (c postgresCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(pgAcquiredConns, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(pgIdleConns, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(pgTotalConns, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(pgMaxConns, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(pgAcquires, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(pgEmptyAcquires, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(pgAcquireTime, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
+
+type redisCollector struct {
+	client *redis.Client
+}
+
+// Redis reports the stats of the client's connection pool each time metrics are
+// scraped.
+func Redis(client *redis.Client) prometheus.Collector {
+	return redisCollector{client}
+}
+
+func (c redisCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{redisHits, redisMisses, redisTimeouts, redisTotalConns, redisIdleConns, redisStaleConns} {
+		ch <- d
+	}
+}
+
+func (c redisCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.client.PoolStats()
+
+	ch <- prometheus.MustNewConstMetric(redisHits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(redisMisses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(redisTimeouts, prometheus.CounterValue, float64(s.Timeouts))
+	ch <- prometheus.MustNewConstMetric(redisTotalConns, prometheus.GaugeValue, float64(s.TotalConns))
+	ch <- prometheus.MustNewConstMetric(redisIdleConns, prometheus.GaugeValue, float64(s.IdleConns))
+	ch <- prometheus.MustNewConstMetric(redisStaleConns, prometheus.CounterValue, float64(s.StaleConns))
+}
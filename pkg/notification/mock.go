@@ -1,8 +1,10 @@
 package notification
 
+import "context"
+
 type MailerMock struct {
 }
 
-func (n *MailerMock) Send(m TemplateMail) error {
+func (n *MailerMock) Send(_ context.Context, m TemplateMail) error {
 	return nil
 }
@@ -1,22 +1,39 @@
 package notification
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
 	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/jobs"
+	"noxecane/go-starter/pkg/metrics"
+	"noxecane/go-starter/pkg/pii"
 	"path/filepath"
 
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	SenderNotify     *mail.Email
 	SenderPostmaster *mail.Email
 
-	templatesNames = []string{"request", "invitation", "password-reset"}
+	templatesNames = []string{
+		"request",
+		"invitation",
+		"membership",
+		"password-reset",
+		"ownership-transfer",
+		"ownership-transferred",
+		"test",
+	}
 )
 
 type TemplateMail struct {
@@ -36,15 +53,79 @@ type MailOpts struct {
 	Sender          string
 	NotifyEmail     string
 	PostmasterEmail string
+	// Queue leaves sending to MailJob when set, the mail is only rendered in Send
+	Queue *jobs.Queue
+}
+
+// Mail is a rendered mail ready to be sent.
+type Mail struct {
+	Sender        *mail.Email `json:"sender"`
+	Subject       string      `json:"subject"`
+	ReceiverName  string      `json:"receiver_name"`
+	ReceiverEmail string      `json:"receiver_email"`
+	HTML          string      `json:"html"`
+	Template      string      `json:"template"`
+}
+
+// TransportAddr is where mails are handed over for delivery.
+const TransportAddr = "api.sendgrid.com:443"
+
+var tracer = otel.Tracer("noxecane/go-starter/pkg/notification")
+
+// SealedMail is a Mail as it waits in the queue, encrypted with the pii cipher as it
+// carries tokens, names and email addresses. Only its template is left readable.
+type SealedMail struct {
+	Template string `json:"template"`
+	Mail     string `json:"mail"`
+}
+
+// MailJob sends mails queued by a Mailer created with a Queue.
+var MailJob = jobs.NewJob[SealedMail]("mail.send")
+
+func seal(m Mail) (SealedMail, error) {
+	c, err := pii.Current()
+	if err != nil {
+		return SealedMail{}, err
+	}
+
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return SealedMail{}, err
+	}
+
+	encrypted, err := c.Encrypt(string(raw))
+	if err != nil {
+		return SealedMail{}, err
+	}
+
+	return SealedMail{Template: m.Template, Mail: encrypted}, nil
+}
+
+func unseal(s SealedMail) (Mail, error) {
+	c, err := pii.Current()
+	if err != nil {
+		return Mail{}, err
+	}
+
+	raw, err := c.Decrypt(s.Mail)
+	if err != nil {
+		return Mail{}, err
+	}
+
+	var m Mail
+	err = json.Unmarshal([]byte(raw), &m)
+
+	return m, err
 }
 
 type Mailer interface {
-	Send(m TemplateMail) error
+	Send(ctx context.Context, m TemplateMail) error
 }
 
 type service struct {
 	client    *sendgrid.Client
 	templates map[string]*template.Template
+	queue     *jobs.Queue
 }
 
 func New(opts MailOpts) Mailer {
@@ -61,25 +142,89 @@ func New(opts MailOpts) Mailer {
 	// sendgrid client
 	client := sendgrid.NewSendClient(opts.Key)
 
-	return &service{client, templates}
+	return &service{client, templates, opts.Queue}
+}
+
+// DeliverMail creates the handler for MailJob.
+func DeliverMail(opts MailOpts) func(context.Context, SealedMail) error {
+	client := sendgrid.NewSendClient(opts.Key)
+
+	return func(ctx context.Context, s SealedMail) error {
+		m, err := unseal(s)
+		if err != nil {
+			return err
+		}
+
+		return deliver(ctx, client, m)
+	}
 }
 
-func (s *service) Send(m TemplateMail) error {
+func (s *service) Send(ctx context.Context, m TemplateMail) (err error) {
+	ctx, span := tracer.Start(ctx, "mail.send", trace.WithAttributes(
+		attribute.String("mail.template", m.Template),
+		attribute.Bool("mail.queued", s.queue != nil),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	htmlTmpl, ok := s.templates[m.Template]
 	if !ok {
 		msg := fmt.Sprintf("template with key \"%s\" doesn't exist", m.Template)
 		panic(errors.New(msg))
 	}
 
-	rcv := mail.NewEmail(m.ReceiverName, m.ReceiverEmail)
-
 	buf, err := ioutil.ReadAll(ExecuteTemplate(htmlTmpl, m.TemplateData))
 	if err != nil {
 		panic(err)
 	}
 
-	message := mail.NewSingleEmail(m.Sender, m.Subject, rcv, "Placeolder Text", string(buf))
-	if res, err := s.client.Send(message); err != nil && res.StatusCode != 200 {
+	rendered := Mail{
+		Sender:        m.Sender,
+		Subject:       m.Subject,
+		ReceiverName:  m.ReceiverName,
+		ReceiverEmail: m.ReceiverEmail,
+		HTML:          string(buf),
+		Template:      m.Template,
+	}
+
+	if s.queue != nil {
+		sealed, err := seal(rendered)
+		if err != nil {
+			return err
+		}
+
+		_, err = MailJob.Enqueue(ctx, s.queue, sealed)
+		return err
+	}
+
+	return deliver(ctx, s.client, rendered)
+}
+
+func deliver(ctx context.Context, client *sendgrid.Client, m Mail) (err error) {
+	_, span := tracer.Start(ctx, "mail.deliver", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("mail.template", m.Template),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			metrics.MailsFailed.WithLabelValues(m.Template).Inc()
+		} else {
+			metrics.MailsSent.WithLabelValues(m.Template).Inc()
+		}
+		span.End()
+	}()
+
+	rcv := mail.NewEmail(m.ReceiverName, m.ReceiverEmail)
+
+	message := mail.NewSingleEmail(m.Sender, m.Subject, rcv, "Placeolder Text", m.HTML)
+	// there's no response to look at when the request itself fails
+	if res, err := client.Send(message); err != nil {
 		return err
 	} else if res.StatusCode >= 400 {
 		return errors.New(res.Body)
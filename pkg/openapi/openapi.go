@@ -0,0 +1,288 @@
+// Package openapi generates an OpenAPI document for a chi router from a table
+// describing its routes, pointing out routes the table has missed.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"noxecane/go-starter/pkg/problem"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const Version = "3.1.0"
+
+var pathParam = regexp.MustCompile(`{([^}:]+)(:[^}]*)?}`)
+
+// Route documents a single route. Body and Response are example values of the
+// request and response types, a nil Response being a JSON null.
+type Route struct {
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Description string
+	Params      []Param
+	Body        interface{}
+	Response    interface{}
+	// Public routes don't need a session
+	Public bool
+	// Errors lists the status codes the route responds with besides 400, 401 and 500
+	Errors []int
+}
+
+// Param is a path, query or header parameter. Path parameters don't need listing
+// unless they aren't strings.
+type Param struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+	Required    bool
+	Enum        []interface{}
+}
+
+// File is a response that's downloaded rather than read, in one of the given media
+// types.
+type File []string
+
+// DriftError lists the routes that are served but undocumented, or documented but
+// no longer served.
+type DriftError struct {
+	Undocumented []string
+	Missing      []string
+}
+
+func (e *DriftError) Error() string {
+	var parts []string
+	if len(e.Undocumented) > 0 {
+		parts = append(parts, "undocumented routes: "+strings.Join(e.Undocumented, ", "))
+	}
+	if len(e.Missing) > 0 {
+		parts = append(parts, "documented routes that don't exist: "+strings.Join(e.Missing, ", "))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// Build generates the document for the routes served by router. The document
+// is still usable when it returns a DriftError, just incomplete.
+func Build(info Info, router chi.Routes, docs []Route) (*Document, error) {
+	served := make(map[string]string)
+	err := chi.Walk(router, func(method, route string, handler http.Handler, _ ...func(http.Handler) http.Handler) error {
+		served[routeKey(method, route)] = handlerName(handler)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := NewSchemas()
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			SecuritySchemes: map[string]*SecurityScheme{
+				"session": {
+					Type:        "apiKey",
+					In:          "header",
+					Name:        "Authorization",
+					Description: "The session token, as `Bearer <token>`",
+				},
+			},
+		},
+		Security: []map[string][]string{{"session": {}}},
+	}
+
+	errSchema := schemas.Of(problem.Details{})
+	drift := &DriftError{}
+
+	documented := make(map[string]bool)
+	for _, r := range docs {
+		key := routeKey(r.Method, r.Path)
+		documented[key] = true
+
+		opID, ok := served[key]
+		if !ok {
+			drift.Missing = append(drift.Missing, key)
+			continue
+		}
+
+		path := trimPath(r.Path)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+
+		(*item)[strings.ToLower(r.Method)] = operation(schemas, errSchema, r, opID)
+	}
+
+	for key := range served {
+		if !documented[key] {
+			drift.Undocumented = append(drift.Undocumented, key)
+		}
+	}
+
+	doc.Components.Schemas = schemas.Components()
+
+	if len(drift.Undocumented) > 0 || len(drift.Missing) > 0 {
+		sort.Strings(drift.Undocumented)
+		sort.Strings(drift.Missing)
+		return doc, drift
+	}
+
+	return doc, nil
+}
+
+func operation(schemas *Schemas, errSchema *Schema, r Route, opID string) *Operation {
+	op := &Operation{
+		OperationID: opID,
+		Summary:     r.Summary,
+		Description: r.Description,
+		Responses:   make(map[string]*Response),
+	}
+
+	if r.Tag != "" {
+		op.Tags = []string{r.Tag}
+	}
+
+	if r.Public {
+		op.Security = []map[string][]string{}
+	}
+
+	op.Parameters = parameters(r)
+
+	if r.Body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: schemas.Of(r.Body)}},
+		}
+	}
+
+	if file, ok := r.Response.(File); ok {
+		content := make(map[string]MediaType)
+		for _, mt := range file {
+			content[mt] = MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+		}
+		op.Responses["200"] = &Response{Description: "OK", Content: content}
+	} else {
+		op.Responses["200"] = &Response{
+			Description: "OK",
+			Content:     map[string]MediaType{"application/json": {Schema: schemas.Of(r.Response)}},
+		}
+	}
+
+	codes := append([]int{http.StatusInternalServerError}, r.Errors...)
+	if r.Body != nil || len(r.Params) > 0 {
+		codes = append(codes, http.StatusBadRequest)
+	}
+	if !r.Public {
+		codes = append(codes, http.StatusUnauthorized)
+	}
+
+	for _, code := range codes {
+		op.Responses[fmt.Sprint(code)] = &Response{
+			Description: http.StatusText(code),
+			Content:     map[string]MediaType{problem.ContentType: {Schema: errSchema}},
+		}
+	}
+
+	return op
+}
+
+// parameters lists the route's parameters, path parameters first in the order they
+// appear.
+func parameters(r Route) []Parameter {
+	given := make(map[string]Param)
+	for _, p := range r.Params {
+		if p.In == "path" {
+			given[p.Name] = p
+		}
+	}
+
+	var params []Parameter
+	for _, match := range pathParam.FindAllStringSubmatch(r.Path, -1) {
+		p, ok := given[match[1]]
+		if !ok {
+			p = Param{Name: match[1], In: "path"}
+		}
+		p.Required = true
+
+		params = append(params, parameter(p))
+	}
+
+	for _, p := range r.Params {
+		if p.In != "path" {
+			params = append(params, parameter(p))
+		}
+	}
+
+	return params
+}
+
+func parameter(p Param) Parameter {
+	typ := p.Type
+	if typ == "" {
+		typ = "string"
+	}
+
+	schema := &Schema{Type: typ, Enum: p.Enum}
+	if typ == "date-time" {
+		schema = &Schema{Type: "string", Format: typ}
+	}
+
+	return Parameter{
+		Name:        p.Name,
+		In:          p.In,
+		Description: p.Description,
+		Required:    p.Required,
+		Schema:      schema,
+	}
+}
+
+func routeKey(method, path string) string {
+	return method + " " + trimPath(path)
+}
+
+// trimPath drops the trailing slash of a mounted router's index route, since
+// they're redirected to the path without it, along with any regexps in parameters.
+func trimPath(path string) string {
+	path = pathParam.ReplaceAllString(path, "{$1}")
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+
+	return path
+}
+
+// handlerName names an operation after the function that created its handler, so
+// the `acceptInvitation` in `r.Patch("/{token}/accept", acceptInvitation(...))`.
+func handlerName(h http.Handler) string {
+	v := reflect.ValueOf(h)
+	if v.Kind() != reflect.Func {
+		return ""
+	}
+
+	fn := runtime.FuncForPC(v.Pointer())
+	if fn == nil {
+		return ""
+	}
+
+	// pkg/rest.acceptInvitation.func1
+	name := fn.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 {
+		return ""
+	}
+
+	return parts[1]
+}
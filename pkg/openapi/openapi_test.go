@@ -0,0 +1,168 @@
+package openapi
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+type base struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type widget struct {
+	base
+	Name    string            `json:"name"`
+	Secret  string            `json:"-"`
+	Owner   *uint             `json:"owner"`
+	Tags    []string          `json:"tags,omitempty"`
+	Labels  map[string]string `json:"labels"`
+	Related *widget           `json:"related,omitempty"`
+}
+
+type widgetDTO struct {
+	Name  string   `json:"name"`
+	Email string   `json:"email"`
+	Kind  string   `json:"kind"`
+	Tags  []string `json:"tags"`
+}
+
+func (t *widgetDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.Name, ozzo.Required, ozzo.Length(3, 10)),
+		ozzo.Field(&t.Email, is.Email),
+		ozzo.Field(&t.Kind, ozzo.Required, ozzo.In("small", "large")),
+		ozzo.Field(&t.Tags, ozzo.Each(ozzo.Length(0, 20))),
+	}
+}
+
+func TestSchemas(t *testing.T) {
+	s := NewSchemas()
+
+	if ref := s.Of([]widget{}); ref.Type != "array" || ref.Items.Ref != "#/components/schemas/Widget" {
+		t.Fatalf("Expected an array of widget references, got %+v", ref)
+	}
+
+	w := s.Components()["Widget"]
+	if w == nil {
+		t.Fatal("Expected widget to be a component")
+	}
+
+	for _, name := range []string{"id", "created_at", "name", "owner", "tags", "labels", "related"} {
+		if w.Properties[name] == nil {
+			t.Errorf("Expected widget to have %s", name)
+		}
+	}
+
+	if w.Properties["Secret"] != nil || w.Properties["base"] != nil {
+		t.Errorf("Expected hidden and embedded fields to be left out, got %v", w.Properties)
+	}
+
+	if w.Properties["created_at"].Format != "date-time" {
+		t.Errorf("Expected times to be date-times, got %+v", w.Properties["created_at"])
+	}
+
+	if !reflect.DeepEqual(w.Properties["owner"].Type, []string{"integer", "null"}) {
+		t.Errorf("Expected owner to be nullable, got %v", w.Properties["owner"].Type)
+	}
+
+	if w.Properties["related"].Ref != "#/components/schemas/Widget" {
+		t.Errorf("Expected widgets to refer to themselves, got %+v", w.Properties["related"])
+	}
+
+	expected := []string{"id", "created_at", "name", "owner", "labels"}
+	if !reflect.DeepEqual(w.Required, expected) {
+		t.Errorf("Expected %v to be required, got %v", expected, w.Required)
+	}
+}
+
+func TestSchemasRules(t *testing.T) {
+	s := NewSchemas()
+	s.Of(widgetDTO{})
+
+	dto := s.Components()["WidgetDTO"]
+	if dto == nil {
+		t.Fatal("Expected the DTO to be a component")
+	}
+
+	if !reflect.DeepEqual(dto.Required, []string{"name", "kind"}) {
+		t.Errorf("Expected required fields to come from the rules, got %v", dto.Required)
+	}
+
+	name := dto.Properties["name"]
+	if name.MinLength == nil || *name.MinLength != 3 || name.MaxLength == nil || *name.MaxLength != 10 {
+		t.Errorf("Expected name's length to be limited, got %+v", name)
+	}
+
+	if dto.Properties["email"].Format != "email" {
+		t.Errorf("Expected email to be formatted as one, got %+v", dto.Properties["email"])
+	}
+
+	if !reflect.DeepEqual(dto.Properties["kind"].Enum, []interface{}{"small", "large"}) {
+		t.Errorf("Expected kind to be an enum, got %v", dto.Properties["kind"].Enum)
+	}
+
+	if tags := dto.Properties["tags"]; tags.Items.MaxLength == nil || *tags.Items.MaxLength != 20 {
+		t.Errorf("Expected each tag's length to be limited, got %+v", tags.Items)
+	}
+}
+
+func TestBuildDrift(t *testing.T) {
+	router := chi.NewRouter()
+	router.Route("/widgets", func(r chi.Router) {
+		r.Get("/", listWidgets())
+		r.Post("/", listWidgets())
+		r.Get("/{id}", listWidgets())
+	})
+
+	docs := []Route{
+		{Method: http.MethodGet, Path: "/widgets/", Response: []widget{}},
+		{Method: http.MethodGet, Path: "/widgets/{id}", Params: []Param{{Name: "id", In: "path", Type: "integer"}}, Response: widget{}},
+		{Method: http.MethodDelete, Path: "/widgets/{id}", Response: widget{}},
+	}
+
+	doc, err := Build(Info{Title: "widgets", Version: "v1"}, router, docs)
+
+	var drift *DriftError
+	if !errors.As(err, &drift) {
+		t.Fatalf("Expected the routes to have drifted, got %v", err)
+	}
+
+	if !reflect.DeepEqual(drift.Undocumented, []string{"POST /widgets"}) {
+		t.Errorf("Expected POST /widgets to be undocumented, got %v", drift.Undocumented)
+	}
+
+	if !reflect.DeepEqual(drift.Missing, []string{"DELETE /widgets/{id}"}) {
+		t.Errorf("Expected DELETE /widgets/{id} to be missing, got %v", drift.Missing)
+	}
+
+	get := (*doc.Paths["/widgets/{id}"])["get"]
+	if get == nil {
+		t.Fatal("Expected the documented routes to still be in the spec")
+	}
+
+	if get.OperationID != "listWidgets" {
+		t.Errorf("Expected the operation to be named after its handler, got %s", get.OperationID)
+	}
+
+	if len(get.Parameters) != 1 || get.Parameters[0].Schema.Type != "integer" || !get.Parameters[0].Required {
+		t.Errorf("Expected a required integer id, got %+v", get.Parameters)
+	}
+
+	docs[2].Method = http.MethodPost
+	docs[2].Path = "/widgets"
+	if _, err := Build(Info{}, router, docs); err != nil {
+		t.Errorf("Expected no drift once every route is documented, got %v", err)
+	}
+}
+
+func listWidgets() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {}
+}
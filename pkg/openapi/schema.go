@@ -0,0 +1,339 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawType     = reflect.TypeOf(json.RawMessage{})
+	ruledType   = reflect.TypeOf((*Ruled)(nil)).Elem()
+	nullSchema  = &Schema{Type: "null"}
+	ruleFormats = map[string]string{
+		"validation_is_email":        "email",
+		"validation_is_email_format": "email",
+		"validation_is_url":          "uri",
+		"validation_is_request_url":  "uri",
+	}
+)
+
+// Ruled is implemented by request bodies whose validation rules should show up in
+// their schema. Validate is expected to check the same rules.
+type Ruled interface {
+	Rules() []*ozzo.FieldRules
+}
+
+// Schemas generates JSON schemas from Go types the way encoding/json would marshal
+// them, collecting named structs as components.
+type Schemas struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func NewSchemas() *Schemas {
+	return &Schemas{
+		components: make(map[string]*Schema),
+		names:      make(map[reflect.Type]string),
+	}
+}
+
+// Components returns every named schema generated so far.
+func (s *Schemas) Components() map[string]*Schema {
+	return s.components
+}
+
+// Of returns the schema for v's type, a reference for named structs.
+func (s *Schemas) Of(v interface{}) *Schema {
+	if v == nil {
+		return nullSchema
+	}
+
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *Schemas) schema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.schema(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: new(float64)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.ref(t)
+	default:
+		return &Schema{}
+	}
+}
+
+// ref generates the component for a named struct the first time it's seen.
+func (s *Schemas) ref(t reflect.Type) *Schema {
+	name, ok := s.names[t]
+	if !ok {
+		name = s.name(t)
+		s.names[t] = name
+
+		// placeholder first, for types that refer to themselves
+		s.components[name] = &Schema{}
+		*s.components[name] = *s.object(t)
+	}
+
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// name uses the type's name, capitalised since unexported response types are still
+// part of the API, qualified by its package when it clashes with another type.
+func (s *Schemas) name(t reflect.Type) string {
+	name := t.Name()
+
+	// generics are named after what they hold, Page[pkg/users.User] is UserPage
+	if outer, args, ok := strings.Cut(name, "["); ok {
+		var parts []string
+		for _, arg := range strings.Split(strings.TrimSuffix(args, "]"), ",") {
+			parts = append(parts, arg[strings.LastIndex(arg, ".")+1:])
+		}
+		name = strings.Join(parts, "") + outer
+	}
+
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	name = string(runes)
+
+	if _, taken := s.components[name]; taken {
+		pkg := t.PkgPath()
+		pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+
+	return name
+}
+
+func (s *Schemas) object(t reflect.Type) *Schema {
+	obj := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	s.fields(obj, t)
+
+	ptr := reflect.New(t)
+	if ptr.Type().Implements(ruledType) {
+		obj.Required = nil
+		applyRules(obj, ptr, ptr.Interface().(Ruled).Rules())
+	}
+
+	return obj
+}
+
+// fields adds t's fields to obj, anything without omitempty is required, which is
+// true of responses. Request bodies get theirs from their rules instead.
+func (s *Schemas) fields(obj *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// embedded structs are flattened like encoding/json does
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				s.fields(obj, ft)
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		omitempty := strings.Contains(opts, "omitempty")
+
+		prop := s.schema(f.Type)
+		if f.Type.Kind() == reflect.Pointer && !omitempty {
+			prop = nullable(prop)
+		}
+
+		obj.Properties[name] = prop
+		if !omitempty {
+			obj.Required = append(obj.Required, name)
+		}
+	}
+}
+
+func nullable(s *Schema) *Schema {
+	if s.Ref != "" || s.Type == nil {
+		return &Schema{AnyOf: []*Schema{s, nullSchema}}
+	}
+
+	cp := *s
+	cp.Type = []string{s.Type.(string), "null"}
+	return &cp
+}
+
+// applyRules describes the validation rules on obj's properties. ozzo doesn't
+// expose its rules, so they're read with reflection, which is fine for the handful
+// of rules we use, anything else is left out of the spec.
+func applyRules(obj *Schema, ptr reflect.Value, rules []*ozzo.FieldRules) {
+	fields := jsonFields(ptr.Elem())
+
+	for _, fr := range rules {
+		frv := reflect.ValueOf(fr).Elem()
+
+		name, ok := fields[frv.FieldByName("fieldPtr").Elem().Pointer()]
+		if !ok {
+			continue
+		}
+
+		prop := obj.Properties[name]
+		if prop == nil {
+			continue
+		}
+
+		// don't touch a component shared with other schemas
+		if prop.Ref != "" {
+			prop = &Schema{AnyOf: []*Schema{prop}}
+		} else {
+			cp := *prop
+			prop = &cp
+		}
+		obj.Properties[name] = prop
+
+		ruleList := frv.FieldByName("rules")
+		for i := 0; i < ruleList.Len(); i++ {
+			if applyRule(prop, ruleList.Index(i).Elem()) {
+				obj.Required = append(obj.Required, name)
+			}
+		}
+	}
+}
+
+// applyRule describes rule on s, returning whether it makes the field required.
+func applyRule(s *Schema, rule reflect.Value) bool {
+	switch rule.Type() {
+	case reflect.TypeOf(ozzo.RequiredRule{}):
+		return rule.FieldByName("condition").Bool() && !rule.FieldByName("skipNil").Bool()
+	case reflect.TypeOf(ozzo.LengthRule{}):
+		min, max := int(rule.FieldByName("min").Int()), int(rule.FieldByName("max").Int())
+		if min > 0 {
+			s.MinLength = &min
+		}
+		if max > 0 {
+			s.MaxLength = &max
+		}
+	case reflect.TypeOf(ozzo.InRule{}):
+		elems := rule.FieldByName("elements")
+		for i := 0; i < elems.Len(); i++ {
+			if e, ok := scalar(elems.Index(i).Elem()); ok {
+				s.Enum = append(s.Enum, e)
+			}
+		}
+	case reflect.TypeOf(ozzo.StringRule{}):
+		err := rule.FieldByName("err").Elem()
+		if err.Kind() != reflect.Struct {
+			break
+		}
+
+		code := err.FieldByName("code").String()
+		if format, ok := ruleFormats[code]; ok {
+			s.Format = format
+		} else if msg := err.FieldByName("message").String(); msg != "" {
+			s.Description = strings.TrimSpace(s.Description + " " + msg)
+		}
+	case reflect.TypeOf(ozzo.EachRule{}):
+		if s.Items == nil {
+			break
+		}
+
+		items := *s.Items
+		s.Items = &items
+
+		each := rule.FieldByName("rules")
+		for i := 0; i < each.Len(); i++ {
+			applyRule(s.Items, each.Index(i).Elem())
+		}
+	}
+
+	return false
+}
+
+// jsonFields maps the addresses of v's fields to their JSON names.
+func jsonFields(v reflect.Value) map[uintptr]string {
+	fields := make(map[uintptr]string)
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = f.Name
+		}
+
+		fields[v.Field(i).Addr().Pointer()] = name
+	}
+
+	return fields
+}
+
+// scalar reads a value we can't call Interface on, having come from an unexported
+// field.
+func scalar(v reflect.Value) (interface{}, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Bool:
+		return v.Bool(), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,71 @@
+package paging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"noxecane/go-starter/pkg/keyring"
+)
+
+var errBadSignature = errors.New("cursor signature doesn't match")
+
+// cursor marks where a page starts, by the sort values of the row before it. It's
+// only good for the sort and filters it was made with.
+type cursor struct {
+	Sort    string   `json:"s"`
+	Filters string   `json:"f"`
+	Values  []string `json:"v"`
+	// Before pages backwards from the row
+	Before bool `json:"b,omitempty"`
+}
+
+// encode signs the cursor with the keyring's active key, so clients can't make up
+// their own or edit the values.
+func (c *cursor) encode(ring keyring.Keyring) string {
+	raw, _ := json.Marshal(c)
+
+	key := ring.Active()
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + key.ID + "." + base64.RawURLEncoding.EncodeToString(sign(payload, key.Secret))
+}
+
+// decodeCursor checks the cursor was signed by a key that's still in the keyring.
+// Cursors made before they carried their key's ID count as signed by LegacyID.
+func decodeCursor(s string, ring keyring.Keyring) (*cursor, error) {
+	payload, rest, _ := strings.Cut(s, ".")
+	id, sig, ok := strings.Cut(rest, ".")
+	if !ok {
+		id, sig = keyring.LegacyID, rest
+	}
+
+	key, ok := ring.Find(id)
+	if !ok {
+		return nil, errBadSignature
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, sign(payload, key.Secret)) {
+		return nil, errBadSignature
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(cursor)
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func sign(payload string, secret []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte("paging:" + payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,124 @@
+package paging
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page is a page of results, with cursors and links to the pages either side of it
+// when there are any.
+type Page[T any] struct {
+	Items   []T     `json:"items"`
+	Cursors Cursors `json:"cursors"`
+	Links   Links   `json:"links"`
+}
+
+type Cursors struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// Links are the request's URL with the cursor swapped out, keeping the limit, sort
+// and filters.
+type Links struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// NewPage turns the rows fetched by a query applied with q into a page. The sorted
+// fields are read off the rows by their JSON names for the cursors.
+func NewPage[T any](q *Query, rows []T) Page[T] {
+	before := q.cursor != nil && q.cursor.Before
+
+	more := len(rows) > q.Limit
+	if more {
+		rows = rows[:q.Limit]
+	}
+
+	// rows were fetched backwards to go back a page
+	if before {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	page := Page[T]{Items: rows}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+
+	if len(rows) == 0 {
+		return page
+	}
+
+	// going forward there's a page behind if we came from one, going back there's
+	// always one ahead
+	hasNext, hasPrev := more, q.cursor != nil
+	if before {
+		hasNext, hasPrev = true, more
+	}
+
+	if hasNext {
+		page.Cursors.Next = q.cursorAt(rows[len(rows)-1], false)
+		page.Links.Next = q.link(page.Cursors.Next)
+	}
+
+	if hasPrev {
+		page.Cursors.Prev = q.cursorAt(rows[0], true)
+		page.Links.Prev = q.link(page.Cursors.Prev)
+	}
+
+	return page
+}
+
+func (q *Query) cursorAt(row interface{}, before bool) string {
+	sort := q.Sort
+	if q.Desc {
+		sort = "-" + sort
+	}
+
+	c := &cursor{Sort: sort, Filters: q.filterHash(), Before: before}
+	for _, k := range q.keys() {
+		c.Values = append(c.Values, jsonField(row, k))
+	}
+
+	return c.encode(q.ring)
+}
+
+func (q *Query) link(cursor string) string {
+	u := q.url
+
+	values := u.Query()
+	values.Set(CursorParam, cursor)
+	u.RawQuery = values.Encode()
+
+	return u.RequestURI()
+}
+
+// jsonField reads the field of the struct with the given JSON name as a string.
+func jsonField(row interface{}, name string) string {
+	v := reflect.Indirect(reflect.ValueOf(row))
+
+	for i := 0; i < v.NumField(); i++ {
+		tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if tag != name {
+			continue
+		}
+
+		switch f := reflect.Indirect(v.Field(i)).Interface().(type) {
+		case time.Time:
+			return f.Format(time.RFC3339Nano)
+		case string:
+			return f
+		case bool:
+			return strconv.FormatBool(f)
+		default:
+			return fmt.Sprint(f)
+		}
+	}
+
+	panic(fmt.Sprintf("paging: %T has no field with the JSON name %s", row, name))
+}
@@ -0,0 +1,286 @@
+// Package paging parses the limit, cursor, sort and filters of list endpoints against
+// what each resource allows, applies them to queries and pages through the results
+// with signed cursors.
+package paging
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/problem"
+
+	"github.com/uptrace/bun"
+)
+
+// Type is the type of a field's values, for parsing filters and cursors.
+type Type int
+
+const (
+	String Type = iota
+	Int
+	Time
+	Bool
+)
+
+// Query parameters that aren't filters.
+const (
+	LimitParam  = "limit"
+	CursorParam = "cursor"
+	SortParam   = "sort"
+)
+
+var (
+	ErrInvalidQuery  = problem.New(http.StatusBadRequest, "invalid_query", "Some of the query parameters are invalid")
+	ErrInvalidCursor = problem.New(http.StatusBadRequest, "invalid_cursor", "This cursor is invalid or was made for another listing")
+)
+
+// operators are the filters fields take on top of equality, as `field.op=value`.
+// Ranges only make sense for numbers and times.
+var operators = map[string]string{
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+// Field is a field of a resource that can be sorted or filtered on, named after its
+// JSON name so cursors can be read off the results.
+type Field struct {
+	// Column is the SQL for the field, like `?TableAlias.created_at` or `m.role`
+	Column string
+	Type   Type
+	Sort   bool
+	Filter bool
+	// Value turns filter values into what's in Column, in place of Type, for columns
+	// that don't hold the value as it's shown, like a blind index
+	Value func(raw string) (interface{}, error)
+}
+
+// Spec is what a list endpoint allows. Sorted fields shouldn't be null.
+type Spec struct {
+	Fields map[string]Field
+	// Key is a unique field that breaks ties between rows sorted on other fields
+	Key string
+	// DefaultSort is the field to sort on when none is given, prefixed with - to sort
+	// in descending order
+	DefaultSort  string
+	DefaultLimit int
+	MaxLimit     int
+}
+
+// Filter is a condition on a field, Values holding more than one for `IN`.
+type Filter struct {
+	Field  string
+	Op     string
+	Values []interface{}
+}
+
+// Query is a parsed page request.
+type Query struct {
+	spec    Spec
+	ring    keyring.Keyring
+	url     url.URL
+	Limit   int
+	Sort    string
+	Desc    bool
+	Filters []Filter
+	cursor  *cursor
+}
+
+// Parse reads the page request from r's query. Anything it doesn't allow is
+// rejected with ErrInvalidQuery blaming the parameter, and cursors that weren't
+// signed by a key in keys or were made for another sort or filter with ErrInvalidCursor.
+func Parse(r *http.Request, spec Spec, keys keyring.Keyring) (*Query, error) {
+	values := r.URL.Query()
+	q := &Query{spec: spec, ring: keys, url: *r.URL, Limit: spec.DefaultLimit}
+
+	if v := values.Get(LimitParam); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > spec.MaxLimit {
+			return nil, invalid(LimitParam, fmt.Sprintf("limit must be between 1 and %d", spec.MaxLimit))
+		}
+		q.Limit = limit
+	}
+
+	sortBy := values.Get(SortParam)
+	if sortBy == "" {
+		sortBy = spec.DefaultSort
+	}
+
+	q.Sort = strings.TrimPrefix(sortBy, "-")
+	q.Desc = strings.HasPrefix(sortBy, "-")
+	if f, ok := spec.Fields[q.Sort]; !ok || !f.Sort {
+		return nil, invalid(SortParam, fmt.Sprintf("can't sort by %s, only by %s", q.Sort, strings.Join(spec.sortable(), ", ")))
+	}
+
+	// sorted so the same filters always make the same cursor
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == LimitParam || name == CursorParam || name == SortParam {
+			continue
+		}
+
+		filter, err := spec.filter(name, values.Get(name))
+		if err != nil {
+			return nil, err
+		}
+		q.Filters = append(q.Filters, filter)
+	}
+
+	if v := values.Get(CursorParam); v != "" {
+		c, err := decodeCursor(v, keys)
+		if err != nil || c.Sort != sortBy || c.Filters != q.filterHash() || len(c.Values) != len(q.keys()) {
+			return nil, ErrInvalidCursor
+		}
+		q.cursor = c
+	}
+
+	return q, nil
+}
+
+func (s Spec) sortable() []string {
+	var names []string
+	for name, f := range s.Fields {
+		if f.Sort {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (s Spec) filter(param, raw string) (Filter, error) {
+	name, op, _ := strings.Cut(param, ".")
+
+	f, ok := s.Fields[name]
+	if !ok || !f.Filter {
+		return Filter{}, invalid(param, fmt.Sprintf("can't filter by %s", name))
+	}
+
+	filter := Filter{Field: name, Op: "="}
+	if op != "" {
+		if filter.Op, ok = operators[op]; !ok || (f.Type != Int && f.Type != Time) {
+			return Filter{}, invalid(param, fmt.Sprintf("can't filter %s with %s", name, op))
+		}
+	}
+
+	// only equality takes a list
+	parts := []string{raw}
+	if filter.Op == "=" {
+		parts = strings.Split(raw, ",")
+	}
+
+	for _, part := range parts {
+		parse := func(raw string) (interface{}, error) { return parseValue(f.Type, raw) }
+		if f.Value != nil {
+			parse = f.Value
+		}
+
+		v, err := parse(part)
+		if err != nil {
+			return Filter{}, invalid(param, fmt.Sprintf("%s is not a valid value for %s", part, name))
+		}
+		filter.Values = append(filter.Values, v)
+	}
+
+	return filter, nil
+}
+
+func parseValue(t Type, raw string) (interface{}, error) {
+	switch t {
+	case Int:
+		return strconv.ParseInt(raw, 10, 64)
+	case Time:
+		return time.Parse(time.RFC3339Nano, raw)
+	case Bool:
+		return strconv.ParseBool(raw)
+	default:
+		return raw, nil
+	}
+}
+
+func invalid(param, message string) *problem.Error {
+	return ErrInvalidQuery.Msg(message).Field(param, "invalid", message)
+}
+
+// keys are the fields rows are ordered by, the sort field then the key to break ties.
+func (q *Query) keys() []string {
+	if q.Sort == q.spec.Key {
+		return []string{q.Sort}
+	}
+
+	return []string{q.Sort, q.spec.Key}
+}
+
+func (q *Query) filterHash() string {
+	parts := make([]string, len(q.Filters))
+	for i, f := range q.Filters {
+		parts[i] = fmt.Sprintf("%s %s %v", f.Field, f.Op, f.Values)
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// Apply filters, orders and limits sq to the page asked for. It fetches one row more
+// than the limit, to tell if there's another page, which Page trims off.
+func (q *Query) Apply(sq *bun.SelectQuery) *bun.SelectQuery {
+	for _, f := range q.Filters {
+		col := q.spec.Fields[f.Field].Column
+		if len(f.Values) > 1 {
+			sq = sq.Where(col+" IN (?)", bun.In(f.Values))
+		} else {
+			sq = sq.Where(col+" "+f.Op+" ?", f.Values[0])
+		}
+	}
+
+	keys := q.keys()
+	cols := make([]string, len(keys))
+	for i, k := range keys {
+		cols[i] = q.spec.Fields[k].Column
+	}
+
+	// going back a page walks the rows the other way, Page flips them back
+	desc := q.Desc
+	if q.cursor != nil && q.cursor.Before {
+		desc = !desc
+	}
+
+	if q.cursor != nil {
+		op := ">"
+		if desc {
+			op = "<"
+		}
+
+		args := make([]interface{}, len(keys))
+		placeholders := make([]string, len(keys))
+		for i, k := range keys {
+			args[i], _ = parseValue(q.spec.Fields[k].Type, q.cursor.Values[i])
+			placeholders[i] = "?"
+		}
+
+		sq = sq.Where(fmt.Sprintf("(%s) %s (%s)", strings.Join(cols, ", "), op, strings.Join(placeholders, ", ")), args...)
+	}
+
+	dir := " ASC"
+	if desc {
+		dir = " DESC"
+	}
+
+	for _, col := range cols {
+		sq = sq.OrderExpr(col + dir)
+	}
+
+	return sq.Limit(q.Limit + 1)
+}
@@ -0,0 +1,233 @@
+package paging
+
+import (
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/problem"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+var (
+	oldKey = keyring.Key{ID: "old", Secret: []byte("old-paging-secret")}
+	newKey = keyring.Key{ID: "new", Secret: []byte("new-paging-secret")}
+	keys   = mustKeyring(oldKey)
+)
+
+func mustKeyring(k ...keyring.Key) keyring.Keyring {
+	ring, err := keyring.New(k...)
+	if err != nil {
+		panic(err)
+	}
+
+	return ring
+}
+
+type row struct {
+	bun.BaseModel `bun:"table:rows,alias:r"`
+
+	ID        int64     `bun:",pk" json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var spec = Spec{
+	Fields: map[string]Field{
+		"id":         {Column: "?TableAlias.id", Type: Int, Sort: true, Filter: true},
+		"name":       {Column: "?TableAlias.name", Sort: true, Filter: true},
+		"created_at": {Column: "?TableAlias.created_at", Type: Time, Sort: true, Filter: true},
+	},
+	Key:          "id",
+	DefaultSort:  "id",
+	DefaultLimit: 2,
+	MaxLimit:     10,
+}
+
+func parse(t *testing.T, query string) (*Query, error) {
+	t.Helper()
+	return Parse(httptest.NewRequest("GET", "/rows?"+query, nil), spec, keys)
+}
+
+func sqlOf(q *Query) string {
+	db := bun.NewDB(&sql.DB{}, pgdialect.New())
+	return q.Apply(db.NewSelect().Model((*row)(nil))).String()
+}
+
+func rows(ids ...int64) []row {
+	rx := make([]row, len(ids))
+	for i, id := range ids {
+		rx[i] = row{ID: id, Name: "row", CreatedAt: time.Unix(id, 0).UTC()}
+	}
+	return rx
+}
+
+func TestParseInvalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		err   error
+		field string
+	}{
+		{"limit too big", "limit=11", ErrInvalidQuery, LimitParam},
+		{"limit not a number", "limit=ten", ErrInvalidQuery, LimitParam},
+		{"unknown sort", "sort=-secret", ErrInvalidQuery, SortParam},
+		{"unknown filter", "secret=1", ErrInvalidQuery, "secret"},
+		{"range on a string", "name.gt=a", ErrInvalidQuery, "name.gt"},
+		{"unknown operator", "id.like=1", ErrInvalidQuery, "id.like"},
+		{"bad value", "created_at.gte=yesterday", ErrInvalidQuery, "created_at.gte"},
+		{"made up cursor", "cursor=abc.def", ErrInvalidCursor, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parse(t, tc.query)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Expected %v, got %v", tc.err, err)
+			}
+
+			var p *problem.Error
+			if tc.field != "" && (!errors.As(err, &p) || len(p.Fields) != 1 || p.Fields[0].Field != tc.field) {
+				t.Errorf("Expected the error to blame %s, got %+v", tc.field, err)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	q, err := parse(t, "sort=-created_at&name=a,b&id.gte=3&limit=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := sqlOf(q)
+	for _, part := range []string{
+		`"r".id >= 3`,
+		`"r".name IN ('a', 'b')`,
+		`ORDER BY "r".created_at DESC, "r".id DESC`,
+		`LIMIT 6`,
+	} {
+		if !strings.Contains(query, part) {
+			t.Errorf("Expected %s in %s", part, query)
+		}
+	}
+}
+
+func TestPages(t *testing.T) {
+	q, err := parse(t, "name=row")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := NewPage(q, rows(1, 2, 3))
+	if len(first.Items) != 2 || first.Cursors.Next == "" || first.Cursors.Prev != "" {
+		t.Fatalf("Expected a first page with only a next cursor, got %+v", first)
+	}
+
+	next, err := url.Parse(first.Links.Next)
+	if err != nil || next.Query().Get("name") != "row" || next.Query().Get(CursorParam) != first.Cursors.Next {
+		t.Fatalf("Expected the next link to keep the filters, got %s", first.Links.Next)
+	}
+
+	if q, err = parse(t, next.RawQuery); err != nil {
+		t.Fatal(err)
+	}
+
+	if query := sqlOf(q); !strings.Contains(query, `("r".id) > (2)`) {
+		t.Errorf("Expected the second page to start after the first, got %s", query)
+	}
+
+	second := NewPage(q, rows(3))
+	if len(second.Items) != 1 || second.Cursors.Next != "" || second.Cursors.Prev == "" {
+		t.Fatalf("Expected a last page with only a prev cursor, got %+v", second)
+	}
+
+	prev, _ := url.Parse(second.Links.Prev)
+	if q, err = parse(t, prev.RawQuery); err != nil {
+		t.Fatal(err)
+	}
+
+	if query := sqlOf(q); !strings.Contains(query, `("r".id) < (3)`) || !strings.Contains(query, `ORDER BY "r".id DESC`) {
+		t.Errorf("Expected going back to walk the rows backwards, got %s", query)
+	}
+
+	// fetched backwards, with nothing before them
+	back := NewPage(q, rows(2, 1))
+	if back.Items[0].ID != 1 || back.Cursors.Prev != "" || back.Cursors.Next == "" {
+		t.Errorf("Expected the first page again, in order, got %+v", back)
+	}
+}
+
+func TestCursorScope(t *testing.T) {
+	q, err := parse(t, "name=row")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewPage(q, rows(1, 2, 3)).Cursors.Next
+
+	for _, query := range []string{
+		"name=other&cursor=" + c,
+		"name=row&sort=-id&cursor=" + c,
+		"name=row&cursor=" + c[:len(c)-2] + "xx",
+	} {
+		if _, err := parse(t, query); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected %s to be rejected, got %v", query, err)
+		}
+	}
+
+	// rotating to a new key keeps old cursors working until the old key is removed
+	for _, tc := range []struct {
+		keys  keyring.Keyring
+		valid bool
+	}{
+		{mustKeyring(newKey, oldKey), true},
+		{mustKeyring(newKey), false},
+		{mustKeyring(keyring.Key{ID: "old", Secret: []byte("another-secret!!")}), false},
+	} {
+		_, err := Parse(httptest.NewRequest("GET", "/rows?name=row&cursor="+c, nil), spec, tc.keys)
+		if tc.valid && err != nil {
+			t.Errorf("Expected the cursor to work with %s, got %v", tc.keys, err)
+		} else if !tc.valid && !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected the cursor to be rejected with %s, got %v", tc.keys, err)
+		}
+	}
+}
+
+func TestLegacyCursor(t *testing.T) {
+	legacyKey := keyring.Key{ID: keyring.LegacyID, Secret: []byte("legacy-paging-secret")}
+
+	q, err := Parse(httptest.NewRequest("GET", "/rows?name=row", nil), spec, mustKeyring(legacyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cursors from before key IDs were payload.sig
+	c := NewPage(q, rows(1, 2, 3)).Cursors.Next
+	payload, rest, _ := strings.Cut(c, ".")
+	_, sig, _ := strings.Cut(rest, ".")
+	legacy := payload + "." + sig
+
+	for _, tc := range []struct {
+		keys  keyring.Keyring
+		valid bool
+	}{
+		{mustKeyring(legacyKey), true},
+		{mustKeyring(newKey, legacyKey), true},
+		{mustKeyring(newKey), false},
+	} {
+		_, err := Parse(httptest.NewRequest("GET", "/rows?name=row&cursor="+legacy, nil), spec, tc.keys)
+		if tc.valid && err != nil {
+			t.Errorf("Expected the old cursor to work with %s, got %v", tc.keys, err)
+		} else if !tc.valid && !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected the old cursor to be rejected with %s, got %v", tc.keys, err)
+		}
+	}
+}
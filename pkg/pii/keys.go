@@ -0,0 +1,264 @@
+package pii
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"noxecane/go-starter/pkg/keyring"
+
+	"github.com/uptrace/bun"
+)
+
+// What a key in pii_keys is for. The newest activated data key encrypts, the others
+// are kept for what they encrypted or will encrypt. There's only ever one index key,
+// changing it would change every blind index.
+const (
+	purposeData  = "data"
+	purposeIndex = "index"
+)
+
+// keysLockID is held while keys are created or rewrapped, so replicas starting
+// together don't make a key each.
+var keysLockID = func() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("noxecane/go-starter pii keys"))
+	return int64(h.Sum64())
+}()
+
+type storedKey struct {
+	bun.BaseModel `bun:"table:pii_keys"`
+
+	ID          int       `bun:",pk,autoincrement"`
+	CreatedAt   time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	ActivatedAt *time.Time
+	Purpose     string
+	MasterKey   string
+	Wrapped     []byte
+}
+
+// Setup loads the keys with Load and uses them for Text and Index.
+func Setup(ctx context.Context, db bun.IDB, master keyring.Keyring) error {
+	c, err := Load(ctx, db, master)
+	if err != nil {
+		return err
+	}
+
+	Use(c)
+	return nil
+}
+
+// Load unwraps every key in pii_keys with the master keys, creating the first data
+// and index keys if there are none yet. It fails if any key was wrapped by a master
+// key that's no longer in master, as whatever it encrypted would be lost.
+func Load(ctx context.Context, db bun.IDB, master keyring.Keyring) (*Cipher, error) {
+	var keys []storedKey
+
+	err := withKeysLock(ctx, db, func(ctx context.Context, tx bun.Tx) error {
+		if err := tx.NewSelect().Model(&keys).Order("id").Scan(ctx); err != nil {
+			return err
+		}
+
+		for _, purpose := range []string{purposeData, purposeIndex} {
+			if newest(keys, purpose) != nil {
+				continue
+			}
+
+			k, err := createKey(ctx, tx, master.Active(), purpose, true)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, *k)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newCipher(master, keys)
+}
+
+// Rotate adds a data key that isn't used for encrypting until it's activated, so
+// every instance can be restarted to know it before anything is encrypted with it.
+func Rotate(ctx context.Context, db bun.IDB, master keyring.Keyring) error {
+	return withKeysLock(ctx, db, func(ctx context.Context, tx bun.Tx) error {
+		_, err := createKey(ctx, tx, master.Active(), purposeData, false)
+		return err
+	})
+}
+
+// Activate lets the data keys from Rotate encrypt, from the next Load on. It returns
+// how many keys were activated.
+func Activate(ctx context.Context, db bun.IDB) (int, error) {
+	res, err := db.
+		NewUpdate().
+		Model((*storedKey)(nil)).
+		Set("activated_at = current_timestamp").
+		Where("purpose = ?", purposeData).
+		Where("activated_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Rewrap wraps every key with the active master key, so the others can be removed
+// from PII_KEYS. It returns how many keys were rewrapped.
+func Rewrap(ctx context.Context, db bun.IDB, master keyring.Keyring) (int, error) {
+	active := master.Active()
+	rewrapped := 0
+
+	err := withKeysLock(ctx, db, func(ctx context.Context, tx bun.Tx) error {
+		var keys []storedKey
+		if err := tx.NewSelect().Model(&keys).Where("master_key <> ?", active.ID).Scan(ctx); err != nil {
+			return err
+		}
+
+		for i := range keys {
+			raw, err := unwrap(master, keys[i])
+			if err != nil {
+				return err
+			}
+
+			if keys[i].Wrapped, err = wrap(active, keys[i].Purpose, raw); err != nil {
+				return err
+			}
+			keys[i].MasterKey = active.ID
+
+			if _, err := tx.NewUpdate().Model(&keys[i]).Column("master_key", "wrapped").WherePK().Exec(ctx); err != nil {
+				return err
+			}
+		}
+
+		rewrapped = len(keys)
+		return nil
+	})
+
+	return rewrapped, err
+}
+
+func withKeysLock(ctx context.Context, db bun.IDB, fn func(ctx context.Context, tx bun.Tx) error) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", keysLockID); err != nil {
+			return err
+		}
+
+		return fn(ctx, tx)
+	})
+}
+
+func createKey(ctx context.Context, tx bun.Tx, master keyring.Key, purpose string, activate bool) (*storedKey, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := wrap(master, purpose, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &storedKey{Purpose: purpose, MasterKey: master.ID, Wrapped: wrapped}
+	if activate {
+		now := time.Now()
+		k.ActivatedAt = &now
+	}
+
+	_, err = tx.NewInsert().Model(k).Column("purpose", "master_key", "wrapped", "activated_at").Returning("*").Exec(ctx)
+
+	return k, err
+}
+
+func newCipher(master keyring.Keyring, keys []storedKey) (*Cipher, error) {
+	c := &Cipher{data: make(map[int]cipher.AEAD)}
+
+	for _, k := range keys {
+		raw, err := unwrap(master, k)
+		if err != nil {
+			return nil, err
+		}
+
+		switch k.Purpose {
+		case purposeData:
+			if c.data[k.ID], err = newAEAD(raw); err != nil {
+				return nil, err
+			}
+		case purposeIndex:
+			c.index = raw
+		}
+	}
+
+	active := newest(keys, purposeData)
+	if active == nil || c.index == nil {
+		return nil, errors.New("pii: missing a data or index key")
+	}
+	c.active = active.ID
+
+	return c, nil
+}
+
+// newest is the last activated key created for purpose.
+func newest(keys []storedKey, purpose string) *storedKey {
+	var last *storedKey
+	for i := range keys {
+		if keys[i].Purpose == purpose && keys[i].ActivatedAt != nil && (last == nil || keys[i].ID > last.ID) {
+			last = &keys[i]
+		}
+	}
+
+	return last
+}
+
+// masterAEAD hashes the master key's secret to an AES-256 key.
+func masterAEAD(master keyring.Key) (cipher.AEAD, error) {
+	key := sha256.Sum256(master.Secret)
+	return newAEAD(key[:])
+}
+
+func wrap(master keyring.Key, purpose string, raw []byte) ([]byte, error) {
+	aead, err := masterAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// the purpose is bound to the key, so an index key can't pass for a data key
+	return aead.Seal(nonce, nonce, raw, []byte(purpose)), nil
+}
+
+func unwrap(master keyring.Keyring, k storedKey) ([]byte, error) {
+	mk, ok := master.Find(k.MasterKey)
+	if !ok {
+		return nil, fmt.Errorf("pii: key %d is wrapped with master key %s, which isn't in PII_KEYS", k.ID, k.MasterKey)
+	}
+
+	aead, err := masterAEAD(mk)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(k.Wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("pii: key %d is malformed", k.ID)
+	}
+
+	raw, err := aead.Open(nil, k.Wrapped[:aead.NonceSize()], k.Wrapped[aead.NonceSize():], []byte(k.Purpose))
+	if err != nil {
+		return nil, fmt.Errorf("pii: can't unwrap key %d with master key %s: %w", k.ID, k.MasterKey, err)
+	}
+
+	return raw, nil
+}
@@ -0,0 +1,133 @@
+// Package pii keeps personal data encrypted at rest with envelope encryption. Values
+// are encrypted with AES-256-GCM under a data key, data keys are kept in pii_keys
+// wrapped by a master key from PII_KEYS, and blind indexes (an HMAC of the value) let
+// encrypted columns still be looked up and kept unique.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Prefix marks encrypted values, followed by the ID of their data key. Anything
+// without it is plaintext from before encryption was turned on.
+const Prefix = "pii:v1:"
+
+var ErrNoCipher = errors.New("pii: no cipher has been set up")
+
+// Cipher encrypts with the newest data key and decrypts with any of them.
+type Cipher struct {
+	active int
+	data   map[int]cipher.AEAD
+	index  []byte
+}
+
+var current atomic.Pointer[Cipher]
+
+// Use makes c the cipher Text and Index work with.
+func Use(c *Cipher) {
+	current.Store(c)
+}
+
+// Current is the cipher set up with Use.
+func Current() (*Cipher, error) {
+	c := current.Load()
+	if c == nil {
+		return nil, ErrNoCipher
+	}
+
+	return c, nil
+}
+
+// Index is the blind index of plain with the current cipher.
+func Index(plain string) ([]byte, error) {
+	c, err := Current()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Index(plain), nil
+}
+
+// Encrypt encrypts plain with the active data key.
+func (c *Cipher) Encrypt(plain string) (string, error) {
+	aead := c.data[c.active]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+	return c.ActivePrefix() + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value from Encrypt with whichever data key it names. Values
+// without Prefix are returned as they are.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return value, nil
+	}
+
+	rawID, encoded, ok := strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+	if !ok {
+		return "", errors.New("pii: malformed encrypted value")
+	}
+
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		return "", errors.New("pii: malformed encrypted value")
+	}
+
+	aead, ok := c.data[id]
+	if !ok {
+		return "", fmt.Errorf("pii: data key %d isn't known", id)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("pii: malformed encrypted value")
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("pii: can't decrypt with data key %d: %w", id, err)
+	}
+
+	return string(plain), nil
+}
+
+// Index is the blind index of plain, the same every time for the same value. Empty
+// values have none, so they stay null and out of unique indexes.
+func (c *Cipher) Index(plain string) []byte {
+	if plain == "" {
+		return nil
+	}
+
+	h := hmac.New(sha256.New, c.index)
+	h.Write([]byte(plain))
+	return h.Sum(nil)
+}
+
+// ActivePrefix starts every value encrypted with the active data key.
+func (c *Cipher) ActivePrefix() string {
+	return Prefix + strconv.Itoa(c.active) + ":"
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
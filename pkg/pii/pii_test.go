@@ -0,0 +1,213 @@
+package pii
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"noxecane/go-starter/pkg/keyring"
+)
+
+func mustKeyring(t *testing.T, s string) keyring.Keyring {
+	t.Helper()
+
+	ring, err := keyring.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ring
+}
+
+// storedKeys makes keys as Load would find them in pii_keys, the last data key
+// being pending when activated is false.
+func storedKeys(t *testing.T, master keyring.Keyring, data int, activated bool) []storedKey {
+	t.Helper()
+
+	now := time.Now()
+	var keys []storedKey
+	for i := 0; i <= data; i++ {
+		purpose := purposeData
+		if i == 0 {
+			purpose = purposeIndex
+		}
+
+		raw := bytes.Repeat([]byte{byte(i + 1)}, 32)
+		wrapped, err := wrap(master.Active(), purpose, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k := storedKey{ID: i + 1, Purpose: purpose, MasterKey: master.Active().ID, Wrapped: wrapped, ActivatedAt: &now}
+		if i == data && !activated {
+			k.ActivatedAt = nil
+		}
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+func TestCipher(t *testing.T) {
+	master := mustKeyring(t, "m1:the-first-master-key")
+
+	c, err := newCipher(master, storedKeys(t, master, 1, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := c.Encrypt("jane@noxecane.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(encrypted, c.ActivePrefix()) || strings.Contains(encrypted, "jane") {
+		t.Errorf("Expected the value to be encrypted with the active key, got %s", encrypted)
+	}
+
+	if again, _ := c.Encrypt("jane@noxecane.com"); again == encrypted {
+		t.Error("Expected every encryption to be different")
+	}
+
+	if plain, err := c.Decrypt(encrypted); err != nil || plain != "jane@noxecane.com" {
+		t.Errorf("Expected to decrypt the value, got %q %v", plain, err)
+	}
+
+	if plain, err := c.Decrypt("john@noxecane.com"); err != nil || plain != "john@noxecane.com" {
+		t.Errorf("Expected plaintext to be left alone, got %q %v", plain, err)
+	}
+
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	if _, err := c.Decrypt(tampered); err == nil {
+		t.Error("Expected tampered values to be rejected")
+	}
+
+	if !bytes.Equal(c.Index("jane@noxecane.com"), c.Index("jane@noxecane.com")) || bytes.Equal(c.Index("jane@noxecane.com"), c.Index("john@noxecane.com")) {
+		t.Error("Expected the index to be the same for the same value only")
+	}
+
+	if c.Index("") != nil {
+		t.Error("Expected empty values to have no index")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	master := mustKeyring(t, "m1:the-first-master-key")
+
+	before, err := newCipher(master, storedKeys(t, master, 1, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := before.Encrypt("jane@noxecane.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new data key is known before it's used
+	pending, err := newCipher(master, storedKeys(t, master, 2, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pending.ActivePrefix() != before.ActivePrefix() {
+		t.Errorf("Expected the pending key not to be used, got %s", pending.ActivePrefix())
+	}
+
+	after, err := newCipher(master, storedKeys(t, master, 2, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := after.Encrypt("jane@noxecane.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []*Cipher{pending, after} {
+		for _, value := range []string{old, current} {
+			if plain, err := c.Decrypt(value); err != nil || plain != "jane@noxecane.com" {
+				t.Errorf("Expected %s to decrypt with every key known, got %q %v", value, plain, err)
+			}
+		}
+	}
+
+	if _, err := before.Decrypt(current); err == nil {
+		t.Error("Expected values from unknown keys to be rejected")
+	}
+
+	if !bytes.Equal(before.Index("jane@noxecane.com"), after.Index("jane@noxecane.com")) {
+		t.Error("Expected the index to survive rotating data keys")
+	}
+}
+
+func TestMasterKeys(t *testing.T) {
+	old := mustKeyring(t, "m1:the-first-master-key")
+	keys := storedKeys(t, old, 1, true)
+
+	// the new master key goes first, the old one stays until everything's rewrapped
+	both := mustKeyring(t, "m2:the-second-master-key,m1:the-first-master-key")
+	if _, err := newCipher(both, keys); err != nil {
+		t.Fatalf("Expected keys wrapped with the old master key to load, got %v", err)
+	}
+
+	for i := range keys {
+		raw, err := unwrap(both, keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if keys[i].Wrapped, err = wrap(both.Active(), keys[i].Purpose, raw); err != nil {
+			t.Fatal(err)
+		}
+		keys[i].MasterKey = both.Active().ID
+	}
+
+	if _, err := newCipher(mustKeyring(t, "m2:the-second-master-key"), keys); err != nil {
+		t.Errorf("Expected rewrapped keys to load without the old master key, got %v", err)
+	}
+
+	if _, err := newCipher(mustKeyring(t, "m1:the-first-master-key"), keys); err == nil {
+		t.Error("Expected keys to need their master key")
+	}
+
+	// keys can't swap purposes
+	keys[0].Purpose, keys[1].Purpose = keys[1].Purpose, keys[0].Purpose
+	if _, err := newCipher(mustKeyring(t, "m2:the-second-master-key"), keys); err == nil {
+		t.Error("Expected keys with the wrong purpose to be rejected")
+	}
+}
+
+func TestText(t *testing.T) {
+	Use(nil)
+	if _, err := Text("jane").Value(); err != ErrNoCipher {
+		t.Errorf("Expected text not to be stored without a cipher, got %v", err)
+	}
+
+	master := mustKeyring(t, "m1:the-first-master-key")
+	c, err := newCipher(master, storedKeys(t, master, 1, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Use(c)
+	t.Cleanup(func() { Use(nil) })
+
+	v, err := Text("jane").Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text Text
+	if err := text.Scan(v); err != nil || text != "jane" {
+		t.Errorf("Expected the text back, got %q %v", text, err)
+	}
+
+	if v, err := Text("").Value(); err != nil || v != nil {
+		t.Errorf("Expected empty text to be null, got %v %v", v, err)
+	}
+
+	if err := text.Scan(nil); err != nil || text != "" {
+		t.Errorf("Expected null to be empty, got %q %v", text, err)
+	}
+}
@@ -0,0 +1,53 @@
+package pii
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// Text is a string that's encrypted in the database and plain everywhere else, using
+// the cipher set up with Use. Empty strings are stored as null.
+type Text string
+
+// Value encrypts the text for the database.
+func (t Text) Value() (driver.Value, error) {
+	if t == "" {
+		return nil, nil
+	}
+
+	c, err := Current()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Encrypt(string(t))
+}
+
+// Scan decrypts the text from the database, nulls being empty.
+func (t *Text) Scan(src interface{}) error {
+	var value string
+	switch src := src.(type) {
+	case nil:
+		*t = ""
+		return nil
+	case string:
+		value = src
+	case []byte:
+		value = string(src)
+	default:
+		return fmt.Errorf("pii: can't scan %T into Text", src)
+	}
+
+	c, err := Current()
+	if err != nil {
+		return err
+	}
+
+	plain, err := c.Decrypt(value)
+	if err != nil {
+		return err
+	}
+
+	*t = Text(plain)
+	return nil
+}
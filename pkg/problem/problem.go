@@ -0,0 +1,139 @@
+// Package problem describes API errors with codes clients can act on, sent as RFC
+// 7807 problem details.
+package problem
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// ContentType is the media type of every error response.
+const ContentType = "application/problem+json"
+
+// Generic problems, for errors that don't deserve a code of their own.
+var (
+	BadRequest           = New(http.StatusBadRequest, "bad_request", "We cannot parse your request")
+	ValidationFailed     = New(http.StatusBadRequest, "validation_failed", "We could not validate your request")
+	Unauthenticated      = New(http.StatusUnauthorized, "unauthenticated", "Your request is not authenticated")
+	Forbidden            = New(http.StatusForbidden, "forbidden", "You are not allowed to do this")
+	NotFound             = New(http.StatusNotFound, "not_found", "This does not exist")
+	RouteNotFound        = New(http.StatusNotFound, "route_not_found", "Whoops!! This route doesn't exist")
+	MethodNotAllowed     = New(http.StatusMethodNotAllowed, "method_not_allowed", "This route doesn't support this method")
+	Conflict             = New(http.StatusConflict, "conflict", "This conflicts with the current state of things")
+	UnsupportedMediaType = New(http.StatusUnsupportedMediaType, "unsupported_media_type", "We only accept JSON")
+	Internal             = New(http.StatusInternalServerError, "internal_error", "Something went wrong on our end")
+	Timeout              = New(http.StatusGatewayTimeout, "timeout", "Your request took too long")
+)
+
+// byStatus picks a generic problem for errors that only come with a status.
+var byStatus = map[int]*Error{
+	http.StatusBadRequest:           BadRequest,
+	http.StatusUnauthorized:         Unauthenticated,
+	http.StatusForbidden:            Forbidden,
+	http.StatusNotFound:             NotFound,
+	http.StatusMethodNotAllowed:     MethodNotAllowed,
+	http.StatusConflict:             Conflict,
+	http.StatusUnsupportedMediaType: UnsupportedMediaType,
+	http.StatusInternalServerError:  Internal,
+	http.StatusGatewayTimeout:       Timeout,
+}
+
+// Error is an error clients can act on. Its code never changes once clients have
+// seen it, unlike its message which is meant for people.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+// FieldError explains what's wrong with a single field of the request, nested
+// fields are joined with dots, like `0.email_address`.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// New creates a problem for the catalogue. Handlers panic with them, or with a copy
+// from Msg, Field or Wrap, never changing the original.
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is matches problems by their code, so errors.Is(err, problem.NotFound) holds for
+// copies made with Msg and the like.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Msg returns a copy of the problem with a more specific message.
+func (e *Error) Msg(message string) *Error {
+	cp := *e
+	cp.Message = message
+	return &cp
+}
+
+// Field returns a copy of the problem blaming the given field.
+func (e *Error) Field(field, code, message string) *Error {
+	cp := *e
+	cp.Fields = append(append([]FieldError(nil), e.Fields...), FieldError{Field: field, Code: code, Message: message})
+	return &cp
+}
+
+// Wrap returns a copy of the problem caused by err. err is only logged, never sent.
+func (e *Error) Wrap(err error) *Error {
+	cp := *e
+	cp.Err = err
+	return &cp
+}
+
+// Invalid turns validation errors into a problem with an entry for every field.
+func Invalid(errs ozzo.Errors) *Error {
+	cp := *ValidationFailed
+	cp.Fields = fieldErrors("", errs)
+	cp.Err = errs
+
+	sort.Slice(cp.Fields, func(i, j int) bool {
+		return cp.Fields[i].Field < cp.Fields[j].Field
+	})
+
+	return &cp
+}
+
+func fieldErrors(prefix string, errs ozzo.Errors) []FieldError {
+	var fields []FieldError
+	for name, err := range errs {
+		field := name
+		if prefix != "" {
+			field = prefix + "." + name
+		}
+
+		switch e := err.(type) {
+		case ozzo.Errors:
+			fields = append(fields, fieldErrors(field, e)...)
+		case ozzo.Error:
+			fields = append(fields, FieldError{Field: field, Code: strings.TrimPrefix(e.Code(), "validation_"), Message: e.Error()})
+		default:
+			fields = append(fields, FieldError{Field: field, Code: "invalid", Message: err.Error()})
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,110 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/noxecane/anansi/api"
+)
+
+var errMissing = errors.New("missing")
+
+func recovered(t *testing.T, rvr interface{}) (int, Details) {
+	t.Helper()
+
+	catalogue := Catalogue{errMissing: NotFound.Msg("This widget does not exist")}
+
+	h := middleware.RequestID(Recoverer("prod", catalogue)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(rvr)
+	})))
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if ct := res.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expected the response to be %s, got %s", ContentType, ct)
+	}
+
+	var body Details
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.RequestID == "" {
+		t.Error("Expected the request ID in the body")
+	}
+
+	if body.Status != res.Code {
+		t.Errorf("Expected the status %d in the body, got %d", res.Code, body.Status)
+	}
+
+	return res.Code, body
+}
+
+func TestRecoverer(t *testing.T) {
+	conflict := New(http.StatusConflict, "widget_taken", "There's already a widget with this name")
+
+	tests := []struct {
+		name    string
+		panic   interface{}
+		status  int
+		code    string
+		message string
+	}{
+		{"problem", conflict, http.StatusConflict, "widget_taken", "There's already a widget with this name"},
+		{"wrapped problem", fmt.Errorf("saving: %w", conflict.Msg("Try another name")), http.StatusConflict, "widget_taken", "Try another name"},
+		{"catalogue", fmt.Errorf("loading: %w", errMissing), http.StatusNotFound, "not_found", "This widget does not exist"},
+		{"api error", api.Err{Code: http.StatusForbidden, Message: "Go away"}, http.StatusForbidden, "forbidden", "Go away"},
+		{"unknown", errors.New("connection refused"), http.StatusInternalServerError, "internal_error", Internal.Message},
+		{"not an error", "oops", http.StatusInternalServerError, "internal_error", Internal.Message},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := recovered(t, tt.panic)
+
+			if status != tt.status || body.Code != tt.code || body.Detail != tt.message {
+				t.Errorf("Expected %d %s %q, got %d %s %q", tt.status, tt.code, tt.message, status, body.Code, body.Detail)
+			}
+		})
+	}
+}
+
+func TestRecovererValidation(t *testing.T) {
+	errs := ozzo.Errors{
+		"name": ozzo.ErrRequired,
+		"0":    ozzo.Errors{"email": errors.New("must be an email")},
+	}
+
+	status, body := recovered(t, api.Err{Code: http.StatusBadRequest, Message: "We could not validate your request.", Data: errs})
+	if status != http.StatusBadRequest || body.Code != ValidationFailed.Code {
+		t.Fatalf("Expected validation to fail, got %d %s", status, body.Code)
+	}
+
+	expected := []FieldError{
+		{Field: "0.email", Code: "invalid", Message: "must be an email"},
+		{Field: "name", Code: "required", Message: "cannot be blank"},
+	}
+	if !reflect.DeepEqual(body.Errors, expected) {
+		t.Errorf("Expected errors for every field, got %+v", body.Errors)
+	}
+}
+
+func TestErrorCopies(t *testing.T) {
+	p := NotFound.Msg("gone").Field("id", "invalid", "not an ID").Wrap(errMissing)
+
+	if NotFound.Message != "This does not exist" || len(NotFound.Fields) != 0 || NotFound.Err != nil {
+		t.Errorf("Expected the original problem to be left alone, got %+v", NotFound)
+	}
+
+	if !errors.Is(p, NotFound) || !errors.Is(p, errMissing) {
+		t.Errorf("Expected %v to still be the problem and its cause", p)
+	}
+}
@@ -0,0 +1,175 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+
+	"github.com/go-chi/chi/v5/middleware"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/noxecane/anansi/api"
+	"github.com/rs/zerolog"
+)
+
+// Details is the body of an error response, RFC 7807 problem details with the code,
+// the request ID and any field errors alongside.
+type Details struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// Catalogue maps domain errors to the problems they stand for, so handlers can
+// panic with them as they are.
+type Catalogue map[error]*Error
+
+// Lookup finds the problem for err or any error it wraps.
+func (c Catalogue) Lookup(err error) *Error {
+	for ; err != nil; err = errors.Unwrap(err) {
+		// some errors, like ozzo's, are maps and can't be keys
+		if !reflect.TypeOf(err).Comparable() {
+			continue
+		}
+
+		if p, ok := c[err]; ok {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// From works out the problem behind any error, falling back to Internal.
+func (c Catalogue) From(err error) *Error {
+	var p *Error
+	var apiErr api.Err
+	var errs ozzo.Errors
+
+	switch {
+	case errors.As(err, &p):
+		return p
+	case errors.As(err, &apiErr):
+		return fromAPI(apiErr)
+	case errors.As(err, &errs):
+		return Invalid(errs)
+	}
+
+	if p := c.Lookup(err); p != nil {
+		return p.Wrap(err)
+	}
+
+	return Internal.Wrap(err)
+}
+
+// fromAPI converts the errors anansi panics with while reading requests and sessions.
+func fromAPI(e api.Err) *Error {
+	if errs, ok := e.Data.(ozzo.Errors); ok {
+		return Invalid(errs)
+	}
+
+	p, ok := byStatus[e.Code]
+	if !ok {
+		p = New(e.Code, "error", http.StatusText(e.Code))
+	}
+
+	p = p.Wrap(e.Err)
+	if e.Message != "" {
+		p.Message = e.Message
+	}
+
+	return p
+}
+
+// Write sends p as problem details. It logs the response if a zerolog.Logger is
+// attached to the request.
+func Write(r *http.Request, w http.ResponseWriter, p *Error) {
+	body := Details{
+		Type:      "about:blank",
+		Title:     http.StatusText(p.Status),
+		Status:    p.Status,
+		Detail:    p.Message,
+		Code:      p.Code,
+		RequestID: middleware.GetReqID(r.Context()),
+		Errors:    p.Fields,
+	}
+
+	raw, _ := json.Marshal(body)
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(raw)))
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(raw)
+
+	log := zerolog.Ctx(r.Context())
+	event := log.Info()
+	if p.Status >= http.StatusInternalServerError {
+		event = log.Error()
+	}
+
+	event.Err(p).
+		Int("status", p.Status).
+		Str("code", p.Code).
+		Int("length", len(raw)).
+		Msg("")
+}
+
+// Recoverer responds to handlers panicking with problem details, whether they
+// panicked with a problem, an api.Err, validation errors or a domain error in the
+// catalogue. Anything else is a 500, or a 504 if the request timed out.
+func Recoverer(env string, catalogue Catalogue) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+
+				// the server knows to drop the connection
+				if rvr == http.ErrAbortHandler {
+					panic(rvr)
+				}
+
+				err, ok := rvr.(error)
+				if !ok {
+					err = fmt.Errorf("%v", rvr)
+				}
+
+				p := catalogue.From(err)
+				if p.Status >= http.StatusInternalServerError {
+					if r.Context().Err() == context.DeadlineExceeded {
+						p = Timeout.Wrap(err)
+					}
+
+					// give dev a chance to trace unknown errors
+					if env == "dev" || env == "test" {
+						stack := make([]byte, api.STACK_SIZE)
+						stack = stack[:runtime.Stack(stack, false)]
+						fmt.Fprintf(os.Stderr, "recovering from panic:\n%s", stack)
+					}
+				}
+
+				Write(r, w, p)
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Handler responds to every request with p, for routes that don't exist.
+func Handler(p *Error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Write(r, w, p)
+	}
+}
@@ -0,0 +1,121 @@
+package querylog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"github.com/uptrace/bun"
+)
+
+// maxQueryLength keeps huge inserts from flooding the logs.
+const maxQueryLength = 2000
+
+type budgetKey struct{}
+
+type budget struct {
+	limit   int64
+	strict  bool
+	queries int64
+}
+
+// BudgetError is what requests panic with when they run more queries than their
+// budget in strict mode.
+type BudgetError struct {
+	Route   string
+	Budget  int
+	Queries int
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s ran %d queries, over its budget of %d", e.Route, e.Queries, e.Budget)
+}
+
+// Hook logs queries that take longer than threshold, along with the route and request
+// that ran them, and counts queries against the request's budget. A threshold of 0
+// turns slow query logging off.
+type Hook struct {
+	log       zerolog.Logger
+	threshold time.Duration
+}
+
+func NewHook(log zerolog.Logger, threshold time.Duration) *Hook {
+	return &Hook{log, threshold}
+}
+
+func (h *Hook) BeforeQuery(ctx context.Context, _ *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *Hook) AfterQuery(ctx context.Context, e *bun.QueryEvent) {
+	if b, ok := ctx.Value(budgetKey{}).(*budget); ok {
+		n := atomic.AddInt64(&b.queries, 1)
+
+		// fail on the query that breaks the budget, so the stack shows where it's from
+		if b.strict && n == b.limit+1 {
+			panic(&BudgetError{Route: route(ctx), Budget: int(b.limit), Queries: int(n)})
+		}
+	}
+
+	elapsed := time.Since(e.StartTime)
+	if h.threshold == 0 || elapsed < h.threshold {
+		return
+	}
+
+	query := e.Query
+	if len(query) > maxQueryLength {
+		query = query[:maxQueryLength] + "..."
+	}
+
+	var table string
+	if e.IQuery != nil {
+		table = e.IQuery.GetTableName()
+	}
+
+	h.log.Warn().
+		Str("route", route(ctx)).
+		Str("request_id", middleware.GetReqID(ctx)).
+		Str("operation", strings.ToUpper(e.Operation())).
+		Str("table", table).
+		Dur("duration", elapsed).
+		Str("query", query).
+		Msg("slow query")
+}
+
+// Budget counts the queries each request runs, warning about requests that run more
+// than limit. In strict mode, meant for tests, the query over the limit panics with a
+// BudgetError instead. A limit of 0 turns the budget off.
+func Budget(limit int, strict bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b := &budget{limit: int64(limit), strict: strict}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), budgetKey{}, b)))
+
+			if n := atomic.LoadInt64(&b.queries); n > b.limit && !strict {
+				zerolog.Ctx(r.Context()).Warn().
+					Str("route", route(r.Context())).
+					Int64("queries", n).
+					Int("budget", limit).
+					Msg("request went over its query budget")
+			}
+		})
+	}
+}
+
+func route(ctx context.Context) string {
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		return rctx.RoutePattern()
+	}
+
+	return ""
+}
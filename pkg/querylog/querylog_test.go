@@ -0,0 +1,101 @@
+package querylog
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"github.com/uptrace/bun"
+)
+
+// runQueries stands in for a handler running n queries through bun.
+func runQueries(hook *Hook, n int, took time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < n; i++ {
+			hook.AfterQuery(r.Context(), &bun.QueryEvent{
+				Query:     "SELECT * FROM users WHERE id = 1",
+				StartTime: time.Now().Add(-took),
+			})
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newRouter(log *bytes.Buffer, hook *Hook, limit int, strict bool, queries int) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l := zerolog.New(log)
+			next.ServeHTTP(w, r.WithContext(l.WithContext(r.Context())))
+		})
+	})
+	router.Use(Budget(limit, strict))
+	router.Get("/users/{id}", runQueries(hook, queries, 0))
+
+	return router
+}
+
+func TestHookSlowQueries(t *testing.T) {
+	var log bytes.Buffer
+	hook := NewHook(zerolog.New(&log), 100*time.Millisecond)
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Get("/fast/{id}", runQueries(hook, 1, 0))
+	router.Get("/slow/{id}", runQueries(hook, 1, time.Second))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast/1", nil))
+	if log.Len() != 0 {
+		t.Fatalf("Expected fast queries to go unlogged, got %s", log.String())
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow/1", nil))
+	for _, field := range []string{`"message":"slow query"`, `"route":"/slow/{id}"`, `"request_id":"`, `"operation":"SELECT"`} {
+		if !strings.Contains(log.String(), field) {
+			t.Errorf("Expected slow query log to have %s, got %s", field, log.String())
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	var log bytes.Buffer
+	hook := NewHook(zerolog.Nop(), 0)
+
+	newRouter(&log, hook, 3, false, 3).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if log.Len() != 0 {
+		t.Fatalf("Expected requests within budget to go unlogged, got %s", log.String())
+	}
+
+	w := httptest.NewRecorder()
+	newRouter(&log, hook, 3, false, 4).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected requests over budget to still be served, got %d", w.Code)
+	}
+
+	if !strings.Contains(log.String(), `"queries":4`) || !strings.Contains(log.String(), `"route":"/users/{id}"`) {
+		t.Errorf("Expected a warning about the request over budget, got %s", log.String())
+	}
+}
+
+func TestBudgetStrict(t *testing.T) {
+	hook := NewHook(zerolog.Nop(), 0)
+
+	defer func() {
+		var budgetErr *BudgetError
+		err, _ := recover().(error)
+		if !errors.As(err, &budgetErr) || budgetErr.Queries != 3 || budgetErr.Route != "/users/{id}" {
+			t.Errorf("Expected the query over budget to panic, got %v", err)
+		}
+	}()
+
+	newRouter(&bytes.Buffer{}, hook, 2, true, 5).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+}
@@ -0,0 +1,260 @@
+// Package ratelimit keeps noisy workspaces, API keys and IPs from crowding everyone
+// else out, limiting their requests with GCRA buckets kept in redis.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcra takes a request from every bucket in KEYS, or from none of them when any is
+// empty, so requests turned away by one scope don't count against the others. ARGV
+// holds the microseconds each request takes to refill and how many the bucket holds,
+// in pairs for each key. A key holds the time its bucket is full again, the
+// theoretical arrival time of GCRA. It returns, for every bucket, whether it had room,
+// how many requests are left and the microseconds until the bucket is full and until
+// the next request is allowed.
+var gcra = redis.NewScript(`
+redis.replicate_commands()
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local results = {}
+local next_tats = {}
+local allowed = true
+
+for i, key in ipairs(KEYS) do
+  local interval = tonumber(ARGV[i * 2 - 1])
+  local tolerance = interval * tonumber(ARGV[i * 2])
+
+  local tat = tonumber(redis.call("GET", key)) or now
+  if tat < now then
+    tat = now
+  end
+
+  local next_tat = tat + interval
+  local allow_at = next_tat - tolerance
+  if allow_at > now then
+    allowed = false
+    results[i] = {0, 0, tat - now, allow_at - now}
+  else
+    next_tats[i] = next_tat
+    results[i] = {1, math.floor((now - allow_at) / interval), next_tat - now, 0}
+  end
+end
+
+if not allowed then
+  -- nothing was taken, so the buckets with room still have this request's
+  for i, res in ipairs(results) do
+    if res[1] == 1 then
+      results[i] = {1, res[2] + 1, res[3] - tonumber(ARGV[i * 2 - 1]), 0}
+    end
+  end
+
+  return results
+end
+
+for i, key in ipairs(KEYS) do
+  redis.call("SET", key, next_tats[i], "PX", math.ceil((next_tats[i] - now) / 1000))
+end
+
+return results
+`)
+
+// Limit lets through Rate requests every Period, all at once if they come together.
+type Limit struct {
+	Rate   int
+	Period time.Duration
+}
+
+// ParseLimit reads a limit like 300/1m. 0 turns the limit off.
+func ParseLimit(s string) (Limit, error) {
+	if s == "0" {
+		return Limit{}, nil
+	}
+
+	rate, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected something like 300/1m", s)
+	}
+
+	var l Limit
+	var err error
+	if l.Rate, err = strconv.Atoi(rate); err != nil || l.Rate < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected a number of requests", s)
+	}
+
+	if l.Period, err = time.ParseDuration(period); err != nil || l.Period <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected a period like 1m", s)
+	}
+
+	return l, nil
+}
+
+// Off is true of limits that let everything through.
+func (l Limit) Off() bool {
+	return l.Rate == 0
+}
+
+func (l Limit) String() string {
+	if l.Off() {
+		return "0"
+	}
+
+	return fmt.Sprintf("%d/%s", l.Rate, l.Period)
+}
+
+// Result is what's left of a bucket after taking a request from it.
+type Result struct {
+	Limit Limit
+	// Allowed is whether the bucket had room for the request. Nothing is taken from
+	// it unless every bucket the request counts against did
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until a request would be allowed, 0 if this one was
+	RetryAfter time.Duration
+}
+
+// Bucket is a limit kept at a key.
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// Limiter takes requests from buckets in redis.
+type Limiter struct {
+	client *redis.Client
+}
+
+func NewLimiter(client *redis.Client) *Limiter {
+	return &Limiter{client}
+}
+
+// Allow takes a request from every bucket if they all have room for it, returning
+// their results in the same order.
+func (l *Limiter) Allow(ctx context.Context, buckets []Bucket) ([]Result, error) {
+	keys := make([]string, len(buckets))
+	args := make([]interface{}, 0, len(buckets)*2)
+	for i, b := range buckets {
+		keys[i] = "ratelimit:" + b.Key
+		args = append(args, b.Limit.Period.Microseconds()/int64(b.Limit.Rate), b.Limit.Rate)
+	}
+
+	raw, err := gcra.Run(ctx, l.client, keys, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(buckets))
+	for i, b := range buckets {
+		res, ok := raw[i].([]interface{})
+		if !ok || len(res) != 4 {
+			return nil, fmt.Errorf("unexpected rate limit result %v", raw[i])
+		}
+
+		n := make([]int64, len(res))
+		for j := range res {
+			if n[j], ok = res[j].(int64); !ok {
+				return nil, fmt.Errorf("unexpected rate limit result %v", raw[i])
+			}
+		}
+
+		results[i] = Result{
+			Limit:      b.Limit,
+			Allowed:    n[0] == 1,
+			Remaining:  int(n[1]),
+			Reset:      time.Duration(n[2]) * time.Microsecond,
+			RetryAfter: time.Duration(n[3]) * time.Microsecond,
+		}
+	}
+
+	return results, nil
+}
+
+// Rules are the limits of every scope, by route group. The "" group holds the
+// defaults for groups that don't set their own.
+type Rules map[string]map[string]Limit
+
+// ParseRules reads rules like `ip=300/1m,workspace=1200/1m,invitations.ip=30/1m`,
+// a scope on its own setting the default and one prefixed by a group overriding it
+// for that group.
+func ParseRules(s string) (Rules, error) {
+	rules := Rules{"": {}}
+
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		name, raw, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit rule %q, expected scope=limit", rule)
+		}
+
+		group, scope := "", name
+		if i := strings.LastIndex(name, "."); i != -1 {
+			group, scope = name[:i], name[i+1:]
+		}
+
+		if !validScope(scope) {
+			return nil, fmt.Errorf("invalid rate limit rule %q, the scope is one of %s", rule, strings.Join(scopes, ", "))
+		}
+
+		limit, err := ParseLimit(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if rules[group] == nil {
+			rules[group] = make(map[string]Limit)
+		}
+		rules[group][scope] = limit
+	}
+
+	return rules, nil
+}
+
+// Decode lets the rules be read straight from the environment.
+func (r *Rules) Decode(value string) error {
+	rules, err := ParseRules(value)
+	if err != nil {
+		return err
+	}
+
+	*r = rules
+	return nil
+}
+
+func (r Rules) String() string {
+	var parts []string
+	for group, limits := range r {
+		for scope, limit := range limits {
+			if group != "" {
+				scope = group + "." + scope
+			}
+			parts = append(parts, scope+"="+limit.String())
+		}
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, ",")
+}
+
+// For returns the limit of the scope for the group.
+func (r Rules) For(group, scope string) Limit {
+	if l, ok := r[group][scope]; ok {
+		return l
+	}
+
+	return r[""][scope]
+}
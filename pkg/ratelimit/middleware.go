@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"noxecane/go-starter/pkg/metrics"
+	"noxecane/go-starter/pkg/problem"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// Scopes requests are limited by. Every request counts against its IP, and against
+// its API key and workspace when it has them.
+const (
+	ScopeKey       = "key"
+	ScopeWorkspace = "workspace"
+	ScopeIP        = "ip"
+)
+
+var scopes = []string{ScopeKey, ScopeWorkspace, ScopeIP}
+
+// unmatched is the group of requests for routes that don't exist.
+const unmatched = "unmatched"
+
+var (
+	ErrLimited     = problem.New(http.StatusTooManyRequests, "rate_limited", "Too many requests, slow down and try again later")
+	ErrUnavailable = problem.New(http.StatusServiceUnavailable, "rate_limit_unavailable", "Requests can't be counted right now, try again later")
+)
+
+func validScope(scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Opts configures the middleware.
+type Opts struct {
+	Rules Rules
+	// Workspace returns the ID of the workspace the request is acting on, if any
+	Workspace func(*http.Request) string
+	// FailOpen lets requests through when redis can't be reached, rather than
+	// rejecting them with a 503
+	FailOpen bool
+}
+
+// Middleware limits requests by the rules for their route group, the first segment
+// of the route they match, like `invitations` for `/invitations/{token}/accept`. Responses carry
+// the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy
+// headers of the scope closest to its limit. Requests over any of their limits are
+// rejected with a 429 and a Retry-After header.
+func Middleware(limiter *Limiter, opts Opts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			group := routeGroup(r)
+
+			ids := map[string]string{
+				ScopeKey: apiKey(r),
+				ScopeIP:  clientIP(r),
+			}
+			if opts.Workspace != nil {
+				ids[ScopeWorkspace] = opts.Workspace(r)
+			}
+
+			var buckets []Bucket
+			var bucketScopes []string
+			for _, scope := range scopes {
+				limit := opts.Rules.For(group, scope)
+				if ids[scope] == "" || limit.Off() {
+					continue
+				}
+
+				buckets = append(buckets, Bucket{Key: group + ":" + scope + ":" + ids[scope], Limit: limit})
+				bucketScopes = append(bucketScopes, scope)
+			}
+
+			if len(buckets) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			results, err := limiter.Allow(r.Context(), buckets)
+			if err != nil {
+				zerolog.Ctx(r.Context()).Err(err).Strs("scopes", bucketScopes).Msg("failed to check the rate limit")
+				if opts.FailOpen {
+					next.ServeHTTP(w, r)
+				} else {
+					problem.Write(r, w, ErrUnavailable.Wrap(err))
+				}
+				return
+			}
+
+			var closest *Result
+			var closestScope string
+			for i := range results {
+				if closest == nil || results[i].closerThan(*closest) {
+					closest, closestScope = &results[i], bucketScopes[i]
+				}
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(closest.Limit.Rate))
+			h.Set("RateLimit-Remaining", strconv.Itoa(closest.Remaining))
+			h.Set("RateLimit-Reset", seconds(closest.Reset))
+			h.Set("RateLimit-Policy", closest.Limit.Policy())
+
+			if !closest.Allowed {
+				metrics.RateLimited.WithLabelValues(group, closestScope).Inc()
+				h.Set("Retry-After", seconds(closest.RetryAfter))
+				problem.Write(r, w, ErrLimited)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// closerThan is true of results closer to their limit than other, the ones that
+// turned the request away first and then the one it has to wait the longest for.
+func (res Result) closerThan(other Result) bool {
+	if res.Allowed != other.Allowed {
+		return !res.Allowed
+	}
+
+	if !res.Allowed {
+		return res.RetryAfter > other.RetryAfter
+	}
+
+	return res.Remaining < other.Remaining
+}
+
+// Policy describes the limit for the RateLimit-Policy header, like `300;w=60`.
+func (l Limit) Policy() string {
+	return strconv.Itoa(l.Rate) + ";w=" + seconds(l.Period)
+}
+
+// seconds rounds d up, so clients waiting that long aren't turned away again.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// routeGroup is the first segment of the route the request matches below the router
+// the middleware is on, so clients can't make up groups with paths of their own.
+// Requests matching no route share the unmatched group.
+func routeGroup(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return unmatched
+	}
+
+	// routing hasn't got past this router yet, so match the whole path from the top
+	match := chi.NewRouteContext()
+	if !rctx.Routes.Match(match, r.Method, r.URL.Path) || len(match.RoutePatterns) <= len(rctx.RoutePatterns) {
+		return unmatched
+	}
+
+	pattern := match.RoutePatterns[len(rctx.RoutePatterns)]
+	group, _, _ := strings.Cut(strings.TrimPrefix(pattern, "/"), "/")
+	return group
+}
+
+// apiKey identifies the credentials in the Authorization header without keeping
+// them around in redis.
+func apiKey(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(auth))
+	return hex.EncodeToString(sum[:])
+}
+
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
@@ -0,0 +1,275 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/noxecane/anansi"
+	"github.com/redis/go-redis/v9"
+)
+
+var testRedis *redis.Client
+
+type testEnv struct {
+	Name          string `default:"go-starter"`
+	RedisHost     string `required:"true" split_words:"true"`
+	RedisPort     int    `required:"true" split_words:"true"`
+	RedisPassword string `default:"" split_words:"true"`
+}
+
+func afterEach(t *testing.T) {
+	ctx := context.TODO()
+
+	keys, err := testRedis.Keys(ctx, "ratelimit:*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) > 0 {
+		if err := testRedis.Del(ctx, keys...).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	var e testEnv
+	if err := anansi.LoadEnv(&e); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(e.Name)
+
+	testRedis = redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", e.RedisHost, e.RedisPort),
+		Password: e.RedisPassword,
+	})
+	if err := testRedis.Ping(context.TODO()).Err(); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to redis")
+
+	code := m.Run()
+
+	if err := testRedis.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from redis cleanly")
+	}
+
+	os.Exit(code)
+}
+
+func newRouter(limiter *Limiter, opts Opts) *chi.Mux {
+	api := chi.NewRouter()
+	api.Use(Middleware(limiter, opts))
+	api.Get("/teams/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	api.Get("/users/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	api.Route("/workspaces", func(r chi.Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	})
+
+	router := chi.NewRouter()
+	router.Mount("/api/v1", api)
+
+	return router
+}
+
+func get(h http.Handler, path, ip, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = ip + ":1234"
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	return res
+}
+
+func mustRules(t *testing.T, s string) Rules {
+	t.Helper()
+
+	rules, err := ParseRules(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rules
+}
+
+func TestParseRules(t *testing.T) {
+	rules := mustRules(t, "ip=10/1m, key=5/1s,teams.ip=2/1h,teams.key=0")
+
+	if l := rules.For("users", ScopeIP); l != (Limit{10, time.Minute}) {
+		t.Errorf("Expected groups without their own limits to use the defaults, got %v", l)
+	}
+
+	if l := rules.For("teams", ScopeIP); l != (Limit{2, time.Hour}) {
+		t.Errorf("Expected the group's own limit, got %v", l)
+	}
+
+	if !rules.For("teams", ScopeKey).Off() || !rules.For("users", ScopeWorkspace).Off() {
+		t.Error("Expected limits set to 0 or left out to be off")
+	}
+
+	for _, s := range []string{"ip", "ip=10", "ip=ten/1m", "ip=10/soon", "user=10/1m"} {
+		if _, err := ParseRules(s); err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	defer afterEach(t)
+
+	router := newRouter(NewLimiter(testRedis), Opts{
+		Rules: mustRules(t, "ip=3/1m,key=2/1m,users.ip=1/1m"),
+	})
+
+	for i := 2; i >= 0; i-- {
+		res := get(router, "/api/v1/teams/", "10.0.0.1", "")
+		if res.Code != http.StatusNoContent {
+			t.Fatalf("Expected request to be allowed, got %d", res.Code)
+		}
+
+		if res.Header().Get("RateLimit-Remaining") != fmt.Sprint(i) || res.Header().Get("RateLimit-Policy") != "3;w=60" {
+			t.Errorf("Expected %d requests remaining of 3 a minute, got %v", i, res.Header())
+		}
+	}
+
+	res := get(router, "/api/v1/teams/", "10.0.0.1", "")
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") != "20" {
+		t.Fatalf("Expected to be told to come back in 20s, got %d %v", res.Code, res.Header())
+	}
+
+	// other IPs and groups have buckets of their own
+	if res := get(router, "/api/v1/teams/", "10.0.0.2", ""); res.Code != http.StatusNoContent {
+		t.Errorf("Expected another IP to be allowed, got %d", res.Code)
+	}
+
+	if res := get(router, "/api/v1/users/", "10.0.0.1", ""); res.Code != http.StatusNoContent || res.Header().Get("RateLimit-Limit") != "1" {
+		t.Errorf("Expected the group's own limit, got %d %v", res.Code, res.Header())
+	}
+
+	// the key runs out first, so it's the one reported
+	res = get(router, "/api/v1/teams/", "10.0.0.3", "Bearer alice")
+	if res.Header().Get("RateLimit-Limit") != "2" || res.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("Expected the key's limit, got %v", res.Header())
+	}
+
+	get(router, "/api/v1/teams/", "10.0.0.4", "Bearer alice")
+	if res := get(router, "/api/v1/teams/", "10.0.0.5", "Bearer alice"); res.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the key to be limited across IPs, got %d", res.Code)
+	}
+}
+
+func TestMiddlewareGroups(t *testing.T) {
+	defer afterEach(t)
+
+	router := newRouter(NewLimiter(testRedis), Opts{
+		Rules: mustRules(t, "ip=10/1m,workspaces.ip=2/1m"),
+	})
+
+	// every ID is the same route
+	for _, path := range []string{"/api/v1/workspaces/1", "/api/v1/workspaces/2"} {
+		if res := get(router, path, "10.0.0.1", ""); res.Code != http.StatusNoContent || res.Header().Get("RateLimit-Limit") != "2" {
+			t.Errorf("Expected the workspaces limit for %s, got %d %v", path, res.Code, res.Header())
+		}
+	}
+
+	if res := get(router, "/api/v1/workspaces/3", "10.0.0.1", ""); res.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the IDs to share a bucket, got %d", res.Code)
+	}
+
+	// made up paths share one group, rather than a bucket each
+	for _, path := range []string{"/api/v1/a", "/api/v1/b/c", "/api/v1/workspaces/1/d"} {
+		get(router, path, "10.0.0.1", "")
+	}
+
+	keys, err := testRedis.Keys(context.TODO(), "ratelimit:*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 {
+		t.Errorf("Expected a bucket for the workspaces and unmatched groups, got %v", keys)
+	}
+}
+
+func TestMiddlewareLimitedByOneScope(t *testing.T) {
+	defer afterEach(t)
+
+	router := newRouter(NewLimiter(testRedis), Opts{
+		Rules: mustRules(t, "ip=3/1m,key=1/1m"),
+	})
+
+	get(router, "/api/v1/teams/", "10.0.0.1", "Bearer alice")
+	if res := get(router, "/api/v1/teams/", "10.0.0.1", "Bearer alice"); res.Code != http.StatusTooManyRequests || res.Header().Get("RateLimit-Limit") != "1" {
+		t.Fatalf("Expected the key to be limited, got %d %v", res.Code, res.Header())
+	}
+
+	// the request the key turned away didn't count against the IP
+	res := get(router, "/api/v1/teams/", "10.0.0.1", "")
+	if res.Code != http.StatusNoContent || res.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("Expected 1 request left for the IP, got %d %v", res.Code, res.Header())
+	}
+}
+
+func TestMiddlewareWorkspace(t *testing.T) {
+	defer afterEach(t)
+
+	router := newRouter(NewLimiter(testRedis), Opts{
+		Rules: mustRules(t, "workspace=1/1m"),
+		Workspace: func(r *http.Request) string {
+			return r.Header.Get("Authorization")
+		},
+	})
+
+	if res := get(router, "/api/v1/teams/", "10.0.0.1", "1"); res.Code != http.StatusNoContent {
+		t.Fatalf("Expected request to be allowed, got %d", res.Code)
+	}
+
+	if res := get(router, "/api/v1/teams/", "10.0.0.2", "1"); res.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the workspace to be limited, got %d", res.Code)
+	}
+
+	if res := get(router, "/api/v1/teams/", "10.0.0.2", "2"); res.Code != http.StatusNoContent {
+		t.Errorf("Expected another workspace to be allowed, got %d", res.Code)
+	}
+
+	// no workspace, no limit
+	for i := 0; i < 3; i++ {
+		if res := get(router, "/api/v1/teams/", "10.0.0.1", ""); res.Code != http.StatusNoContent || res.Header().Get("RateLimit-Limit") != "" {
+			t.Errorf("Expected requests without a workspace to go through, got %d %v", res.Code, res.Header())
+		}
+	}
+}
+
+func TestMiddlewareRedisDown(t *testing.T) {
+	down := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer down.Close()
+
+	rules := mustRules(t, "ip=1/1m")
+
+	open := newRouter(NewLimiter(down), Opts{Rules: rules, FailOpen: true})
+	if res := get(open, "/api/v1/teams/", "10.0.0.1", ""); res.Code != http.StatusNoContent {
+		t.Errorf("Expected to let requests through, got %d", res.Code)
+	}
+
+	closed := newRouter(NewLimiter(down), Opts{Rules: rules})
+	if res := get(closed, "/api/v1/teams/", "10.0.0.1", ""); res.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected to turn requests away, got %d", res.Code)
+	}
+}
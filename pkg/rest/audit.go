@@ -0,0 +1,186 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"noxecane/go-starter/pkg/audit"
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/users"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/noxecane/anansi"
+	"github.com/noxecane/anansi/api"
+	"github.com/uptrace/bun"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+	maxAuditExport    = 10000
+)
+
+func AuditEvents(r *chi.Mux, app *config.App) {
+
+	r.Route("/audit-events", func(r chi.Router) {
+		r.Use(ActiveWorkspace(app.Auth, app.DB))
+
+		r.Get("/", listAuditEvents(app.Auth, app.DB))
+		r.Get("/export", exportAuditEvents(app.Auth, app.DB))
+	})
+}
+
+// newEvent starts an audit event for a change the session's user is making in this request.
+func newEvent(r *http.Request, s session, action, targetType string, targetID uint) *audit.Event {
+	actor := s.User
+
+	return &audit.Event{
+		Workspace:  s.Workspace,
+		Actor:      &actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatUint(uint64(targetID), 10),
+		IPAddress:  clientIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+}
+
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+func auditFilter(r *http.Request, limit int) audit.Filter {
+	q := r.URL.Query()
+	f := audit.Filter{
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+		Limit:      limit,
+	}
+
+	var err error
+	if v := q.Get("actor"); v != "" {
+		var actor uint64
+		if actor, err = strconv.ParseUint(v, 10, 32); err != nil {
+			panic(invalidParam("actor", "actor must be an integer ID"))
+		}
+		f.Actor = uint(actor)
+	}
+
+	if v := q.Get("before"); v != "" {
+		if f.Before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			panic(invalidParam("before", "before must be an integer ID"))
+		}
+	}
+
+	f.Since = timeQuery(r, "since")
+	f.Until = timeQuery(r, "until")
+
+	return f
+}
+
+func timeQuery(r *http.Request, name string) time.Time {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}
+	}
+
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t
+	}
+
+	t, err := anansi.ParseISO(v)
+	if err != nil {
+		panic(invalidParam(name, fmt.Sprintf("%s must be an ISO 8601 date", name)))
+	}
+
+	return t
+}
+
+func loadOwner(auth *keyring.SessionStore, r *http.Request) session {
+	var session session
+	loadSession(auth, r, &session)
+
+	if session.Role != users.RoleOwner {
+		panic(errOwnerOnly.Msg("Only the owner can view the audit log"))
+	}
+
+	return session
+}
+
+func listAuditEvents(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := loadOwner(auth, r)
+
+		limit := defaultAuditLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxAuditLimit {
+				panic(invalidParam("limit", fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit)))
+			}
+		}
+
+		var events []audit.Event
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			events, err = audit.NewRepo(tx).List(ctx, session.Workspace, auditFilter(r, limit))
+			return err
+		})
+
+		api.Success(r, w, events)
+	}
+}
+
+func exportAuditEvents(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := loadOwner(auth, r)
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "csv"
+		}
+
+		if format != "csv" && format != "json" {
+			panic(invalidParam("format", "format must be either csv or json"))
+		}
+
+		// one more than the export holds, to tell if there's more to come
+		var events []audit.Event
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			events, err = audit.NewRepo(tx).List(ctx, session.Workspace, auditFilter(r, maxAuditExport+1))
+			return err
+		})
+
+		// the rest can be exported by passing this as before
+		if len(events) > maxAuditExport {
+			events = events[:maxAuditExport]
+			w.Header().Set("X-Next-Before", strconv.FormatUint(events[maxAuditExport-1].ID, 10))
+		}
+
+		filename := fmt.Sprintf("audit-events-%s.%s", time.Now().Format("20060102"), format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		var err error
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			err = audit.WriteCSV(w, events)
+		} else {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			err = audit.WriteJSON(w, events)
+		}
+
+		if err != nil {
+			panic(err)
+		}
+	}
+}
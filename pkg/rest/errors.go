@@ -0,0 +1,81 @@
+package rest
+
+import (
+	"net/http"
+
+	"noxecane/go-starter/pkg/problem"
+	"noxecane/go-starter/pkg/teams"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/workspaces"
+)
+
+// The problems the API responds with, on top of problem's generic ones. Clients
+// rely on the codes, so they never change once released.
+var (
+	errInvalidParam      = problem.New(http.StatusBadRequest, "invalid_parameter", "Some of the parameters of your request are invalid")
+	errRoleNotAllowed    = problem.New(http.StatusForbidden, "role_not_allowed", "Your role does not allow this")
+	errOwnerOnly         = problem.New(http.StatusForbidden, "owner_only", "Only the owner can do this")
+	errNotMember         = problem.New(http.StatusForbidden, "not_a_member", "You are not a member of this workspace")
+	errWrongWorkspace    = problem.New(http.StatusForbidden, "wrong_workspace", "Your session belongs to a different workspace")
+	errWorkspaceDeleted  = problem.New(http.StatusForbidden, "workspace_deleted", "This workspace has been deleted")
+	errWorkspaceNotFound = problem.New(http.StatusNotFound, "workspace_not_found", "This workspace does not exist")
+	errNoWorkspace       = problem.New(http.StatusNotFound, "workspace_not_named", "No workspace was named in this request")
+	errNotRestorable     = problem.New(http.StatusConflict, "workspace_not_restorable", "This workspace cannot be restored")
+	errSlugTaken         = problem.New(http.StatusConflict, "slug_taken", "This URL is already taken by another workspace")
+	errInvalidSlug       = problem.ValidationFailed.Field("slug", "invalid", "can only contain lowercase letters, numbers and hyphens")
+	errReservedSlug      = problem.ValidationFailed.Field("slug", "reserved", "is reserved and can't be used by a workspace")
+
+	errInvitationExpired = problem.New(http.StatusUnauthorized, "invitation_expired", "Your invitation token has expired")
+	errAccountExists     = problem.New(http.StatusConflict, "account_exists", "You already have an account, log in to access this workspace")
+	errMembershipRevoked = problem.New(http.StatusForbidden, "membership_revoked", "You are no longer a member of this workspace")
+	errEmailTaken        = problem.New(http.StatusConflict, "email_taken", "This email address already belongs to a member of your workspace")
+	errPhoneNumberTaken  = problem.New(http.StatusConflict, "phone_number_taken", "This phone number is already in use")
+
+	errAccountNotFound    = problem.New(http.StatusNotFound, "account_not_found", "Your account no longer exists")
+	errIncompleteProfile  = problem.New(http.StatusConflict, "incomplete_profile", "Your account has not been set up, accept your invitation first")
+	errIncorrectPassword  = problem.New(http.StatusUnauthorized, "incorrect_password", "Your current password is incorrect")
+	errUserNotFound       = problem.New(http.StatusNotFound, "user_not_found", "This user is not a member of your workspace")
+	errOwnerRole          = problem.New(http.StatusConflict, "owner_role", "The owner's role can only change by transferring ownership")
+	errOwnerLeaving       = problem.New(http.StatusConflict, "owner_leaving", "The owner has to transfer ownership before leaving")
+	errNotWorkspaceMember = problem.ValidationFailed.Field("user", "not_a_member", "This user is not a member of your workspace")
+
+	errTransferTarget   = problem.ValidationFailed.Field("user", "not_an_admin", "You can only transfer this workspace to one of its admins")
+	errTransferExpired  = problem.New(http.StatusUnauthorized, "transfer_expired", "Your transfer token has expired")
+	errTransferNotYours = problem.New(http.StatusForbidden, "transfer_not_yours", "This transfer was not meant for you")
+	errRolesChanged     = problem.New(http.StatusConflict, "roles_changed", "The roles in this workspace have changed since the transfer was requested")
+
+	errTeamNotFound  = problem.New(http.StatusNotFound, "team_not_found", "This team does not exist")
+	errTeamNameTaken = problem.New(http.StatusConflict, "team_name_taken", "There's already a team with this name")
+	errAlreadyInTeam = problem.New(http.StatusConflict, "already_in_team", "This user is already in the team")
+	errNotInTeam     = problem.New(http.StatusNotFound, "not_in_team", "This user is not in the team")
+
+	errWebhookNotFound = problem.New(http.StatusNotFound, "webhook_not_found", "This webhook does not exist")
+)
+
+// Problems maps the errors of the domain packages to the problems they stand for,
+// so handlers can panic with them as they are. Expired invitations and transfers
+// share the token store's error, so handlers tell those apart themselves.
+var Problems = problem.Catalogue{
+	users.ErrExistingEmail:       errEmailTaken,
+	users.ErrExistingPhoneNumber: errPhoneNumberTaken,
+	users.ErrOwnerRole:           errOwnerRole,
+	users.ErrInvalidPassword:     errIncorrectPassword,
+	users.ErrIncompleteProfile:   errIncompleteProfile,
+	users.ErrNotOwner:            errRolesChanged,
+	users.ErrNotAdmin:            errRolesChanged,
+
+	workspaces.ErrExistingSlug: errSlugTaken,
+	workspaces.ErrInvalidSlug:  errInvalidSlug,
+	workspaces.ErrReservedSlug: errReservedSlug,
+	workspaces.ErrDeleted:      errWorkspaceDeleted,
+	workspaces.ErrNotDeleted:   errNotRestorable,
+
+	teams.ErrExistingTeam:       errTeamNameTaken,
+	teams.ErrExistingMember:     errAlreadyInTeam,
+	teams.ErrNotWorkspaceMember: errNotWorkspaceMember,
+}
+
+// invalidParam blames a path or query parameter.
+func invalidParam(name, message string) *problem.Error {
+	return errInvalidParam.Msg(message).Field(name, "invalid", message)
+}
@@ -1,23 +1,31 @@
 package rest
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strings"
 
+	"noxecane/go-starter/pkg/audit"
 	"noxecane/go-starter/pkg/config"
 	"noxecane/go-starter/pkg/invitations"
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/metrics"
 	"noxecane/go-starter/pkg/notification"
+	"noxecane/go-starter/pkg/problem"
+	"noxecane/go-starter/pkg/teams"
+	"noxecane/go-starter/pkg/tenant"
 	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/webhooks"
 	"noxecane/go-starter/pkg/workspaces"
 
 	"github.com/go-chi/chi/v5"
 	ozzo "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 	"github.com/noxecane/anansi/api"
-	sessions "github.com/noxecane/anansi/sessions"
+	"github.com/uptrace/bun"
 )
 
 var (
@@ -34,13 +42,18 @@ var (
 type InvitationDTO struct {
 	EmailAddress string `json:"email_address" mod:"smalltext"`
 	Role         string `json:"role" mod:"smalltext"`
+	Team         uint   `json:"team"`
 }
 
-func (t *InvitationDTO) Validate() error {
-	return ozzo.ValidateStruct(t,
+func (t *InvitationDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
 		ozzo.Field(&t.EmailAddress, ozzo.Required, is.Email),
 		ozzo.Field(&t.Role, ozzo.Required, ozzo.In("member", "admin")),
-	)
+	}
+}
+
+func (t *InvitationDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
 }
 
 type RegistrationDTO struct {
@@ -51,25 +64,29 @@ type RegistrationDTO struct {
 	PhoneNumber string `json:"phone_number" mod:"trim"`
 }
 
-func (t *RegistrationDTO) Validate() error {
-	return ozzo.ValidateStruct(t,
+func (t *RegistrationDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
 		ozzo.Field(&t.CompanyName),
 		ozzo.Field(&t.FirstName, ozzo.Required),
 		ozzo.Field(&t.LastName, ozzo.Required),
 		ozzo.Field(&t.Password, ozzo.Required, ozzo.Length(8, 64)),
 		ozzo.Field(&t.PhoneNumber, ozzo.Required, phoneValidator),
-	)
+	}
+}
+
+func (t *RegistrationDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
 }
 
 func Invitations(r *chi.Mux, app *config.App, mailer notification.Mailer) {
 	ivStore := invitations.NewStore(app.Tokens)
-	uRepo := users.NewRepo(app.DB)
-	wRepo := workspaces.NewRepo(app.DB)
 
 	r.Route("/invitations", func(r chi.Router) {
-		r.Post("/", inviteUsers(app.Auth, uRepo, ivStore, app.Env, mailer))
+		r.Use(ActiveWorkspace(app.Auth, app.DB))
+
+		r.Post("/", inviteUsers(app.Auth, app.DB, ivStore, app.Env, mailer))
 		r.Patch("/{token}/extend", extendInvitation(ivStore))
-		r.Patch("/{token}/accept", acceptInvitation(ivStore, uRepo, wRepo, app.Auth))
+		r.Patch("/{token}/accept", acceptInvitation(ivStore, app.DB))
 	})
 }
 
@@ -80,10 +97,7 @@ func extendInvitation(ivStore *invitations.Store) http.HandlerFunc {
 		iv, err := ivStore.Extend(r.Context(), token)
 		if err != nil {
 			if errors.Is(err, invitations.ErrExpired) {
-				panic(api.Err{
-					Code:    http.StatusUnauthorized,
-					Message: "Your invitation token has expired",
-				})
+				panic(errInvitationExpired)
 			}
 			panic(err)
 		}
@@ -92,7 +106,7 @@ func extendInvitation(ivStore *invitations.Store) http.HandlerFunc {
 	}
 }
 
-func acceptInvitation(ivStore *invitations.Store, uRepo *users.Repo, wRepo *workspaces.Repo, sStore *sessions.Store) http.HandlerFunc {
+func acceptInvitation(ivStore *invitations.Store, db bun.IDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var dto RegistrationDTO
 		api.ReadJSON(r, &dto)
@@ -102,41 +116,71 @@ func acceptInvitation(ivStore *invitations.Store, uRepo *users.Repo, wRepo *work
 		iv, err := ivStore.View(r.Context(), token)
 		if err != nil {
 			if errors.Is(err, invitations.ErrExpired) {
-				panic(api.Err{
-					Code:    http.StatusUnauthorized,
-					Message: "Your invitation token has expired",
-				})
+				panic(errInvitationExpired)
 			}
 			panic(err)
 		}
 
-		user, err := uRepo.Register(r.Context(), iv.EmailAddress, users.Registration{
-			FirstName:   dto.FirstName,
-			LastName:    dto.LastName,
-			PhoneNumber: dto.PhoneNumber,
-			Password:    dto.Password,
+		var workspace *workspaces.Workspace
+		scoped(r, db, iv.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			workspace, err = workspaces.NewRepo(tx).Get(ctx, iv.Workspace)
+			return err
 		})
-		if err != nil {
-			if errors.Is(err, users.ErrExistingPhoneNumber) {
-				panic(api.Err{
-					Code:    http.StatusConflict,
-					Message: err.Error(),
-				})
-			} else {
-				panic(err)
-			}
+
+		if workspace == nil || workspace.IsDeleted() {
+			panic(errWorkspaceDeleted.Msg("This workspace does not exist"))
 		}
 
-		if err := ivStore.Revoke(r.Context(), user.EmailAddress); err != nil {
+		var user *users.User
+		err = db.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+			uRepo := users.NewRepo(tx)
+
+			// only placeholders invited to this workspace can be registered, but their
+			// invitations from every workspace stop being pending, so only scope after
+			registered, err := uRepo.Register(ctx, iv.Workspace, iv.EmailAddress, users.Registration{
+				FirstName:   dto.FirstName,
+				LastName:    dto.LastName,
+				PhoneNumber: dto.PhoneNumber,
+				Password:    dto.Password,
+			})
+			if err != nil {
+				return err
+			}
+
+			// the profile is only set once, on the first invitation accepted
+			if registered.ID == 0 {
+				panic(errAccountExists)
+			}
+
+			if err := tenant.Scope(ctx, tx, iv.Workspace); err != nil {
+				return err
+			}
+
+			if user, err = uRepo.Get(ctx, iv.Workspace, registered.ID); err != nil {
+				return err
+			}
+
+			if user == nil {
+				panic(errMembershipRevoked)
+			}
+
+			// the new user is the one acting here
+			e := newEvent(r, session{Workspace: iv.Workspace, User: user.ID}, audit.ActionInvitationAccepted, audit.TargetUser, user.ID)
+			if err := audit.NewRepo(tx).Record(ctx, e); err != nil {
+				return err
+			}
+
+			return webhooks.NewRepo(tx).Enqueue(ctx, iv.Workspace, webhooks.EventUserJoined, user.Ref())
+		})
+		if err != nil {
 			panic(err)
 		}
 
-		workspace, err := wRepo.Get(r.Context(), user.Workspace)
-		if err != nil {
-			panic(api.Err{
-				Code:    http.StatusForbidden,
-				Message: "This workspace does not exist",
-			})
+		metrics.InvitationsAccepted.Inc()
+
+		if err := ivStore.Revoke(r.Context(), iv.Workspace, iv.EmailAddress); err != nil {
+			panic(err)
 		}
 
 		session := session{
@@ -147,24 +191,17 @@ func acceptInvitation(ivStore *invitations.Store, uRepo *users.Repo, wRepo *work
 			FullName:    fmt.Sprintf("%s %s", user.FirstName, user.LastName),
 		}
 
-		if err != nil {
-			panic(err)
-		}
-
 		api.Success(r, w, session)
 	}
 }
 
-func inviteUsers(auth *sessions.Store, uRepo *users.Repo, ivStore *invitations.Store, env *config.Env, mailer notification.Mailer) http.HandlerFunc {
+func inviteUsers(auth *keyring.SessionStore, db bun.IDB, ivStore *invitations.Store, env *config.Env, mailer notification.Mailer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var session session
-		api.Load(auth, r, &session)
+		loadSession(auth, r, &session)
 
 		if session.Role == "member" {
-			panic(api.Err{
-				Code:    http.StatusForbidden,
-				Message: "You are not allowed to invite other users",
-			})
+			panic(errRoleNotAllowed.Msg("You are not allowed to invite other users"))
 		}
 
 		var dtos []InvitationDTO
@@ -178,20 +215,105 @@ func inviteUsers(auth *sessions.Store, uRepo *users.Repo, ivStore *invitations.S
 				Role:         dto.Role,
 			})
 		}
-		ux, err := uRepo.CreateMany(r.Context(), session.Workspace, reqs)
+		var ux []users.User
+		err := db.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			if ux, err = users.NewRepo(tx).CreateMany(ctx, session.Workspace, reqs); err != nil {
+				return err
+			}
+
+			// invitees are matched to existing accounts by email, so only scope once that's done
+			if err := tenant.Scope(ctx, tx, session.Workspace); err != nil {
+				return err
+			}
+
+			// put the invited users straight into their teams
+			tRepo := teams.NewRepo(tx)
+			for i, dto := range dtos {
+				if dto.Team == 0 {
+					continue
+				}
+
+				team, err := tRepo.Get(ctx, session.Workspace, dto.Team)
+				if err != nil {
+					return err
+				}
+
+				if team == nil {
+					panic(problem.ValidationFailed.Field(fmt.Sprintf("%d.team", i), "not_found", fmt.Sprintf("Team %d does not exist", dto.Team)))
+				}
+
+				// invited again, and already put in the team the first time
+				if m, err := tRepo.Member(ctx, session.Workspace, team.ID, ux[i].ID); err != nil {
+					return err
+				} else if m != nil {
+					continue
+				}
+
+				if err := tRepo.AddMembers(ctx, session.Workspace, team.ID, []uint{ux[i].ID}, teams.RoleMember); err != nil {
+					return err
+				}
+			}
+
+			aRepo := audit.NewRepo(tx)
+			whRepo := webhooks.NewRepo(tx)
+			for i, u := range ux {
+				e := newEvent(r, session, audit.ActionUserInvited, audit.TargetUser, u.ID)
+				e.Changes = map[string]audit.Change{
+					"role": {After: dtos[i].Role},
+					"team": {After: dtos[i].Team},
+				}
+				if err := aRepo.Record(ctx, e); err != nil {
+					return err
+				}
+
+				if err := whRepo.Enqueue(ctx, session.Workspace, webhooks.EventUserInvited, u.Ref()); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
 		if err != nil {
+			if errors.Is(err, users.ErrExistingEmail) {
+				panic(errEmailTaken.Msg("Some of these users are already members of your workspace"))
+			}
 			panic(err)
 		}
 
+		var workspace *workspaces.Workspace
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			workspace, err = workspaces.NewRepo(tx).Get(ctx, session.Workspace)
+			return err
+		})
+
 		// send them mail invitations
 		var ivs []invitations.Invitation
 		for _, u := range ux {
-			iv, err := ivStore.Create(r.Context(), session.Workspace, session.CompanyName, u.EmailAddress)
+			// users with accounts have nothing to set up, they only need to know
+			if len(u.Password) > 0 {
+				iv := invitations.Invitation{
+					Workspace:    workspace.ID,
+					CompanyName:  workspace.CompanyName,
+					Slug:         workspace.Slug,
+					EmailAddress: string(u.EmailAddress),
+				}
+
+				if err := invitations.SendMembership(r.Context(), mailer, env.ClientLoginPage.String(), iv); err != nil {
+					panic(err)
+				}
+
+				ivs = append(ivs, iv)
+				continue
+			}
+
+			iv, err := ivStore.Create(r.Context(), workspace, string(u.EmailAddress))
 			if err != nil {
 				panic(err)
 			}
 
-			if err := invitations.SendInvitation(mailer, env.ClientUserPage, iv); err != nil {
+			if err := invitations.SendInvitation(r.Context(), mailer, env.ClientUserPage.String(), iv); err != nil {
 				panic(err)
 			}
 
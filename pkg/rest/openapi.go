@@ -0,0 +1,183 @@
+package rest
+
+import (
+	"net/http"
+
+	"noxecane/go-starter/pkg/audit"
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/idempotency"
+	"noxecane/go-starter/pkg/invitations"
+	"noxecane/go-starter/pkg/notification"
+	"noxecane/go-starter/pkg/openapi"
+	"noxecane/go-starter/pkg/paging"
+	"noxecane/go-starter/pkg/secure"
+	"noxecane/go-starter/pkg/teams"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/webhooks"
+	"noxecane/go-starter/pkg/workspaces"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/noxecane/anansi/api"
+)
+
+// docsPage renders the spec next to it with Redoc.
+const docsPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>API docs</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+  </head>
+  <body>
+    <redoc spec-url="openapi.json"></redoc>
+    <script src="https://cdn.redoc.ly/redoc/v2.1.3/bundles/redoc.standalone.js"></script>
+  </body>
+</html>
+`
+
+// docsPolicy lets the docs page load Redoc, its fonts and the spec.
+const docsPolicy = "default-src 'none'; script-src https://cdn.redoc.ly; style-src 'unsafe-inline' https://fonts.googleapis.com; " +
+	"font-src https://fonts.gstatic.com; img-src data: https:; worker-src blob:; connect-src 'self'; frame-ancestors 'none'"
+
+var (
+	idParam   = openapi.Param{Name: "id", In: "path", Type: "integer"}
+	userParam = openapi.Param{Name: "user", In: "path", Type: "integer"}
+
+	limitParam = func(max string) openapi.Param {
+		return openapi.Param{Name: "limit", In: "query", Type: "integer", Description: "How many to return, at most " + max}
+	}
+	idempotencyParam = openapi.Param{
+		Name:        idempotency.Header,
+		In:          "header",
+		Description: "A unique key for the request, retries with the same key get the first response back instead of repeating it",
+	}
+
+	beforeParam = openapi.Param{Name: "before", In: "query", Type: "integer", Description: "Only return those older than this ID, to page through them"}
+
+	userListParams = []openapi.Param{
+		limitParam("200"),
+		{Name: paging.CursorParam, In: "query", Description: "The next or previous cursor of the page before, to page through them"},
+		{
+			Name: paging.SortParam, In: "query", Description: "The field to sort by, prefixed with - for descending order, id by default",
+			Enum: []interface{}{"id", "-id", "created_at", "-created_at"},
+		},
+		{Name: "id", In: "query", Description: "Only return the users with these IDs, separated by commas"},
+		{Name: "email_address", In: "query", Description: "Only return the users with these exact email addresses, separated by commas"},
+		{Name: "role", In: "query", Description: "Only return the users with these roles, separated by commas"},
+		{Name: "created_at.gte", In: "query", Type: "date-time", Description: "Only return users created from this time on"},
+		{Name: "created_at.lt", In: "query", Type: "date-time", Description: "Only return users created before this time"},
+	}
+
+	auditParams = []openapi.Param{
+		{Name: "actor", In: "query", Type: "integer", Description: "The ID of the user who acted"},
+		{Name: "action", In: "query", Description: "The action taken, like `user.invited`"},
+		{Name: "target_type", In: "query", Description: "The type of thing acted on"},
+		{Name: "target_id", In: "query", Description: "The ID of the thing acted on"},
+		beforeParam,
+		{Name: "since", In: "query", Type: "date-time", Description: "Only return events from this time on"},
+		{Name: "until", In: "query", Type: "date-time", Description: "Only return events before this time"},
+	}
+)
+
+// routes documents every route the API serves, keep it in step with the
+// registrations or TestRoutesDocumented fails.
+var routes = []openapi.Route{
+	{Method: http.MethodGet, Path: "/openapi.json", Tag: "docs", Summary: "This document", Response: map[string]interface{}{}, Public: true},
+	{Method: http.MethodGet, Path: "/docs", Tag: "docs", Summary: "Browse this document", Response: openapi.File{"text/html"}, Public: true},
+
+	{Method: http.MethodPost, Path: "/invitations/", Tag: "invitations", Summary: "Invite users to the workspace", Body: []InvitationDTO{}, Response: []invitations.Invitation{}, Errors: []int{403, 409}},
+	{Method: http.MethodPatch, Path: "/invitations/{token}/extend", Tag: "invitations", Summary: "Extend an invitation before it expires", Response: invitations.Invitation{}, Public: true, Errors: []int{401}},
+	{Method: http.MethodPatch, Path: "/invitations/{token}/accept", Tag: "invitations", Summary: "Accept an invitation, setting up the user's account", Body: RegistrationDTO{}, Response: session{}, Public: true, Errors: []int{401, 403, 409}},
+
+	{Method: http.MethodGet, Path: "/sessions/workspaces", Tag: "sessions", Summary: "List the workspaces the user belongs to", Response: []users.Membership{}},
+	{Method: http.MethodPost, Path: "/sessions/switch", Tag: "sessions", Summary: "Start a session in another of the user's workspaces", Body: SwitchDTO{}, Response: session{}, Errors: []int{403}},
+
+	{Method: http.MethodGet, Path: "/teams/", Tag: "teams", Summary: "List the workspace's teams", Response: []teams.Team{}, Errors: []int{403}},
+	{Method: http.MethodPost, Path: "/teams/", Tag: "teams", Summary: "Create a team", Body: TeamDTO{}, Response: teams.Team{}, Errors: []int{403, 409}},
+	{Method: http.MethodGet, Path: "/teams/{id}", Tag: "teams", Summary: "Get a team", Params: []openapi.Param{idParam}, Response: teams.Team{}, Errors: []int{403, 404}},
+	{Method: http.MethodPut, Path: "/teams/{id}", Tag: "teams", Summary: "Update a team", Params: []openapi.Param{idParam}, Body: TeamDTO{}, Response: teams.Team{}, Errors: []int{403, 404, 409}},
+	{Method: http.MethodDelete, Path: "/teams/{id}", Tag: "teams", Summary: "Delete a team", Params: []openapi.Param{idParam}, Response: teams.Team{}, Errors: []int{403, 404}},
+	{Method: http.MethodGet, Path: "/teams/{id}/members", Tag: "teams", Summary: "List a team's members", Params: []openapi.Param{idParam}, Response: []teams.Member{}, Errors: []int{403, 404}},
+	{Method: http.MethodPost, Path: "/teams/{id}/members", Tag: "teams", Summary: "Add a member to a team", Params: []openapi.Param{idParam}, Body: TeamMemberDTO{}, Response: teams.Member{}, Errors: []int{403, 404, 409}},
+	{Method: http.MethodPatch, Path: "/teams/{id}/members/{user}", Tag: "teams", Summary: "Change a member's role in a team", Params: []openapi.Param{idParam, userParam}, Body: TeamRoleDTO{}, Response: teams.Member{}, Errors: []int{403, 404}},
+	{Method: http.MethodDelete, Path: "/teams/{id}/members/{user}", Tag: "teams", Summary: "Remove a member from a team", Params: []openapi.Param{idParam, userParam}, Errors: []int{403, 404}},
+
+	{Method: http.MethodGet, Path: "/users/", Tag: "users", Summary: "List the workspace's users", Params: userListParams, Response: paging.Page[users.User]{}},
+	{Method: http.MethodPatch, Path: "/users/me/password", Tag: "users", Summary: "Change the user's password", Body: PasswordDTO{}, Response: users.User{}, Errors: []int{404}},
+	{Method: http.MethodPatch, Path: "/users/{id}/role", Tag: "users", Summary: "Change a user's role in the workspace", Params: []openapi.Param{idParam}, Body: UserRoleDTO{}, Response: users.User{}, Errors: []int{403, 404, 409}},
+	{Method: http.MethodDelete, Path: "/users/{id}", Tag: "users", Summary: "Remove a user from the workspace", Params: []openapi.Param{idParam}, Response: users.User{}, Errors: []int{403, 404, 409}},
+
+	{Method: http.MethodGet, Path: "/webhooks/events", Tag: "webhooks", Summary: "List the events webhooks can subscribe to", Response: []string{}, Errors: []int{403}},
+	{Method: http.MethodGet, Path: "/webhooks/", Tag: "webhooks", Summary: "List the workspace's webhooks", Response: []webhooks.Endpoint{}, Errors: []int{403}},
+	{Method: http.MethodPost, Path: "/webhooks/", Tag: "webhooks", Summary: "Create a webhook", Description: "The signing secret is only ever returned here.", Body: WebhookDTO{}, Response: createdWebhook{}, Errors: []int{403}},
+	{Method: http.MethodGet, Path: "/webhooks/{id}", Tag: "webhooks", Summary: "Get a webhook", Params: []openapi.Param{idParam}, Response: webhooks.Endpoint{}, Errors: []int{403, 404}},
+	{Method: http.MethodPut, Path: "/webhooks/{id}", Tag: "webhooks", Summary: "Update a webhook", Params: []openapi.Param{idParam}, Body: WebhookDTO{}, Response: webhooks.Endpoint{}, Errors: []int{403, 404}},
+	{Method: http.MethodDelete, Path: "/webhooks/{id}", Tag: "webhooks", Summary: "Delete a webhook", Params: []openapi.Param{idParam}, Response: webhooks.Endpoint{}, Errors: []int{403, 404}},
+	{Method: http.MethodPost, Path: "/webhooks/{id}/test", Tag: "webhooks", Summary: "Send a test event to a webhook", Params: []openapi.Param{idParam}, Response: webhooks.Delivery{}, Errors: []int{403, 404}},
+	{Method: http.MethodGet, Path: "/webhooks/{id}/deliveries", Tag: "webhooks", Summary: "List a webhook's deliveries, newest first", Params: []openapi.Param{idParam, limitParam("200"), beforeParam}, Response: []webhooks.Delivery{}, Errors: []int{403, 404}},
+
+	{Method: http.MethodGet, Path: "/workspaces/branding", Tag: "workspaces", Summary: "Get the branding of the workspace named by the X-Workspace header or subdomain", Params: []openapi.Param{{Name: "X-Workspace", In: "header", Description: "The workspace's slug"}}, Response: branding{}, Public: true, Errors: []int{404}},
+	{Method: http.MethodPatch, Path: "/workspaces/restore", Tag: "workspaces", Summary: "Restore a deleted workspace within its grace period", Response: workspaces.Workspace{}, Errors: []int{403, 409}},
+	{Method: http.MethodDelete, Path: "/workspaces", Tag: "workspaces", Summary: "Delete the workspace, it can be restored until the grace period is up", Response: workspaces.Workspace{}, Errors: []int{403}},
+	{Method: http.MethodPatch, Path: "/workspaces/name", Tag: "workspaces", Summary: "Rename the workspace", Body: WorkspaceNameDTO{}, Response: workspaces.Workspace{}, Errors: []int{403}},
+	{Method: http.MethodPatch, Path: "/workspaces/slug", Tag: "workspaces", Summary: "Change the workspace's slug", Body: SlugDTO{}, Response: workspaces.Workspace{}, Errors: []int{403, 409}},
+	{Method: http.MethodPost, Path: "/workspaces/transfers", Tag: "workspaces", Summary: "Nominate a new owner for the workspace", Body: TransferDTO{}, Response: users.TransferToken{}, Errors: []int{403}},
+	{Method: http.MethodPatch, Path: "/workspaces/transfers/{token}/accept", Tag: "workspaces", Summary: "Accept ownership of the workspace", Response: users.User{}, Errors: []int{403, 404, 409}},
+
+	{Method: http.MethodGet, Path: "/audit-events/", Tag: "audit", Summary: "List the workspace's audit events, newest first", Params: append([]openapi.Param{limitParam("200")}, auditParams...), Response: []audit.Event{}, Errors: []int{403}},
+	{
+		Method: http.MethodGet, Path: "/audit-events/export", Tag: "audit", Summary: "Download up to 10000 audit events",
+		Description: "When there are more, the X-Next-Before header has the ID to pass as before for the next batch.",
+		Params:      append([]openapi.Param{{Name: "format", In: "query", Enum: []interface{}{"csv", "json"}, Description: "csv by default"}}, auditParams...),
+		Response:    openapi.File{"text/csv", "application/json"}, Errors: []int{403},
+	},
+}
+
+// Routes sets up every route of the API, along with its spec at /openapi.json and
+// docs at /docs. The spec leaving out some routes, or documenting ones that don't
+// exist, is reported as an *openapi.DriftError, the API still works either way.
+func Routes(r *chi.Mux, app *config.App, mailer notification.Mailer, dispatcher *webhooks.Dispatcher) error {
+	Invitations(r, app, mailer)
+	Workspaces(r, app, mailer)
+	Sessions(r, app)
+	Teams(r, app)
+	Users(r, app)
+	AuditEvents(r, app)
+	Webhooks(r, app, dispatcher)
+
+	var spec *openapi.Document
+
+	r.Get("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		api.Success(r, w, spec)
+	})
+
+	r.With(secure.Override(func(p *secure.Policy) { p.CSP = docsPolicy })).Get("/docs", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(docsPage))
+	})
+
+	info := openapi.Info{
+		Title:   app.Env.Name,
+		Version: "v1",
+	}
+
+	// every route is rate limited, and every route that changes things takes an
+	// idempotency key
+	docs := make([]openapi.Route, len(routes))
+	for i, route := range routes {
+		route.Errors = append(append([]int(nil), route.Errors...), http.StatusTooManyRequests)
+
+		switch route.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			route.Params = append(append([]openapi.Param(nil), route.Params...), idempotencyParam)
+		}
+		docs[i] = route
+	}
+
+	spec, err := openapi.Build(info, r, docs)
+	if spec != nil {
+		spec.Servers = []openapi.Server{{URL: "/api/v1"}}
+	}
+
+	return err
+}
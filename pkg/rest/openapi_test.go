@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/openapi"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRoutesDocumented(t *testing.T) {
+	router := chi.NewRouter()
+
+	// nothing's called while registering routes, so no connections needed
+	app := &config.App{Env: &config.Env{Name: "go-starter", WorkspaceGracePeriod: 720 * time.Hour}}
+	if err := Routes(router, app, nil, nil); err != nil {
+		t.Fatalf("Expected every route to be documented, got %v", err)
+	}
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected to get the spec, got %d", res.Code)
+	}
+
+	var spec openapi.Document
+	if err := json.Unmarshal(res.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	accept := (*spec.Paths["/invitations/{token}/accept"])["patch"]
+	if accept == nil || accept.OperationID != "acceptInvitation" {
+		t.Fatalf("Expected accepting invitations to be documented, got %+v", accept)
+	}
+
+	registration := spec.Components.Schemas["RegistrationDTO"]
+	if registration == nil || *registration.Properties["password"].MinLength != 8 {
+		t.Errorf("Expected the registration's rules in its schema, got %+v", registration)
+	}
+
+	if spec.Components.Schemas["Session"] == nil {
+		t.Error("Expected the session response in the spec")
+	}
+
+	if spec.Components.Schemas["UserPage"] == nil {
+		t.Error("Expected pages of users to be named after what they hold")
+	}
+}
@@ -1,5 +1,26 @@
 package rest
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/tenant"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/workspaces"
+
+	"github.com/go-chi/chi/v5"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/noxecane/anansi/api"
+	"github.com/noxecane/anansi/sessions"
+	"github.com/noxecane/anansi/tokens"
+	"github.com/uptrace/bun"
+)
+
 type session struct {
 	Workspace   uint   `json:"workspace"`
 	User        uint   `json:"user"`
@@ -8,3 +29,171 @@ type session struct {
 	SessionKey  string `json:"session_key"`
 	FullName    string `json:"full_name"`
 }
+
+type SwitchDTO struct {
+	Workspace uint `json:"workspace"`
+}
+
+func (t *SwitchDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.Workspace, ozzo.Required),
+	}
+}
+
+func (t *SwitchDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+// SessionWorkspace returns the ID of the workspace of the request's session, for
+// limiting requests by workspace. Requests without a valid session have none. The
+// session isn't extended, that's left for the handlers of requests that get through.
+func SessionWorkspace(auth *keyring.SessionStore) func(*http.Request) string {
+	return func(r *http.Request) string {
+		var session session
+		if err := auth.Peek(r, &session); err != nil || session.Workspace == 0 {
+			return ""
+		}
+
+		return strconv.FormatUint(uint64(session.Workspace), 10)
+	}
+}
+
+func Sessions(r *chi.Mux, app *config.App) {
+	uRepo := users.NewRepo(app.DB)
+
+	// no ActiveWorkspace here, users need to be able to switch away from deleted workspaces
+	r.Route("/sessions", func(r chi.Router) {
+		r.Get("/workspaces", listWorkspaces(app.Auth, uRepo))
+		r.Post("/switch", switchWorkspace(app.Auth, app.DB))
+	})
+}
+
+func sessionKey(user, workspace uint) string {
+	return fmt.Sprintf("session:%d:%d", user, workspace)
+}
+
+// endSession logs the user out of the workspace, for when their place in it changes.
+func endSession(ctx context.Context, tStore tokens.Store, user, workspace uint) error {
+	if err := tStore.Revoke(ctx, sessionKey(user, workspace)); err != nil && !errors.Is(err, tokens.ErrTokenNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// loadSession is api.Load for sessions whose headless tokens may be from any key in the
+// keyring, rejecting the request with a 401 when there's no valid session.
+func loadSession(auth *keyring.SessionStore, r *http.Request, v interface{}) {
+	err := auth.Load(r, v)
+	if err == nil {
+		return
+	}
+
+	switch err {
+	case sessions.ErrEmptyHeader:
+		panic(api.Err{Code: http.StatusUnauthorized, Message: "Your request is not authenticated"})
+	case sessions.ErrHeaderFormat:
+		panic(api.Err{Code: http.StatusUnauthorized, Message: "Your authorization header is incorrect"})
+	case sessions.ErrUnsupportedScheme:
+		panic(api.Err{Code: http.StatusUnauthorized, Message: "We don't support your authorization scheme"})
+	default:
+		panic(api.Err{Code: http.StatusUnauthorized, Message: "Your token is either invalid or has expired", Err: err})
+	}
+}
+
+// scoped runs fn in a transaction that only sees the workspace's rows, which every read
+// made for a session goes through like the writes do.
+func scoped(r *http.Request, db bun.IDB, wkID uint, fn func(ctx context.Context, tx bun.Tx) error) {
+	if err := tenant.RunInTx(r.Context(), db, wkID, fn); err != nil {
+		panic(err)
+	}
+}
+
+// ActiveWorkspace rejects authenticated requests made against a deleted workspace.
+// Requests without a session are left for the handlers to deal with.
+func ActiveWorkspace(auth *keyring.SessionStore, db bun.IDB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var session session
+			if err := auth.Load(r, &session); err == nil {
+				if tenant := RequestWorkspace(r); tenant != nil && tenant.ID != session.Workspace {
+					panic(errWrongWorkspace)
+				}
+
+				var workspace *workspaces.Workspace
+				scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+					var err error
+					workspace, err = workspaces.NewRepo(tx).Get(ctx, session.Workspace)
+					return err
+				})
+
+				if workspace == nil || workspace.IsDeleted() {
+					panic(errWorkspaceDeleted)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func listWorkspaces(auth *keyring.SessionStore, uRepo *users.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		// the user's own memberships, across every workspace
+		members, err := uRepo.Memberships(r.Context(), session.User)
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, members)
+	}
+}
+
+func switchWorkspace(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var current session
+		loadSession(auth, r, &current)
+
+		var dto SwitchDTO
+		api.ReadJSON(r, &dto)
+
+		var user *users.User
+		var workspace *workspaces.Workspace
+		scoped(r, db, dto.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			if user, err = users.NewRepo(tx).Get(ctx, dto.Workspace, current.User); err != nil || user == nil {
+				return err
+			}
+
+			workspace, err = workspaces.NewRepo(tx).Get(ctx, dto.Workspace)
+			return err
+		})
+
+		if user == nil {
+			panic(errNotMember)
+		}
+
+		if workspace == nil || workspace.IsDeleted() {
+			panic(errWorkspaceDeleted)
+		}
+
+		next := session{
+			Workspace:   workspace.ID,
+			User:        user.ID,
+			Role:        user.Role,
+			CompanyName: workspace.CompanyName,
+			FullName:    fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		}
+
+		var err error
+		next.SessionKey, err = auth.Save(r, sessionKey(user.ID, workspace.ID), next)
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, next)
+	}
+}
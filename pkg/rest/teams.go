@@ -0,0 +1,379 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+
+	"noxecane/go-starter/pkg/audit"
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/teams"
+	"noxecane/go-starter/pkg/tenant"
+	"noxecane/go-starter/pkg/users"
+
+	"github.com/go-chi/chi/v5"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/noxecane/anansi/api"
+	"github.com/uptrace/bun"
+)
+
+type TeamDTO struct {
+	Name        string `json:"name" mod:"trim"`
+	Description string `json:"description" mod:"trim"`
+}
+
+func (t *TeamDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.Name, ozzo.Required, ozzo.Length(1, 100)),
+		ozzo.Field(&t.Description, ozzo.Length(0, 500)),
+	}
+}
+
+func (t *TeamDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+type TeamMemberDTO struct {
+	User uint   `json:"user"`
+	Role string `json:"role" mod:"smalltext"`
+}
+
+func (t *TeamMemberDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.User, ozzo.Required),
+		ozzo.Field(&t.Role, ozzo.Required, ozzo.In(teams.RoleMember, teams.RoleLead)),
+	}
+}
+
+func (t *TeamMemberDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+type TeamRoleDTO struct {
+	Role string `json:"role" mod:"smalltext"`
+}
+
+func (t *TeamRoleDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.Role, ozzo.Required, ozzo.In(teams.RoleMember, teams.RoleLead)),
+	}
+}
+
+func (t *TeamRoleDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+func Teams(r *chi.Mux, app *config.App) {
+	r.Route("/teams", func(r chi.Router) {
+		r.Use(ActiveWorkspace(app.Auth, app.DB))
+
+		r.Get("/", listTeams(app.Auth, app.DB))
+		r.Post("/", createTeam(app.Auth, app.DB))
+		r.Get("/{id}", getTeam(app.Auth, app.DB))
+		r.Put("/{id}", updateTeam(app.Auth, app.DB))
+		r.Delete("/{id}", deleteTeam(app.Auth, app.DB))
+
+		r.Get("/{id}/members", listTeamMembers(app.Auth, app.DB))
+		r.Post("/{id}/members", addTeamMember(app.Auth, app.DB))
+		r.Patch("/{id}/members/{user}", changeTeamRole(app.Auth, app.DB))
+		r.Delete("/{id}/members/{user}", removeTeamMember(app.Auth, app.DB))
+	})
+}
+
+// canManageTeam checks whether the session's user can change who is in the team, which
+// workspace admins can do for every team and leads only for their own.
+func canManageTeam(r *http.Request, db bun.IDB, s session, team uint) bool {
+	if s.Role != users.RoleMember {
+		return true
+	}
+
+	var member *teams.Member
+	scoped(r, db, s.Workspace, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		member, err = teams.NewRepo(tx).Member(ctx, s.Workspace, team, s.User)
+		return err
+	})
+
+	return member != nil && member.Role == teams.RoleLead
+}
+
+func loadTeam(r *http.Request, db bun.IDB, s session) *teams.Team {
+	id := api.IDParam(r, "id")
+
+	var team *teams.Team
+	scoped(r, db, s.Workspace, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		team, err = teams.NewRepo(tx).Get(ctx, s.Workspace, id)
+		return err
+	})
+
+	if team == nil {
+		panic(errTeamNotFound)
+	}
+
+	return team
+}
+
+func listTeams(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		var tl []teams.Team
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			tl, err = teams.NewRepo(tx).List(ctx, session.Workspace)
+			return err
+		})
+
+		api.Success(r, w, tl)
+	}
+}
+
+func createTeam(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		if session.Role == users.RoleMember {
+			panic(errRoleNotAllowed.Msg("You are not allowed to create teams"))
+		}
+
+		var dto TeamDTO
+		api.ReadJSON(r, &dto)
+
+		var team *teams.Team
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			if team, err = teams.NewRepo(tx).Create(ctx, session.Workspace, dto.Name, dto.Description); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionTeamCreated, audit.TargetTeam, team.ID)
+			e.Changes = audit.Diff(nil, team)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, team)
+	}
+}
+
+func getTeam(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		api.Success(r, w, loadTeam(r, db, session))
+	}
+}
+
+func updateTeam(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		before := loadTeam(r, db, session)
+		if !canManageTeam(r, db, session, before.ID) {
+			panic(errRoleNotAllowed.Msg("You are not allowed to change this team"))
+		}
+
+		var dto TeamDTO
+		api.ReadJSON(r, &dto)
+
+		var team *teams.Team
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			team, err = teams.NewRepo(tx).Update(ctx, session.Workspace, before.ID, dto.Name, dto.Description)
+			if err != nil || team == nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionTeamUpdated, audit.TargetTeam, team.ID)
+			e.Changes = audit.Diff(before, team)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if team == nil {
+			panic(errTeamNotFound)
+		}
+
+		api.Success(r, w, team)
+	}
+}
+
+func deleteTeam(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		if session.Role == users.RoleMember {
+			panic(errRoleNotAllowed.Msg("You are not allowed to delete teams"))
+		}
+
+		team := loadTeam(r, db, session)
+
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			if _, err := teams.NewRepo(tx).Delete(ctx, session.Workspace, team.ID); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionTeamDeleted, audit.TargetTeam, team.ID)
+			e.Changes = audit.Diff(team, nil)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, team)
+	}
+}
+
+func listTeamMembers(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		team := loadTeam(r, db, session)
+
+		var members []teams.Member
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			members, err = teams.NewRepo(tx).Members(ctx, session.Workspace, team.ID)
+			return err
+		})
+
+		api.Success(r, w, members)
+	}
+}
+
+func addTeamMember(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		team := loadTeam(r, db, session)
+		if !canManageTeam(r, db, session, team.ID) {
+			panic(errRoleNotAllowed.Msg("You are not allowed to add members to this team"))
+		}
+
+		var dto TeamMemberDTO
+		api.ReadJSON(r, &dto)
+
+		var member *teams.Member
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			txRepo := teams.NewRepo(tx)
+			if err := txRepo.AddMembers(ctx, session.Workspace, team.ID, []uint{dto.User}, dto.Role); err != nil {
+				return err
+			}
+
+			var err error
+			if member, err = txRepo.Member(ctx, session.Workspace, team.ID, dto.User); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionTeamMemberAdded, audit.TargetTeam, team.ID)
+			e.Changes = map[string]audit.Change{
+				"user": {After: dto.User},
+				"role": {After: dto.Role},
+			}
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, member)
+	}
+}
+
+func changeTeamRole(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		team := loadTeam(r, db, session)
+		if !canManageTeam(r, db, session, team.ID) {
+			panic(errRoleNotAllowed.Msg("You are not allowed to change roles in this team"))
+		}
+
+		var dto TeamRoleDTO
+		api.ReadJSON(r, &dto)
+
+		userID := api.IDParam(r, "user")
+
+		var member *teams.Member
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			txRepo := teams.NewRepo(tx)
+
+			before, err := txRepo.Member(ctx, session.Workspace, team.ID, userID)
+			if err != nil || before == nil {
+				return err
+			}
+
+			if member, err = txRepo.ChangeRole(ctx, session.Workspace, team.ID, userID, dto.Role); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionTeamRoleChanged, audit.TargetTeam, team.ID)
+			e.Changes = map[string]audit.Change{
+				"user": {Before: userID, After: userID},
+				"role": {Before: before.Role, After: member.Role},
+			}
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if member == nil {
+			panic(errNotInTeam)
+		}
+
+		api.Success(r, w, member)
+	}
+}
+
+func removeTeamMember(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		team := loadTeam(r, db, session)
+		userID := api.IDParam(r, "user")
+
+		// anyone can leave a team on their own
+		if userID != session.User && !canManageTeam(r, db, session, team.ID) {
+			panic(errRoleNotAllowed.Msg("You are not allowed to remove members from this team"))
+		}
+
+		var ok bool
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			if ok, err = teams.NewRepo(tx).RemoveMember(ctx, session.Workspace, team.ID, userID); err != nil || !ok {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionTeamMemberRemoved, audit.TargetTeam, team.ID)
+			e.Changes = map[string]audit.Change{
+				"user": {Before: userID},
+			}
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if !ok {
+			panic(errNotInTeam)
+		}
+
+		api.Success(r, w, nil)
+	}
+}
@@ -0,0 +1,230 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"noxecane/go-starter/pkg/audit"
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/paging"
+	"noxecane/go-starter/pkg/tenant"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/webhooks"
+
+	"github.com/go-chi/chi/v5"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/noxecane/anansi/api"
+	"github.com/noxecane/anansi/tokens"
+	"github.com/uptrace/bun"
+)
+
+type PasswordDTO struct {
+	CurrentPassword string `json:"current_password" mod:"trim"`
+	NewPassword     string `json:"new_password" mod:"trim"`
+}
+
+func (t *PasswordDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.CurrentPassword, ozzo.Required),
+		ozzo.Field(&t.NewPassword, ozzo.Required, ozzo.Length(8, 64)),
+	}
+}
+
+func (t *PasswordDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+type UserRoleDTO struct {
+	Role string `json:"role" mod:"smalltext"`
+}
+
+func (t *UserRoleDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.Role, ozzo.Required, ozzo.In(users.RoleMember, users.RoleAdmin)),
+	}
+}
+
+func (t *UserRoleDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+func Users(r *chi.Mux, app *config.App) {
+	r.Route("/users", func(r chi.Router) {
+		r.Use(ActiveWorkspace(app.Auth, app.DB))
+
+		r.Get("/", listUsers(app.Auth, app.DB, app.Env.SigningKeys))
+		r.Patch("/me/password", changePassword(app.Auth, app.DB))
+		r.Patch("/{id}/role", changeUserRole(app.Auth, app.Tokens, app.DB))
+		r.Delete("/{id}", removeUser(app.Auth, app.Tokens, app.DB))
+	})
+}
+
+func listUsers(auth *keyring.SessionStore, db bun.IDB, keys keyring.Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		q, err := paging.Parse(r, users.ListSpec, keys)
+		if err != nil {
+			panic(err)
+		}
+
+		var ux []users.User
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			ux, err = users.NewRepo(tx).List(ctx, session.Workspace, q)
+			return err
+		})
+
+		api.Success(r, w, paging.NewPage(q, ux))
+	}
+}
+
+func changePassword(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		var dto PasswordDTO
+		api.ReadJSON(r, &dto)
+
+		var user *users.User
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			uRepo := users.NewRepo(tx)
+
+			current, err := uRepo.Get(ctx, session.Workspace, session.User)
+			if err != nil {
+				return err
+			}
+
+			if current == nil {
+				panic(errAccountNotFound)
+			}
+
+			if err := users.ValidatePassword(dto.CurrentPassword, current.Password); err != nil {
+				return err
+			}
+
+			if user, err = uRepo.ChangePassword(ctx, session.Workspace, session.User, dto.NewPassword); err != nil {
+				return err
+			}
+
+			// no diff, we don't want password hashes in the audit log
+			e := newEvent(r, session, audit.ActionPasswordChanged, audit.TargetUser, session.User)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, user)
+	}
+}
+
+func changeUserRole(auth *keyring.SessionStore, tStore tokens.Store, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		if session.Role == users.RoleMember {
+			panic(errRoleNotAllowed.Msg("You are not allowed to change roles"))
+		}
+
+		var dto UserRoleDTO
+		api.ReadJSON(r, &dto)
+
+		id := api.IDParam(r, "id")
+
+		var user *users.User
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			uRepo := users.NewRepo(tx)
+
+			before, err := uRepo.Get(ctx, session.Workspace, id)
+			if err != nil || before == nil {
+				return err
+			}
+
+			if user, err = uRepo.ChangeRole(ctx, session.Workspace, id, dto.Role); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionUserRoleChanged, audit.TargetUser, id)
+			e.Changes = audit.Diff(before.Ref(), user.Ref())
+			if err := audit.NewRepo(tx).Record(ctx, e); err != nil {
+				return err
+			}
+
+			return webhooks.NewRepo(tx).Enqueue(ctx, session.Workspace, webhooks.EventUserRoleChanged, roleChange(user, before.Role))
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if user == nil {
+			panic(errUserNotFound)
+		}
+
+		// sessions hold the old role, so they need to log back in
+		if err := endSession(r.Context(), tStore, user.ID, session.Workspace); err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, user)
+	}
+}
+
+func removeUser(auth *keyring.SessionStore, tStore tokens.Store, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		id := api.IDParam(r, "id")
+
+		// anyone can leave a workspace on their own
+		if id != session.User && session.Role == users.RoleMember {
+			panic(errRoleNotAllowed.Msg("You are not allowed to remove users"))
+		}
+
+		var user *users.User
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			if user, err = users.NewRepo(tx).RemoveMember(ctx, session.Workspace, id); err != nil || user == nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionUserRemoved, audit.TargetUser, id)
+			e.Changes = audit.Diff(user.Ref(), nil)
+			if err := audit.NewRepo(tx).Record(ctx, e); err != nil {
+				return err
+			}
+
+			return webhooks.NewRepo(tx).Enqueue(ctx, session.Workspace, webhooks.EventUserLeft, user.Ref())
+		})
+		if err != nil {
+			if errors.Is(err, users.ErrOwnerRole) {
+				panic(errOwnerLeaving)
+			}
+			panic(err)
+		}
+
+		if user == nil {
+			panic(errUserNotFound)
+		}
+
+		// sessions hold the old role, so they need to log back in
+		if err := endSession(r.Context(), tStore, user.ID, session.Workspace); err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, user)
+	}
+}
+
+// roleChange is the webhook data for user.role_changed events.
+func roleChange(user *users.User, previous string) interface{} {
+	return struct {
+		users.Ref
+		PreviousRole string `json:"previous_role"`
+	}{user.Ref(), previous}
+}
@@ -0,0 +1,302 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"noxecane/go-starter/pkg/audit"
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/problem"
+	"noxecane/go-starter/pkg/tenant"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/webhooks"
+
+	"github.com/go-chi/chi/v5"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/noxecane/anansi/api"
+	"github.com/uptrace/bun"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+var webhookScheme = ozzo.NewStringRuleWithError(
+	func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+	},
+	ozzo.NewError("validation_is_webhook_url", "must be an http or https URL"),
+)
+
+type WebhookDTO struct {
+	URL         string   `json:"url" mod:"trim"`
+	Description string   `json:"description" mod:"trim"`
+	Events      []string `json:"events"`
+	Active      *bool    `json:"active"`
+}
+
+func (t *WebhookDTO) Rules() []*ozzo.FieldRules {
+	events := make([]interface{}, len(webhooks.Events))
+	for i, e := range webhooks.Events {
+		events[i] = e
+	}
+
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.URL, ozzo.Required, is.URL, webhookScheme),
+		ozzo.Field(&t.Description, ozzo.Length(0, 500)),
+		ozzo.Field(&t.Events, ozzo.Each(ozzo.In(events...))),
+	}
+}
+
+func (t *WebhookDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+func (t *WebhookDTO) request() webhooks.EndpointRequest {
+	// endpoints start out active unless told otherwise
+	active := t.Active == nil || *t.Active
+
+	return webhooks.EndpointRequest{
+		URL:         t.URL,
+		Description: t.Description,
+		Events:      t.Events,
+		Active:      active,
+	}
+}
+
+// createdWebhook is the only time an endpoint's secret is shown.
+type createdWebhook struct {
+	*webhooks.Endpoint
+	Secret string `json:"secret"`
+}
+
+func Webhooks(r *chi.Mux, app *config.App, dispatcher *webhooks.Dispatcher) {
+	r.Route("/webhooks", func(r chi.Router) {
+		r.Use(ActiveWorkspace(app.Auth, app.DB))
+
+		r.Get("/events", listWebhookEvents(app.Auth))
+		r.Get("/", listWebhooks(app.Auth, app.DB))
+		r.Post("/", createWebhook(app.Auth, app.DB, app.Env.WebhookAllowPrivate))
+		r.Get("/{id}", getWebhook(app.Auth, app.DB))
+		r.Put("/{id}", updateWebhook(app.Auth, app.DB, app.Env.WebhookAllowPrivate))
+		r.Delete("/{id}", deleteWebhook(app.Auth, app.DB))
+		r.Post("/{id}/test", testWebhook(app.Auth, app.DB, dispatcher))
+		r.Get("/{id}/deliveries", listWebhookDeliveries(app.Auth, app.DB))
+	})
+}
+
+func loadWebhookAdmin(auth *keyring.SessionStore, r *http.Request) session {
+	var session session
+	loadSession(auth, r, &session)
+
+	if session.Role == users.RoleMember {
+		panic(errRoleNotAllowed.Msg("You are not allowed to manage webhooks"))
+	}
+
+	return session
+}
+
+func loadWebhook(r *http.Request, db bun.IDB, s session) *webhooks.Endpoint {
+	id := api.IDParam(r, "id")
+
+	var endpoint *webhooks.Endpoint
+	scoped(r, db, s.Workspace, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		endpoint, err = webhooks.NewRepo(tx).Get(ctx, s.Workspace, id)
+		return err
+	})
+
+	if endpoint == nil {
+		panic(errWebhookNotFound)
+	}
+
+	return endpoint
+}
+
+// checkWebhookURL rejects endpoints that would have us make requests inside our own
+// network. The dispatcher checks again when it connects, as hostnames can change.
+func checkWebhookURL(u string, allowPrivate bool) {
+	if allowPrivate {
+		return
+	}
+
+	if err := webhooks.CheckURL(u); err != nil {
+		panic(problem.ValidationFailed.Field("url", "private_address", "Webhooks can't be sent to private, loopback or link-local addresses"))
+	}
+}
+
+func listWebhookEvents(auth *keyring.SessionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loadWebhookAdmin(auth, r)
+
+		api.Success(r, w, webhooks.Events)
+	}
+}
+
+func listWebhooks(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := loadWebhookAdmin(auth, r)
+
+		var endpoints []webhooks.Endpoint
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			endpoints, err = webhooks.NewRepo(tx).List(ctx, session.Workspace)
+			return err
+		})
+
+		api.Success(r, w, endpoints)
+	}
+}
+
+func createWebhook(auth *keyring.SessionStore, db bun.IDB, allowPrivate bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := loadWebhookAdmin(auth, r)
+
+		var dto WebhookDTO
+		api.ReadJSON(r, &dto)
+		checkWebhookURL(dto.URL, allowPrivate)
+
+		var endpoint *webhooks.Endpoint
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			if endpoint, err = webhooks.NewRepo(tx).Create(ctx, session.Workspace, dto.request()); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionWebhookCreated, audit.TargetWebhook, endpoint.ID)
+			e.Changes = audit.Diff(nil, endpoint)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, createdWebhook{endpoint, endpoint.Secret})
+	}
+}
+
+func getWebhook(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := loadWebhookAdmin(auth, r)
+
+		api.Success(r, w, loadWebhook(r, db, session))
+	}
+}
+
+func updateWebhook(auth *keyring.SessionStore, db bun.IDB, allowPrivate bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := loadWebhookAdmin(auth, r)
+		before := loadWebhook(r, db, session)
+
+		var dto WebhookDTO
+		api.ReadJSON(r, &dto)
+		checkWebhookURL(dto.URL, allowPrivate)
+
+		var endpoint *webhooks.Endpoint
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			endpoint, err = webhooks.NewRepo(tx).Update(ctx, session.Workspace, before.ID, dto.request())
+			if err != nil || endpoint == nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionWebhookUpdated, audit.TargetWebhook, endpoint.ID)
+			e.Changes = audit.Diff(before, endpoint)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if endpoint == nil {
+			panic(errWebhookNotFound)
+		}
+
+		api.Success(r, w, endpoint)
+	}
+}
+
+func deleteWebhook(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := loadWebhookAdmin(auth, r)
+		endpoint := loadWebhook(r, db, session)
+
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			if _, err := webhooks.NewRepo(tx).Delete(ctx, session.Workspace, endpoint.ID); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionWebhookDeleted, audit.TargetWebhook, endpoint.ID)
+			e.Changes = audit.Diff(endpoint, nil)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, endpoint)
+	}
+}
+
+// testWebhook sends a test event to the endpoint straight away, returning how it went.
+// The delivery is created as already sending, so the dispatcher can't send it too.
+func testWebhook(auth *keyring.SessionStore, db bun.IDB, dispatcher *webhooks.Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := loadWebhookAdmin(auth, r)
+		endpoint := loadWebhook(r, db, session)
+
+		var delivery *webhooks.Delivery
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			delivery, err = webhooks.NewRepo(tx).Test(ctx, endpoint)
+			return err
+		})
+
+		if err := dispatcher.Attempt(r.Context(), delivery); err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, delivery)
+	}
+}
+
+func listWebhookDeliveries(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := loadWebhookAdmin(auth, r)
+		endpoint := loadWebhook(r, db, session)
+
+		q := r.URL.Query()
+
+		limit := defaultDeliveryLimit
+		if v := q.Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxDeliveryLimit {
+				panic(invalidParam("limit", fmt.Sprintf("limit must be between 1 and %d", maxDeliveryLimit)))
+			}
+		}
+
+		var before uint64
+		if v := q.Get("before"); v != "" {
+			var err error
+			if before, err = strconv.ParseUint(v, 10, 64); err != nil {
+				panic(invalidParam("before", "before must be an integer ID"))
+			}
+		}
+
+		var deliveries []webhooks.Delivery
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			deliveries, err = webhooks.NewRepo(tx).Deliveries(ctx, session.Workspace, endpoint.ID, before, limit)
+			return err
+		})
+
+		api.Success(r, w, deliveries)
+	}
+}
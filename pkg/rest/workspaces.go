@@ -0,0 +1,409 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"noxecane/go-starter/pkg/audit"
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/keyring"
+	"noxecane/go-starter/pkg/notification"
+	"noxecane/go-starter/pkg/tenant"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/webhooks"
+	"noxecane/go-starter/pkg/workspaces"
+
+	"github.com/go-chi/chi/v5"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/noxecane/anansi/api"
+	"github.com/noxecane/anansi/tokens"
+	"github.com/uptrace/bun"
+)
+
+type contextKey string
+
+const tenantKey contextKey = "tenant"
+
+type WorkspaceNameDTO struct {
+	CompanyName string `json:"company_name" mod:"trim"`
+}
+
+func (t *WorkspaceNameDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.CompanyName, ozzo.Required, ozzo.Length(1, 100)),
+	}
+}
+
+func (t *WorkspaceNameDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+type SlugDTO struct {
+	Slug string `json:"slug" mod:"smalltext"`
+}
+
+func (t *SlugDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.Slug, ozzo.Required, ozzo.Length(3, 63)),
+	}
+}
+
+func (t *SlugDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+type branding struct {
+	ID          uint   `json:"id"`
+	CompanyName string `json:"company_name"`
+	Slug        string `json:"slug"`
+}
+
+type TransferDTO struct {
+	User uint `json:"user"`
+}
+
+func (t *TransferDTO) Rules() []*ozzo.FieldRules {
+	return []*ozzo.FieldRules{
+		ozzo.Field(&t.User, ozzo.Required),
+	}
+}
+
+func (t *TransferDTO) Validate() error {
+	return ozzo.ValidateStruct(t, t.Rules()...)
+}
+
+func Workspaces(r *chi.Mux, app *config.App, mailer notification.Mailer) {
+	r.Route("/workspaces", func(r chi.Router) {
+		r.Get("/branding", getBranding())
+
+		// the owner needs to reach a deleted workspace to restore it
+		r.Patch("/restore", restoreWorkspace(app.Auth, app.DB))
+
+		r.Group(func(r chi.Router) {
+			r.Use(ActiveWorkspace(app.Auth, app.DB))
+
+			r.Delete("/", deleteWorkspace(app.Auth, app.DB, app.Env.WorkspaceGracePeriod))
+			r.Patch("/name", changeWorkspaceName(app.Auth, app.DB))
+			r.Patch("/slug", changeSlug(app.Auth, app.DB))
+			r.Post("/transfers", nominateOwner(app.Auth, app.Tokens, app.DB, app.Env, mailer))
+			r.Patch("/transfers/{token}/accept", acceptOwnership(app.Auth, app.Tokens, app.DB, mailer))
+		})
+	})
+}
+
+// Tenant resolves the workspace a request is addressed to from the X-Workspace header
+// or, failing that, the subdomain of the host under the given domain. Requests that
+// don't name a workspace pass through untouched.
+func Tenant(wRepo *workspaces.Repo, domain string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wkSlug := tenantSlug(r, domain)
+			if wkSlug == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			workspace, err := wRepo.GetBySlug(r.Context(), wkSlug)
+			if err != nil {
+				panic(err)
+			}
+
+			if workspace == nil || workspace.IsDeleted() {
+				panic(errWorkspaceNotFound)
+			}
+
+			ctx := context.WithValue(r.Context(), tenantKey, workspace)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestWorkspace returns the workspace resolved by Tenant, if any.
+func RequestWorkspace(r *http.Request) *workspaces.Workspace {
+	workspace, _ := r.Context().Value(tenantKey).(*workspaces.Workspace)
+	return workspace
+}
+
+func tenantSlug(r *http.Request, domain string) string {
+	if wkSlug := r.Header.Get("X-Workspace"); wkSlug != "" {
+		return strings.ToLower(wkSlug)
+	}
+
+	if domain == "" {
+		return ""
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(domain))
+	if !ok || sub == "" || strings.Contains(sub, ".") {
+		return ""
+	}
+
+	return sub
+}
+
+func getBranding() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace := RequestWorkspace(r)
+		if workspace == nil {
+			panic(errNoWorkspace)
+		}
+
+		api.Success(r, w, branding{
+			ID:          workspace.ID,
+			CompanyName: workspace.CompanyName,
+			Slug:        workspace.Slug,
+		})
+	}
+}
+
+func changeWorkspaceName(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		if session.Role == users.RoleMember {
+			panic(errRoleNotAllowed.Msg("You are not allowed to rename the workspace"))
+		}
+
+		var dto WorkspaceNameDTO
+		api.ReadJSON(r, &dto)
+
+		var workspace *workspaces.Workspace
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			wRepo := workspaces.NewRepo(tx)
+
+			before, err := wRepo.Get(ctx, session.Workspace)
+			if err != nil {
+				return err
+			}
+
+			if workspace, err = wRepo.ChangeName(ctx, session.Workspace, dto.CompanyName); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionWorkspaceRenamed, audit.TargetWorkspace, workspace.ID)
+			e.Changes = audit.Diff(before, workspace)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, workspace)
+	}
+}
+
+func changeSlug(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		if session.Role == users.RoleMember {
+			panic(errRoleNotAllowed.Msg("You are not allowed to change the workspace URL"))
+		}
+
+		var dto SlugDTO
+		api.ReadJSON(r, &dto)
+
+		var workspace *workspaces.Workspace
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			wRepo := workspaces.NewRepo(tx)
+
+			before, err := wRepo.Get(ctx, session.Workspace)
+			if err != nil {
+				return err
+			}
+
+			if workspace, err = wRepo.ChangeSlug(ctx, session.Workspace, dto.Slug); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionSlugChanged, audit.TargetWorkspace, workspace.ID)
+			e.Changes = audit.Diff(before, workspace)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, workspace)
+	}
+}
+
+func deleteWorkspace(auth *keyring.SessionStore, db bun.IDB, gracePeriod time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		if session.Role != users.RoleOwner {
+			panic(errOwnerOnly.Msg("Only the owner can delete this workspace"))
+		}
+
+		var workspace *workspaces.Workspace
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			if workspace, err = workspaces.NewRepo(tx).Delete(ctx, session.Workspace, gracePeriod); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionWorkspaceDeleted, audit.TargetWorkspace, workspace.ID)
+			if err := audit.NewRepo(tx).Record(ctx, e); err != nil {
+				return err
+			}
+
+			return webhooks.NewRepo(tx).Enqueue(ctx, workspace.ID, webhooks.EventWorkspaceDeleted, workspace)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, workspace)
+	}
+}
+
+func restoreWorkspace(auth *keyring.SessionStore, db bun.IDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		if session.Role != users.RoleOwner {
+			panic(errOwnerOnly.Msg("Only the owner can restore this workspace"))
+		}
+
+		var workspace *workspaces.Workspace
+		err := tenant.RunInTx(r.Context(), db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			if workspace, err = workspaces.NewRepo(tx).Restore(ctx, session.Workspace); err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionWorkspaceRestored, audit.TargetWorkspace, workspace.ID)
+			return audit.NewRepo(tx).Record(ctx, e)
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, workspace)
+	}
+}
+
+func nominateOwner(auth *keyring.SessionStore, tStore tokens.Store, db bun.IDB, env *config.Env, mailer notification.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		if session.Role != users.RoleOwner {
+			panic(errOwnerOnly.Msg("Only the owner can transfer this workspace"))
+		}
+
+		var dto TransferDTO
+		api.ReadJSON(r, &dto)
+
+		var owner, nominee *users.User
+		scoped(r, db, session.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			uRepo := users.NewRepo(tx)
+
+			var err error
+			if owner, err = uRepo.Get(ctx, session.Workspace, session.User); err != nil {
+				return err
+			}
+
+			nominee, err = uRepo.Get(ctx, session.Workspace, dto.User)
+			return err
+		})
+
+		if nominee == nil || nominee.Role != users.RoleAdmin {
+			panic(errTransferTarget)
+		}
+
+		tToken, err := users.NewTransferToken(r.Context(), tStore, owner, nominee)
+		if err != nil {
+			panic(err)
+		}
+
+		err = users.SendTransferRequest(r.Context(), mailer, env.ClientTransferPage.String(), session.CompanyName, tToken, owner, nominee)
+		if err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, tToken)
+	}
+}
+
+func acceptOwnership(auth *keyring.SessionStore, tStore tokens.Store, db bun.IDB, mailer notification.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session session
+		loadSession(auth, r, &session)
+
+		token := api.StringParam(r, "token")
+
+		tToken, err := users.ViewTransferToken(r.Context(), tStore, token)
+		if err != nil {
+			if errors.Is(err, users.ErrTransferExpired) {
+				panic(errTransferExpired)
+			}
+			panic(err)
+		}
+
+		if tToken.Workspace != session.Workspace || tToken.Nominee != session.User {
+			panic(errTransferNotYours)
+		}
+
+		var workspace *workspaces.Workspace
+		var oldOwner, newOwner *users.User
+		err = tenant.RunInTx(r.Context(), db, tToken.Workspace, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			// the workspace may have been purged since the nomination
+			if workspace, err = workspaces.NewRepo(tx).Get(ctx, tToken.Workspace); err != nil || workspace == nil {
+				return err
+			}
+
+			oldOwner, newOwner, err = users.NewRepo(tx).TransferOwnership(ctx, tToken.Workspace, tToken.Owner, tToken.Nominee)
+			if err != nil {
+				return err
+			}
+
+			e := newEvent(r, session, audit.ActionOwnershipTransferred, audit.TargetWorkspace, tToken.Workspace)
+			e.Changes = map[string]audit.Change{
+				"owner": {Before: oldOwner.ID, After: newOwner.ID},
+			}
+			if err := audit.NewRepo(tx).Record(ctx, e); err != nil {
+				return err
+			}
+
+			whRepo := webhooks.NewRepo(tx)
+			if err := whRepo.Enqueue(ctx, tToken.Workspace, webhooks.EventUserRoleChanged, roleChange(oldOwner, users.RoleOwner)); err != nil {
+				return err
+			}
+
+			return whRepo.Enqueue(ctx, tToken.Workspace, webhooks.EventUserRoleChanged, roleChange(newOwner, users.RoleAdmin))
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if workspace == nil {
+			panic(errWorkspaceNotFound)
+		}
+
+		if err := users.RevokeTransferToken(r.Context(), tStore, tToken.Workspace); err != nil {
+			panic(err)
+		}
+
+		if err := users.SendTransferComplete(r.Context(), mailer, workspace.CompanyName, oldOwner, newOwner); err != nil {
+			panic(err)
+		}
+
+		api.Success(r, w, newOwner)
+	}
+}
@@ -0,0 +1,46 @@
+package secure
+
+import (
+	"net/http"
+
+	"github.com/rs/cors"
+)
+
+// exposedHeaders are the response headers browsers let clients read.
+var exposedHeaders = []string{
+	"Content-Disposition",
+	"Idempotent-Replayed",
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"RateLimit-Policy",
+	"Retry-After",
+}
+
+// CORS lets browsers call the API from the given origins, which can have a wildcard
+// like https://*.noxecane. Without any, dev lets in every origin and everywhere else
+// none. Sessions are sent in the Authorization header rather than cookies, so
+// credentials aren't allowed.
+func CORS(env string, origins []string) func(http.Handler) http.Handler {
+	if len(origins) == 0 {
+		if env != "dev" {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		origins = []string{"*"}
+	}
+
+	return cors.New(cors.Options{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{
+			http.MethodHead,
+			http.MethodGet,
+			http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+		},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: exposedHeaders,
+		MaxAge:         600,
+	}).Handler
+}
@@ -0,0 +1,107 @@
+package secure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ctxKey struct{}
+
+// Policy is the security headers responses are sent with.
+type Policy struct {
+	// HSTS is the max-age of Strict-Transport-Security, 0 leaves it out
+	HSTS time.Duration
+	// CSP is the Content-Security-Policy of HTML responses, nothing else renders
+	CSP            string
+	ReferrerPolicy string
+}
+
+// Headers sends responses with the policy's headers, along with
+// X-Content-Type-Options. They're set as the response is written, so routes can
+// change the policy with Override.
+func Headers(p Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := p
+			ctx := context.WithValue(r.Context(), ctxKey{}, &policy)
+
+			next.ServeHTTP(&headerWriter{ResponseWriter: w, policy: &policy}, r.WithContext(ctx))
+		})
+	}
+}
+
+// Override changes the policy for the routes it's used on, like a looser CSP for a
+// page that loads scripts.
+func Override(fn func(p *Policy)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if policy, ok := r.Context().Value(ctxKey{}).(*Policy); ok {
+				fn(policy)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// headerWriter sets the policy's headers just before the response is written, once
+// it's known whether it's HTML.
+type headerWriter struct {
+	http.ResponseWriter
+	policy  *Policy
+	written bool
+}
+
+func (hw *headerWriter) WriteHeader(code int) {
+	if !hw.written {
+		hw.written = true
+		hw.policy.apply(hw.Header())
+	}
+
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *headerWriter) Write(b []byte) (int, error) {
+	if !hw.written {
+		// net/http would sniff it anyway, do it first to know if it's HTML
+		if hw.Header().Get("Content-Type") == "" {
+			hw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		hw.WriteHeader(http.StatusOK)
+	}
+
+	return hw.ResponseWriter.Write(b)
+}
+
+func (hw *headerWriter) Flush() {
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		if !hw.written {
+			hw.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the writer underneath.
+func (hw *headerWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+func (p *Policy) apply(h http.Header) {
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	if p.ReferrerPolicy != "" {
+		h.Set("Referrer-Policy", p.ReferrerPolicy)
+	}
+
+	if p.HSTS > 0 {
+		h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int(p.HSTS.Seconds())))
+	}
+
+	if p.CSP != "" && strings.HasPrefix(h.Get("Content-Type"), "text/html") {
+		h.Set("Content-Security-Policy", p.CSP)
+	}
+}
@@ -0,0 +1,148 @@
+// Package secure sets up what browsers and proxies need to talk to the API safely:
+// CORS, the client's real IP behind trusted proxies and security headers.
+package secure
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	trueClientIP  = http.CanonicalHeaderKey("True-Client-IP")
+	xForwardedFor = http.CanonicalHeaderKey("X-Forwarded-For")
+	xRealIP       = http.CanonicalHeaderKey("X-Real-IP")
+)
+
+// Proxies are the networks of the proxies in front of the API.
+type Proxies []*net.IPNet
+
+// ParseProxies reads CIDRs like 10.0.0.0/8, a bare IP being a network of its own.
+func ParseProxies(cidrs []string) (Proxies, error) {
+	var proxies Proxies
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, expected an IP or CIDR", cidr)
+			}
+
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, expected an IP or CIDR", cidr)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// Decode lets the proxies be read straight from the environment, separated by commas.
+func (p *Proxies) Decode(value string) error {
+	proxies, err := ParseProxies(strings.Split(value, ","))
+	if err != nil {
+		return err
+	}
+
+	*p = proxies
+	return nil
+}
+
+func (p Proxies) String() string {
+	cidrs := make([]string, len(p))
+	for i, network := range p {
+		cidrs[i] = network.String()
+	}
+
+	return strings.Join(cidrs, ",")
+}
+
+func (p Proxies) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RealIP sets the request's remote address to the client's. Only requests from
+// trusted proxies get to say who the client is, through X-Forwarded-For or
+// X-Real-IP, the client being the last address in X-Forwarded-For that isn't a
+// trusted proxy. Everyone else's headers are ignored.
+//
+// The result is left in X-Real-IP too, so it has to run before webpack's RealIP,
+// which believes those headers from anyone.
+func RealIP(proxies Proxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := remoteIP(r.RemoteAddr)
+
+			if proxies.trusts(client) {
+				client = forwardedIP(r, proxies, client)
+			}
+
+			r.Header.Del(trueClientIP)
+			r.Header.Set(xRealIP, client)
+			r.RemoteAddr = client
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedIP walks X-Forwarded-For back from the proxy that sent the request,
+// stopping at the first address that isn't one of ours.
+func forwardedIP(r *http.Request, proxies Proxies, remote string) string {
+	var hops []string
+	for _, header := range r.Header.Values(xForwardedFor) {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); net.ParseIP(hop) != nil {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	if len(hops) == 0 {
+		if ip := strings.TrimSpace(r.Header.Get(xRealIP)); net.ParseIP(ip) != nil {
+			return ip
+		}
+		return remote
+	}
+
+	for i := len(hops) - 1; i > 0; i-- {
+		if !proxies.trusts(hops[i]) {
+			return hops[i]
+		}
+	}
+
+	return hops[0]
+}
+
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
@@ -0,0 +1,137 @@
+package secure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestRealIP(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		remote string
+		header http.Header
+		client string
+	}{
+		{"direct", "203.0.113.9:1234", nil, "203.0.113.9"},
+		{"spoofed", "203.0.113.9:1234", http.Header{"X-Forwarded-For": {"1.1.1.1"}, "True-Client-Ip": {"2.2.2.2"}}, "203.0.113.9"},
+		{"through a proxy", "10.0.0.2:1234", http.Header{"X-Forwarded-For": {"198.51.100.7"}}, "198.51.100.7"},
+		{"through proxies", "10.0.0.2:1234", http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.7, 192.168.1.1"}}, "198.51.100.7"},
+		{"only proxies", "10.0.0.2:1234", http.Header{"X-Forwarded-For": {"10.0.0.3", "10.0.0.4"}}, "10.0.0.3"},
+		{"real ip from a proxy", "192.168.1.1:1234", http.Header{"X-Real-Ip": {"198.51.100.7"}}, "198.51.100.7"},
+		{"untrusted real ip", "192.168.1.2:1234", http.Header{"X-Real-Ip": {"198.51.100.7"}}, "192.168.1.2"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			router := chi.NewRouter()
+			router.Use(RealIP(proxies))
+			// as webpack does after it
+			router.Use(middleware.RealIP)
+			router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remote
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tc.client {
+				t.Errorf("Expected the client to be %s, got %s", tc.client, got)
+			}
+		})
+	}
+
+	if _, err := ParseProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected invalid CIDRs to be rejected")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	preflight := func(h http.Handler, origin string) string {
+		req := httptest.NewRequest(http.MethodOptions, "/teams", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+
+		return res.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	prod := CORS("production", []string{"https://*.noxecane"})(ok)
+	if got := preflight(prod, "https://app.noxecane"); got != "https://app.noxecane" {
+		t.Errorf("Expected a matching origin to be allowed, got %q", got)
+	}
+
+	if got := preflight(prod, "https://evil.example"); got != "" {
+		t.Errorf("Expected other origins to be refused, got %q", got)
+	}
+
+	if got := preflight(CORS("production", nil)(ok), "https://app.noxecane"); got != "" {
+		t.Errorf("Expected no origins to be allowed outside dev by default, got %q", got)
+	}
+
+	if got := preflight(CORS("dev", nil)(ok), "http://localhost:8080"); got != "*" {
+		t.Errorf("Expected any origin to be allowed in dev, got %q", got)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(Headers(Policy{HSTS: time.Hour, CSP: "default-src 'none'", ReferrerPolicy: "no-referrer"}))
+	router.Get("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	})
+	router.Get("/html", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<!DOCTYPE html><html></html>"))
+	})
+	router.With(Override(func(p *Policy) { p.CSP = "script-src 'self'" })).Get("/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	get := func(path string) http.Header {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		return res.Header()
+	}
+
+	h := get("/json")
+	if h.Get("X-Content-Type-Options") != "nosniff" || h.Get("Referrer-Policy") != "no-referrer" || h.Get("Strict-Transport-Security") != "max-age=3600; includeSubDomains" {
+		t.Errorf("Expected the security headers, got %v", h)
+	}
+
+	if h.Get("Content-Security-Policy") != "" {
+		t.Errorf("Expected no CSP for JSON, got %v", h)
+	}
+
+	if h := get("/html"); h.Get("Content-Security-Policy") != "default-src 'none'" {
+		t.Errorf("Expected the CSP for HTML, got %v", h)
+	}
+
+	if h := get("/docs"); h.Get("Content-Security-Policy") != "script-src 'self'" {
+		t.Errorf("Expected the route's own CSP, got %v", h)
+	}
+
+	// overrides don't leak into other requests
+	if h := get("/html"); h.Get("Content-Security-Policy") != "default-src 'none'" {
+		t.Errorf("Expected the CSP for HTML, got %v", h)
+	}
+}
@@ -0,0 +1,264 @@
+package teams
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"noxecane/go-starter/pkg/pii"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/uptrace/bun"
+)
+
+const (
+	RoleMember = "member"
+	RoleLead   = "lead"
+)
+
+var ErrExistingTeam = errors.New("team name already in use")
+var ErrExistingMember = errors.New("user is already a member of the team")
+var ErrNotWorkspaceMember = errors.New("user is not a member of the workspace")
+
+type Team struct {
+	ID          uint      `bun:",pk" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Workspace   uint      `json:"workspace"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+}
+
+// Member is a user's place in a team, along with the user's details when listed.
+type Member struct {
+	bun.BaseModel `bun:"table:team_members"`
+
+	Team         uint      `bun:",pk" json:"team"`
+	User         uint      `bun:"user_id,pk" json:"user"`
+	CreatedAt    time.Time `json:"created_at"`
+	Role         string    `json:"role"`
+	FirstName    pii.Text  `bun:",scanonly" json:"first_name,omitempty"`
+	LastName     pii.Text  `bun:",scanonly" json:"last_name,omitempty"`
+	EmailAddress pii.Text  `bun:",scanonly" json:"email_address,omitempty"`
+}
+
+type Repo struct {
+	db bun.IDB
+}
+
+func NewRepo(db bun.IDB) *Repo {
+	return &Repo{db}
+}
+
+// Create a team in the workspace. Returns ErrExistingTeam if the workspace already has
+// a team with the same name.
+func (r *Repo) Create(ctx context.Context, wkID uint, name, description string) (*Team, error) {
+	team := &Team{Workspace: wkID, Name: name, Description: description}
+
+	_, err := r.db.
+		NewInsert().
+		Model(team).
+		Column("workspace", "name", "description").
+		Returning("*").
+		Exec(ctx)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return nil, ErrExistingTeam
+	}
+
+	return team, err
+}
+
+// Get returns the team with the given ID in the workspace. Returns nil if the team doesn't exist
+func (r *Repo) Get(ctx context.Context, wkID, id uint) (*Team, error) {
+	team := new(Team)
+	err := r.db.
+		NewSelect().
+		Model(team).
+		Where("id = ?", id).
+		Where("workspace = ?", wkID).
+		Scan(ctx)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return team, err
+}
+
+// List returns all the teams in the workspace ordered by name
+func (r *Repo) List(ctx context.Context, wkID uint) ([]Team, error) {
+	teams := []Team{}
+	err := r.db.
+		NewSelect().
+		Model(&teams).
+		Where("workspace = ?", wkID).
+		Order("name").
+		Scan(ctx)
+
+	return teams, err
+}
+
+// Update changes the name and description of a team. Returns nil if the team doesn't exist
+func (r *Repo) Update(ctx context.Context, wkID, id uint, name, description string) (*Team, error) {
+	team := &Team{ID: id, Name: name, Description: description}
+
+	res, err := r.db.
+		NewUpdate().
+		Model(team).
+		WherePK().
+		Where("workspace = ?", wkID).
+		Column("name", "description").
+		Returning("*").
+		Exec(ctx)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return nil, ErrExistingTeam
+	} else if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, nil
+	}
+
+	return team, nil
+}
+
+// Delete removes the team along with its memberships. Returns false if the team doesn't exist
+func (r *Repo) Delete(ctx context.Context, wkID, id uint) (bool, error) {
+	res, err := r.db.
+		NewDelete().
+		Model((*Team)(nil)).
+		Where("id = ?", id).
+		Where("workspace = ?", wkID).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// AddMembers puts the users in the team with the given role. Returns ErrNotWorkspaceMember
+// if any of the users doesn't belong to the team's workspace and ErrExistingMember if
+// any of them is already in the team.
+func (r *Repo) AddMembers(ctx context.Context, wkID, id uint, userIDs []uint, role string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	// only users in the team's workspace make it through the join
+	res, err := r.db.
+		NewRaw(`
+			INSERT INTO team_members (team, user_id, role)
+			SELECT t.id, m.user_id, ?
+			FROM teams AS t
+			JOIN memberships AS m ON m.workspace = t.workspace
+			WHERE t.id = ? AND t.workspace = ? AND m.user_id IN (?)`,
+			role, id, wkID, bun.In(userIDs),
+		).
+		Exec(ctx)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return ErrExistingMember
+	} else if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n != int64(len(userIDs)) {
+		return ErrNotWorkspaceMember
+	}
+
+	return nil
+}
+
+// ChangeRole sets the role of a user in the team. Returns nil if the user is not in the team
+func (r *Repo) ChangeRole(ctx context.Context, wkID, id, userID uint, role string) (*Member, error) {
+	member := &Member{Team: id, User: userID, Role: role}
+
+	res, err := r.db.
+		NewUpdate().
+		Model(member).
+		WherePK().
+		Where("EXISTS (SELECT 1 FROM teams WHERE id = ?TableAlias.team AND workspace = ?)", wkID).
+		Column("role").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, nil
+	}
+
+	return member, nil
+}
+
+// RemoveMember takes the user out of the team. Returns false if the user wasn't in the team
+func (r *Repo) RemoveMember(ctx context.Context, wkID, id, userID uint) (bool, error) {
+	res, err := r.db.
+		NewDelete().
+		Model((*Member)(nil)).
+		Where("team = ?", id).
+		Where("user_id = ?", userID).
+		Where("EXISTS (SELECT 1 FROM teams WHERE id = ?TableAlias.team AND workspace = ?)", wkID).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// Members returns everyone in the team with their details, leads first.
+func (r *Repo) Members(ctx context.Context, wkID, id uint) ([]Member, error) {
+	members := []Member{}
+	err := r.db.
+		NewSelect().
+		Model(&members).
+		ColumnExpr("?TableAlias.*").
+		ColumnExpr("u.first_name, u.last_name, u.email_address").
+		Join("JOIN users AS u ON u.id = ?TableAlias.user_id").
+		Join("JOIN teams AS t ON t.id = ?TableAlias.team").
+		Where("?TableAlias.team = ?", id).
+		Where("t.workspace = ?", wkID).
+		OrderExpr("?TableAlias.role = ? DESC, ?TableAlias.created_at", RoleLead).
+		Scan(ctx)
+
+	return members, err
+}
+
+// Member returns the user's membership of the team. Returns nil if the user is not in the team
+func (r *Repo) Member(ctx context.Context, wkID, id, userID uint) (*Member, error) {
+	member := new(Member)
+	err := r.db.
+		NewSelect().
+		Model(member).
+		Join("JOIN teams AS t ON t.id = ?TableAlias.team").
+		Where("?TableAlias.team = ?", id).
+		Where("?TableAlias.user_id = ?", userID).
+		Where("t.workspace = ?", wkID).
+		Scan(ctx)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return member, err
+}
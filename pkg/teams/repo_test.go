@@ -0,0 +1,161 @@
+package teams
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/pii"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/workspaces"
+
+	"github.com/jaswdr/faker"
+	"github.com/noxecane/anansi"
+	"github.com/uptrace/bun"
+)
+
+var testDB *bun.DB
+var fake = faker.New()
+
+func afterEach(t *testing.T) {
+	if _, err := testDB.NewTruncateTable().Table("workspaces", "users").Cascade().Exec(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	var err error
+	var sqlDB *sql.DB
+
+	var env config.Env
+	if err = anansi.LoadEnv(&env); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(env.Name)
+
+	if sqlDB, testDB, err = config.SetupDB(env); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to postgres")
+
+	if err = pii.Setup(context.TODO(), testDB, env.PIIKeys); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	if err := sqlDB.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from postgres cleanly")
+	}
+
+	os.Exit(code)
+}
+
+func TestRepoCreate(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	wkRepo := workspaces.NewRepo(testDB)
+	wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := fake.Company().JobTitle()
+	if _, err := repo.Create(ctx, wk.ID, name, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Create(ctx, wk.ID, name, ""); err != ErrExistingTeam {
+		t.Errorf("Expected duplicate create to fail with \"%v\", got %v", ErrExistingTeam, err)
+	}
+}
+
+func TestRepoAddMembers(t *testing.T) {
+	repo := NewRepo(testDB)
+	uRepo := users.NewRepo(testDB)
+	ctx := context.TODO()
+
+	wkRepo := workspaces.NewRepo(testDB)
+
+	t.Run("adds workspace members to the team", func(t *testing.T) {
+		defer afterEach(t)
+
+		wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		team, err := repo.Create(ctx, wk.ID, fake.Company().JobTitle(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ux, err := uRepo.CreateMany(ctx, wk.ID, []users.UserRequest{
+			{EmailAddress: fake.Internet().Email(), Role: users.RoleMember},
+			{EmailAddress: fake.Internet().Email(), Role: users.RoleMember},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.AddMembers(ctx, wk.ID, team.ID, []uint{ux[0].ID}, RoleMember); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.AddMembers(ctx, wk.ID, team.ID, []uint{ux[1].ID}, RoleLead); err != nil {
+			t.Fatal(err)
+		}
+
+		members, err := repo.Members(ctx, wk.ID, team.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(members) != 2 {
+			t.Fatalf("Expected team to have 2 members, got %d", len(members))
+		}
+
+		if members[0].User != ux[1].ID || members[0].Role != RoleLead {
+			t.Errorf("Expected team lead(%d) to be listed first, got %d(%s)", ux[1].ID, members[0].User, members[0].Role)
+		}
+
+		if err := repo.AddMembers(ctx, wk.ID, team.ID, []uint{ux[0].ID}, RoleMember); err != ErrExistingMember {
+			t.Errorf("Expected adding twice to fail with \"%v\", got %v", ErrExistingMember, err)
+		}
+	})
+
+	t.Run("rejects users from other workspaces", func(t *testing.T) {
+		defer afterEach(t)
+
+		wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wk2, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		team, err := repo.Create(ctx, wk.ID, fake.Company().JobTitle(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outsider, err := uRepo.Create(ctx, wk2.ID, users.UserRequest{EmailAddress: fake.Internet().Email(), Role: users.RoleAdmin})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = repo.AddMembers(ctx, wk.ID, team.ID, []uint{outsider.ID}, RoleMember)
+		if err != ErrNotWorkspaceMember {
+			t.Errorf("Expected add to fail with \"%v\", got %v", ErrNotWorkspaceMember, err)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package tenant
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/uptrace/bun"
+)
+
+// Role is the database role row level security policies apply to. Our own connection
+// owns the tables, which would otherwise skip the policies.
+const Role = "app_tenant"
+
+// Scope restricts the rest of the transaction to rows belonging to the workspace.
+// Anything that needs to see across workspaces, like looking up users by email, has
+// to happen before the transaction is scoped.
+func Scope(ctx context.Context, tx bun.Tx, wkID uint) error {
+	_, err := tx.ExecContext(ctx, "SELECT set_config('app.current_workspace', ?, true)", strconv.FormatUint(uint64(wkID), 10))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "SET LOCAL ROLE ?", bun.Ident(Role))
+
+	return err
+}
+
+// RunInTx runs fn in a transaction that can only read and write the workspace's rows.
+func RunInTx(ctx context.Context, db bun.IDB, wkID uint, fn func(ctx context.Context, tx bun.Tx) error) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := Scope(ctx, tx, wkID); err != nil {
+			return err
+		}
+
+		return fn(ctx, tx)
+	})
+}
@@ -0,0 +1,196 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/pii"
+	"noxecane/go-starter/pkg/teams"
+	"noxecane/go-starter/pkg/users"
+	"noxecane/go-starter/pkg/workspaces"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jaswdr/faker"
+	"github.com/noxecane/anansi"
+	"github.com/uptrace/bun"
+)
+
+var testDB *bun.DB
+var fake = faker.New()
+
+func afterEach(t *testing.T) {
+	if _, err := testDB.NewTruncateTable().Table("workspaces", "users").Cascade().Exec(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	var err error
+	var sqlDB *sql.DB
+
+	var env config.Env
+	if err = anansi.LoadEnv(&env); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(env.Name)
+
+	if sqlDB, testDB, err = config.SetupDB(env); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to postgres")
+
+	if err = pii.Setup(context.TODO(), testDB, env.PIIKeys); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	if err := sqlDB.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from postgres cleanly")
+	}
+
+	os.Exit(code)
+}
+
+func isRLSViolation(err error) bool {
+	pgErr, ok := err.(*pgconn.PgError)
+	return ok && pgErr.Code == pgerrcode.InsufficientPrivilege
+}
+
+func TestRunInTx(t *testing.T) {
+	defer afterEach(t)
+
+	ctx := context.TODO()
+	wkRepo := workspaces.NewRepo(testDB)
+	uRepo := users.NewRepo(testDB)
+	tRepo := teams.NewRepo(testDB)
+
+	wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outsider, err := uRepo.Create(ctx, other.ID, users.UserRequest{EmailAddress: fake.Internet().Email(), Role: users.RoleMember})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherTeam, err := tRepo.Create(ctx, other.ID, fake.Company().JobTitle(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("hides other workspaces' rows", func(t *testing.T) {
+		err := RunInTx(ctx, testDB, wk.ID, func(ctx context.Context, tx bun.Tx) error {
+			var wx []workspaces.Workspace
+			if err := tx.NewSelect().Model(&wx).Scan(ctx); err != nil {
+				return err
+			}
+
+			if len(wx) != 1 || wx[0].ID != wk.ID {
+				t.Errorf("Expected to only see workspace %d, got %v", wk.ID, wx)
+			}
+
+			// no workspace filter, the policy has to do it
+			var ux []users.User
+			if err := tx.NewSelect().Model(&ux).Where("id = ?", outsider.ID).Scan(ctx); err != nil {
+				return err
+			}
+
+			if len(ux) != 0 {
+				t.Errorf("Expected user %d from another workspace to be hidden", outsider.ID)
+			}
+
+			tl, err := teams.NewRepo(tx).List(ctx, other.ID)
+			if err != nil {
+				return err
+			}
+
+			if len(tl) != 0 {
+				t.Errorf("Expected teams from another workspace to be hidden, got %d", len(tl))
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ignores updates to other workspaces' rows", func(t *testing.T) {
+		err := RunInTx(ctx, testDB, wk.ID, func(ctx context.Context, tx bun.Tx) error {
+			res, err := tx.
+				NewUpdate().
+				TableExpr("teams").
+				Set("name = ?", "hijacked").
+				Where("id = ?", otherTeam.ID).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+
+			if n, _ := res.RowsAffected(); n != 0 {
+				t.Errorf("Expected no teams to be updated, got %d", n)
+			}
+
+			// registration goes by email, it mustn't reach other workspaces' placeholders
+			registered, err := users.NewRepo(tx).Register(ctx, wk.ID, string(outsider.EmailAddress), users.Registration{
+				FirstName:   fake.Person().FirstName(),
+				LastName:    fake.Person().LastName(),
+				PhoneNumber: fake.Phone().E164Number(),
+				Password:    fake.Internet().Password(),
+			})
+			if err != nil {
+				return err
+			}
+
+			if registered.ID != 0 {
+				t.Errorf("Expected user %d from another workspace not to be registered", outsider.ID)
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("rejects inserts into other workspaces", func(t *testing.T) {
+		err := RunInTx(ctx, testDB, wk.ID, func(ctx context.Context, tx bun.Tx) error {
+			_, err := teams.NewRepo(tx).Create(ctx, other.ID, fake.Company().JobTitle(), "")
+			return err
+		})
+
+		if !isRLSViolation(err) {
+			t.Errorf("Expected insert to be rejected by row level security, got %v", err)
+		}
+	})
+
+	t.Run("hides everything without a workspace", func(t *testing.T) {
+		err := RunInTx(ctx, testDB, 0, func(ctx context.Context, tx bun.Tx) error {
+			n, err := tx.NewSelect().Model((*workspaces.Workspace)(nil)).Count(ctx)
+			if err != nil {
+				return err
+			}
+
+			if n != 0 {
+				t.Errorf("Expected no workspaces to be visible, got %d", n)
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/noxecane/anansi/api"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HTTP starts a span for every request, carrying on the trace from a traceparent
+// header if there's one, and adds the trace ID to the request's logger. Use it after
+// the logger is attached, the span is named after the route once it's known.
+func HTTP(next http.Handler) http.Handler {
+	tracer := otel.Tracer("noxecane/go-starter/pkg/tracing")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+
+		if sc := span.SpanContext(); sc.IsValid() {
+			zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("trace_id", sc.TraceID().String()).Str("span_id", sc.SpanID().String())
+			})
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			code := ww.Status()
+
+			// handlers panic with their errors, leaving the response to the recoverer
+			if rvr := recover(); rvr != nil {
+				code = http.StatusInternalServerError
+				if e, ok := rvr.(api.Err); ok {
+					code = e.Code
+				}
+
+				if code >= http.StatusInternalServerError {
+					span.RecordError(fmt.Errorf("%v", rvr))
+				}
+
+				defer panic(rvr)
+			}
+
+			if code == 0 {
+				code = http.StatusOK
+			}
+
+			if route := chi.RouteContext(r.Context()); route != nil && route.RoutePattern() != "" {
+				span.SetName(r.Method + " " + route.RoutePattern())
+				span.SetAttributes(semconv.HTTPRoute(route.RoutePattern()))
+			}
+
+			span.SetAttributes(semconv.HTTPStatusCode(code))
+			if code >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(code))
+			}
+
+			span.End()
+		}()
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/noxecane/anansi/api"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	return recorder
+}
+
+func newRouter(log *bytes.Buffer) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l := zerolog.New(log)
+			next.ServeHTTP(w, r.WithContext(l.WithContext(r.Context())))
+		})
+	})
+	router.Use(api.Recoverer("test"))
+	router.Use(HTTP)
+
+	router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		zerolog.Ctx(r.Context()).Info().Msg("found user")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Get("/missing", func(http.ResponseWriter, *http.Request) {
+		panic(api.Err{Code: http.StatusNotFound, Message: "not here"})
+	})
+	router.Get("/broken", func(http.ResponseWriter, *http.Request) {
+		panic(errors.New("boom"))
+	})
+
+	return router
+}
+
+func TestHTTP(t *testing.T) {
+	if _, err := Setup(context.TODO(), Opts{Exporter: ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	recorder := record(t)
+
+	var log bytes.Buffer
+	router := newRouter(&log)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected a span for the request, got %d", len(spans))
+	}
+
+	span := spans[0]
+	if span.Name() != "GET /users/{id}" {
+		t.Errorf("Expected span to be named after the route, got %q", span.Name())
+	}
+
+	if span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the trace to carry on from the traceparent header, got %s", span.SpanContext().TraceID())
+	}
+
+	if span.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected span to be a child of the caller's, got %s", span.Parent().SpanID())
+	}
+
+	if !strings.Contains(log.String(), `"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`) {
+		t.Errorf("Expected request logs to have the trace ID, got %s", log.String())
+	}
+}
+
+func TestHTTPPanics(t *testing.T) {
+	recorder := record(t)
+	router := newRouter(&bytes.Buffer{})
+
+	for _, path := range []string{"/missing", "/broken"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected a span for each request, got %d", len(spans))
+	}
+
+	if status := spans[0].Status().Code; status != codes.Unset {
+		t.Errorf("Expected client errors to leave the span alone, got %v", status)
+	}
+
+	if status := spans[1].Status().Code; status != codes.Error {
+		t.Errorf("Expected server errors to fail the span, got %v", status)
+	}
+}
+
+func TestSetupStdout(t *testing.T) {
+	var out bytes.Buffer
+
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	flush, err := Setup(context.TODO(), Opts{Service: "go-starter", Exporter: ExporterStdout, SampleRatio: 1, Output: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.TODO(), "test")
+	span.End()
+
+	if err := flush(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), `"Name":"test"`) || !strings.Contains(out.String(), "go-starter") {
+		t.Errorf("Expected span to be written out, got %s", out.String())
+	}
+
+	if _, err := Setup(context.TODO(), Opts{Exporter: "jaeger"}); err == nil {
+		t.Error("Expected unknown exporters to be refused")
+	}
+}
@@ -0,0 +1,56 @@
+package users
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"noxecane/go-starter/pkg/audit"
+
+	"github.com/rs/zerolog"
+	"github.com/uptrace/bun"
+)
+
+// CleanupPlaceholders creates a job that removes invited users who still haven't
+// accepted after age has passed since their last invitation.
+func CleanupPlaceholders(db bun.IDB, age time.Duration, log zerolog.Logger) func(context.Context) error {
+	return func(ctx context.Context) error {
+		var expired []Membership
+
+		err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			var err error
+			if expired, err = NewRepo(tx).ExpireInvitations(ctx, time.Now().Add(-age)); err != nil {
+				return err
+			}
+
+			aRepo := audit.NewRepo(tx)
+			for _, m := range expired {
+				e := &audit.Event{
+					Workspace:  m.Workspace,
+					Action:     audit.ActionInvitationExpired,
+					TargetType: audit.TargetUser,
+					TargetID:   strconv.FormatUint(uint64(m.User), 10),
+					Changes:    audit.Diff(m, nil),
+				}
+
+				if err := aRepo.Record(ctx, e); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, m := range expired {
+			log.Info().
+				Uint("workspace", m.Workspace).
+				Uint("user", m.User).
+				Msg("removed user who never accepted their invitation")
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,94 @@
+package users
+
+import (
+	"context"
+
+	"noxecane/go-starter/pkg/pii"
+
+	"github.com/uptrace/bun"
+)
+
+// encryptedColumns are the columns of users kept encrypted, indexColumns their blind
+// indexes.
+var (
+	encryptedColumns = []string{"first_name", "last_name", "email_address", "phone_number"}
+	indexColumns     = []string{"email_index", "phone_index"}
+)
+
+// Reencrypt encrypts users with the active data key and fills in their blind indexes,
+// batch users at a time, returning how many it changed. Users already encrypted with
+// the active key are left alone, so it can be stopped and run again. With
+// plaintextOnly, only users from before encryption are touched.
+func Reencrypt(ctx context.Context, db bun.IDB, batch int, plaintextOnly bool) (int, error) {
+	c, err := pii.Current()
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	var last uint
+	for {
+		var ux []User
+
+		// the batch is locked until it's written back, so nothing changed in between
+		// gets overwritten
+		err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			err := tx.
+				NewSelect().
+				Model(&ux).
+				Where("id > ?", last).
+				WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+					q = q.Where("email_index IS NULL")
+					if plaintextOnly {
+						return q
+					}
+
+					for _, col := range encryptedColumns {
+						q = q.WhereOr("(? <> '' AND ? NOT LIKE ?)", bun.Ident(col), bun.Ident(col), c.ActivePrefix()+"%")
+					}
+					return q
+				}).
+				Order("id").
+				Limit(batch).
+				For("UPDATE").
+				Scan(ctx)
+			if err != nil {
+				return err
+			}
+
+			for i := range ux {
+				_, err := tx.
+					NewUpdate().
+					Model(&ux[i]).
+					Column(encryptedColumns...).
+					Column(indexColumns...).
+					WherePK().
+					Exec(ctx)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return changed, err
+		}
+
+		if len(ux) == 0 {
+			return changed, nil
+		}
+
+		changed += len(ux)
+		last = ux[len(ux)-1].ID
+	}
+}
+
+// HasPlaintext reports whether any users are still waiting to be encrypted.
+func HasPlaintext(ctx context.Context, db bun.IDB) (bool, error) {
+	return db.
+		NewSelect().
+		Model((*User)(nil)).
+		Where("email_index IS NULL").
+		Exists(ctx)
+}
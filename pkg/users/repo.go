@@ -6,6 +6,9 @@ import (
 	"errors"
 	"time"
 
+	"noxecane/go-starter/pkg/paging"
+	"noxecane/go-starter/pkg/pii"
+
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/uptrace/bun"
@@ -20,6 +23,9 @@ const (
 
 var ErrExistingPhoneNumber = errors.New("tphone number already in use")
 var ErrExistingEmail = errors.New("email already in use")
+var ErrNotOwner = errors.New("user is not the owner of the workspace")
+var ErrNotAdmin = errors.New("user is not an admin of the workspace")
+var ErrOwnerRole = errors.New("the owner's role can only change through an ownership transfer")
 
 type Registration struct {
 	FirstName   string `json:"first_name"`
@@ -28,16 +34,67 @@ type Registration struct {
 	PhoneNumber string `json:"phone_number"`
 }
 
+// User is someone with an account, or a placeholder for someone invited who hasn't
+// accepted yet. Their names, email and phone number are encrypted in the database,
+// the email and phone number being looked up by their blind indexes.
 type User struct {
 	ID           uint      `bun:",pk" json:"id"`
 	CreatedAt    time.Time `json:"created_at"`
-	FirstName    string    `json:"first_name,omitempty"`
-	LastName     string    `json:"last_name,omitempty"`
-	Role         string    `json:"role"`
+	FirstName    pii.Text  `json:"first_name,omitempty"`
+	LastName     pii.Text  `json:"last_name,omitempty"`
+	Role         string    `bun:",scanonly" json:"role"`
 	Password     []byte    `json:"-"`
-	EmailAddress string    `json:"email_address"`
-	PhoneNumber  string    `json:"phone_number,omitempty"`
-	Workspace    uint      `json:"workspace"`
+	EmailAddress pii.Text  `json:"email_address"`
+	EmailIndex   []byte    `json:"-"`
+	PhoneNumber  pii.Text  `json:"phone_number,omitempty"`
+	PhoneIndex   []byte    `json:"-"`
+	Workspace    uint      `bun:",scanonly" json:"workspace"`
+}
+
+// Ref is all that's kept of a user in audit events and webhook payloads, which
+// aren't encrypted.
+type Ref struct {
+	ID        uint   `json:"id"`
+	Workspace uint   `json:"workspace"`
+	Role      string `json:"role"`
+}
+
+func (u *User) Ref() Ref {
+	return Ref{ID: u.ID, Workspace: u.Workspace, Role: u.Role}
+}
+
+var _ bun.BeforeAppendModelHook = (*User)(nil)
+
+// BeforeAppendModel keeps the blind indexes in step with the columns they index.
+func (u *User) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery, *bun.UpdateQuery:
+	default:
+		return nil
+	}
+
+	c, err := pii.Current()
+	if err != nil {
+		return err
+	}
+
+	u.EmailIndex = c.Index(string(u.EmailAddress))
+	u.PhoneIndex = c.Index(string(u.PhoneNumber))
+
+	return nil
+}
+
+// Membership is a user's place in a workspace. The same user can be a member of
+// many workspaces, with a different role in each.
+type Membership struct {
+	User        uint      `bun:"user_id,pk" json:"user"`
+	Workspace   uint      `bun:",pk" json:"workspace"`
+	CreatedAt   time.Time `json:"created_at"`
+	Role        string    `json:"role"`
+	CompanyName string    `bun:",scanonly" json:"company_name,omitempty"`
+	// InvitedAt is when a user without an account was last invited, it's cleared once
+	// they accept.
+	InvitedAt *time.Time `json:"invited_at,omitempty"`
 }
 
 type UserRequest struct {
@@ -54,73 +111,221 @@ func NewRepo(db bun.IDB) *Repo {
 }
 
 func (r *Repo) Create(ctx context.Context, workspace uint, req UserRequest) (*User, error) {
-	user := &User{
-		EmailAddress: req.EmailAddress,
-		Role:         req.Role,
-		Workspace:    workspace,
-	}
-
-	_, err := r.db.
-		NewInsert().
-		Model(user).
-		Column("email_address", "role", "workspace").
-		Returning("*").
-		Exec(ctx)
-
-	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
-		return nil, ErrExistingEmail
+	users, err := r.CreateMany(ctx, workspace, []UserRequest{req})
+	if err != nil {
+		return nil, err
 	}
 
-	return user, err
+	return &users[0], nil
 }
 
+// CreateMany adds users to the workspace, creating placeholders for emails we haven't
+// seen before and attaching users that already exist. Placeholders already invited to
+// the workspace are invited again with the new role. Returns ErrExistingEmail if any of
+// the users is already a member of the workspace.
 func (r *Repo) CreateMany(ctx context.Context, workspace uint, reqs []UserRequest) ([]User, error) {
 	var users []User
 
+	emails := make(map[string]bool)
 	for _, req := range reqs {
-		users = append(users, User{
-			EmailAddress: req.EmailAddress,
-			Role:         req.Role,
-			Workspace:    workspace,
-		})
+		if emails[req.EmailAddress] {
+			return nil, ErrExistingEmail
+		}
+		emails[req.EmailAddress] = true
+
+		users = append(users, User{EmailAddress: pii.Text(req.EmailAddress)})
 	}
 
-	_, err := r.db.
-		NewInsert().
-		Model(&users).
-		Column("email_address", "role", "workspace").
-		Returning("*").
-		Exec(ctx)
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// existing users keep their profile, we only need them returned
+		_, err := tx.
+			NewInsert().
+			Model(&users).
+			Column("email_address", "email_index").
+			On("CONFLICT (email_index) DO UPDATE").
+			Set("email_index = EXCLUDED.email_index").
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		var members []Membership
+		for i := range users {
+			users[i].Role = reqs[i].Role
+			users[i].Workspace = workspace
+
+			m := Membership{
+				User:      users[i].ID,
+				Workspace: workspace,
+				Role:      reqs[i].Role,
+			}
 
-	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
+			// users with accounts are members straight away
+			if len(users[i].Password) == 0 {
+				m.InvitedAt = &now
+			}
+
+			members = append(members, m)
+		}
+
+		// only pending invitations can be sent again, actual members are left alone
+		res, err := tx.
+			NewInsert().
+			Model(&members).
+			Column("user_id", "workspace", "role", "invited_at").
+			On("CONFLICT (user_id, workspace) DO UPDATE").
+			Set("role = EXCLUDED.role").
+			Set("invited_at = EXCLUDED.invited_at").
+			Where("?TableAlias.invited_at IS NOT NULL AND EXCLUDED.invited_at IS NOT NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n != int64(len(members)) {
+			return ErrExistingEmail
+		}
+
+		return nil
+	})
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
 		return nil, ErrExistingEmail
 	}
 
 	return users, err
 }
 
-func (r *Repo) Register(ctx context.Context, email string, reg Registration) (*User, error) {
+// Get returns the user with the given ID in the workspace. Returns nil if the user
+// doesn't exist or isn't a member of the workspace
+func (r *Repo) Get(ctx context.Context, wkID, id uint) (*User, error) {
+	user := new(User)
+	err := r.db.
+		NewSelect().
+		Model(user).
+		ColumnExpr("?TableAlias.*").
+		ColumnExpr("m.role, m.workspace").
+		Join("JOIN memberships AS m ON m.user_id = ?TableAlias.id").
+		Where("?TableAlias.id = ?", id).
+		Where("m.workspace = ?", wkID).
+		Scan(ctx)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return user, err
+}
+
+// ListSpec is what listing a workspace's users can be sorted and filtered by. Names
+// and emails are encrypted so they can't be sorted on, emails are filtered by their
+// blind index.
+var ListSpec = paging.Spec{
+	Fields: map[string]paging.Field{
+		"id":            {Column: "?TableAlias.id", Type: paging.Int, Sort: true, Filter: true},
+		"created_at":    {Column: "?TableAlias.created_at", Type: paging.Time, Sort: true, Filter: true},
+		"email_address": {Column: "?TableAlias.email_index", Filter: true, Value: indexValue},
+		"role":          {Column: "m.role", Filter: true},
+	},
+	Key:          "id",
+	DefaultSort:  "id",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
+func indexValue(raw string) (interface{}, error) {
+	return pii.Index(raw)
+}
+
+// List returns a page of the workspace's users, fetched as q asks.
+func (r *Repo) List(ctx context.Context, wkID uint, q *paging.Query) ([]User, error) {
+	users := []User{}
+	err := q.Apply(
+		r.db.
+			NewSelect().
+			Model(&users).
+			ColumnExpr("?TableAlias.*").
+			ColumnExpr("m.role, m.workspace").
+			Join("JOIN memberships AS m ON m.user_id = ?TableAlias.id").
+			Where("m.workspace = ?", wkID),
+	).Scan(ctx)
+
+	return users, err
+}
+
+// Memberships returns every workspace the user belongs to that hasn't been deleted,
+// oldest first.
+func (r *Repo) Memberships(ctx context.Context, id uint) ([]Membership, error) {
+	var members []Membership
+	err := r.db.
+		NewSelect().
+		Model(&members).
+		ColumnExpr("?TableAlias.*").
+		ColumnExpr("w.company_name").
+		Join("JOIN workspaces AS w ON w.id = ?TableAlias.workspace").
+		Where("?TableAlias.user_id = ?", id).
+		Where("w.deleted_at IS NULL").
+		Order("created_at").
+		Scan(ctx)
+
+	return members, err
+}
+
+// Register sets up the account of the placeholder invited to the workspace with email.
+// It returns a user without an ID if there's no such placeholder, like when they've
+// registered already. Every invitation they have stops being pending, so it has to
+// run before the transaction is scoped to the workspace.
+func (r *Repo) Register(ctx context.Context, wkID uint, email string, reg Registration) (*User, error) {
 	pwdBytes, err := bcrypt.GenerateFromPassword([]byte(reg.Password), 10)
 	if err != nil {
 		return nil, err
 	}
 
+	emailIndex, err := pii.Index(email)
+	if err != nil {
+		return nil, err
+	}
+
 	user := &User{
 		Password:    pwdBytes,
-		FirstName:   reg.FirstName,
-		LastName:    reg.LastName,
-		PhoneNumber: reg.PhoneNumber,
+		FirstName:   pii.Text(reg.FirstName),
+		LastName:    pii.Text(reg.LastName),
+		PhoneNumber: pii.Text(reg.PhoneNumber),
 	}
 
+	// the user is no longer a placeholder, so none of their invitations are pending
+	// anymore. It's cleared by the same statement, which sees the user as they were
+	// before it.
+	cleared := r.db.
+		NewUpdate().
+		Model((*Membership)(nil)).
+		Set("invited_at = NULL").
+		Where(`user_id IN (
+			SELECT u.id FROM users AS u
+			WHERE u.email_index = ? AND u.password IS NULL
+			AND EXISTS (SELECT 1 FROM memberships AS m WHERE m.user_id = u.id AND m.workspace = ?)
+		)`, emailIndex, wkID)
+
+	// registered users can't have their profile taken over by an invitation
 	_, err = r.db.
 		NewUpdate().
+		With("cleared", cleared).
 		Model(user).
-		Where("email_address = ?", email).
-		Column("first_name", "last_name", "phone_number", "password").
+		Where("email_index = ?", emailIndex).
+		Where("password IS NULL").
+		Where("EXISTS (SELECT 1 FROM memberships AS m WHERE m.user_id = ?TableAlias.id AND m.workspace = ?)", wkID).
+		Column("first_name", "last_name", "phone_number", "phone_index", "password").
 		Returning("*").
 		Exec(ctx)
 
-	if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
 		return nil, ErrExistingPhoneNumber
 	}
 
@@ -138,7 +343,7 @@ func (r *Repo) ChangePassword(ctx context.Context, wkID, id uint, password strin
 		NewUpdate().
 		Model(user).
 		Where("id = ?", id).
-		Where("workspace = ?", wkID).
+		Where("EXISTS (SELECT 1 FROM memberships WHERE user_id = ?TableAlias.id AND workspace = ?)", wkID).
 		Column("password").
 		Returning("*").
 		Exec(ctx)
@@ -149,3 +354,231 @@ func (r *Repo) ChangePassword(ctx context.Context, wkID, id uint, password strin
 
 	return user, err
 }
+
+// ResetPassword sets a new password for the user with the email, whichever workspace
+// they're in. Returns nil if there's no such user or they haven't accepted their
+// invitation yet.
+func (r *Repo) ResetPassword(ctx context.Context, email, password string) (*User, error) {
+	pwdBytes, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return nil, err
+	}
+
+	emailIndex, err := pii.Index(email)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{Password: pwdBytes}
+	res, err := r.db.
+		NewUpdate().
+		Model(user).
+		Where("email_index = ?", emailIndex).
+		Where("password IS NOT NULL").
+		Column("password").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+// TransferOwnership makes the nominee the owner of the workspace and demotes the
+// current owner to an admin. Both roles are checked and swapped in one transaction,
+// returning ErrNotOwner or ErrNotAdmin if either user no longer holds the expected role.
+func (r *Repo) TransferOwnership(ctx context.Context, wkID, owner, nominee uint) (*User, *User, error) {
+	var oldOwner, newOwner *User
+
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var members []Membership
+		err := tx.
+			NewSelect().
+			Model(&members).
+			Where("user_id IN (?)", bun.In([]uint{owner, nominee})).
+			Where("workspace = ?", wkID).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		var ownerM, nomineeM *Membership
+		for i := range members {
+			switch members[i].User {
+			case owner:
+				ownerM = &members[i]
+			case nominee:
+				nomineeM = &members[i]
+			}
+		}
+
+		if ownerM == nil || ownerM.Role != RoleOwner {
+			return ErrNotOwner
+		}
+
+		if nomineeM == nil || nomineeM.Role != RoleAdmin {
+			return ErrNotAdmin
+		}
+
+		ownerM.Role = RoleAdmin
+		nomineeM.Role = RoleOwner
+
+		for _, m := range []*Membership{ownerM, nomineeM} {
+			if _, err := tx.NewUpdate().Model(m).WherePK().Column("role").Exec(ctx); err != nil {
+				return err
+			}
+		}
+
+		txRepo := NewRepo(tx)
+		if oldOwner, err = txRepo.Get(ctx, wkID, owner); err != nil {
+			return err
+		}
+
+		newOwner, err = txRepo.Get(ctx, wkID, nominee)
+
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return oldOwner, newOwner, nil
+}
+
+// ChangeRole switches a member between admin and member. Returns nil if the user isn't
+// a member of the workspace, and ErrOwnerRole if the user is the owner.
+func (r *Repo) ChangeRole(ctx context.Context, wkID, id uint, role string) (*User, error) {
+	var user *User
+
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		m := new(Membership)
+		err := tx.
+			NewSelect().
+			Model(m).
+			Where("user_id = ?", id).
+			Where("workspace = ?", wkID).
+			For("UPDATE").
+			Scan(ctx)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if m.Role == RoleOwner {
+			return ErrOwnerRole
+		}
+
+		m.Role = role
+		if _, err := tx.NewUpdate().Model(m).WherePK().Column("role").Exec(ctx); err != nil {
+			return err
+		}
+
+		user, err = NewRepo(tx).Get(ctx, wkID, id)
+
+		return err
+	})
+
+	return user, err
+}
+
+// RemoveMember takes the user out of the workspace and all its teams, returning the
+// user as they were before leaving. Returns nil if the user isn't a member of the
+// workspace and ErrOwnerRole if the user is the owner.
+func (r *Repo) RemoveMember(ctx context.Context, wkID, id uint) (*User, error) {
+	var user *User
+
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		if user, err = NewRepo(tx).Get(ctx, wkID, id); err != nil || user == nil {
+			return err
+		}
+
+		if user.Role == RoleOwner {
+			return ErrOwnerRole
+		}
+
+		_, err = tx.
+			NewDelete().
+			TableExpr("team_members").
+			Where("user_id = ?", id).
+			Where("team IN (SELECT id FROM teams WHERE workspace = ?)", wkID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.
+			NewDelete().
+			Model((*Membership)(nil)).
+			Where("user_id = ?", id).
+			Where("workspace = ?", wkID).
+			Exec(ctx)
+
+		return err
+	})
+
+	return user, err
+}
+
+// ExpireInvitations removes placeholders from the workspaces they were invited to
+// before the given time, returning the memberships removed. Placeholders left without a
+// workspace are deleted.
+func (r *Repo) ExpireInvitations(ctx context.Context, before time.Time) ([]Membership, error) {
+	var expired []Membership
+
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.
+			NewRaw(`
+				DELETE FROM memberships AS m
+				USING users AS u
+				WHERE u.id = m.user_id AND u.password IS NULL AND m.invited_at <= ?
+				RETURNING m.*`,
+				before,
+			).
+			Scan(ctx, &expired)
+		if err != nil {
+			return err
+		}
+
+		if len(expired) == 0 {
+			return nil
+		}
+
+		var ids []uint
+		for _, m := range expired {
+			ids = append(ids, m.User)
+
+			_, err := tx.
+				NewDelete().
+				TableExpr("team_members").
+				Where("user_id = ?", m.User).
+				Where("team IN (SELECT id FROM teams WHERE workspace = ?)", m.Workspace).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		// placeholders still invited elsewhere stay behind
+		_, err = tx.
+			NewDelete().
+			TableExpr("users").
+			Where("id IN (?)", bun.In(ids)).
+			Where("password IS NULL").
+			Where("NOT EXISTS (SELECT 1 FROM memberships WHERE user_id = users.id)").
+			Exec(ctx)
+
+		return err
+	})
+
+	return expired, err
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/pii"
 
 	"noxecane/go-starter/pkg/workspaces"
 
@@ -19,7 +22,7 @@ var testDB *bun.DB
 var fake = faker.New()
 
 func afterEach(t *testing.T) {
-	if _, err := testDB.NewTruncateTable().Table("workspaces", "users").Cascade().Exec(context.TODO()); err != nil {
+	if _, err := testDB.NewTruncateTable().Table("workspaces", "users", "workspace_purges").Cascade().Exec(context.TODO()); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -40,6 +43,10 @@ func TestMain(m *testing.M) {
 	}
 	log.Info().Msg("Successfully connected to postgres")
 
+	if err = pii.Setup(context.TODO(), testDB, env.PIIKeys); err != nil {
+		panic(err)
+	}
+
 	code := m.Run()
 
 	if err := sqlDB.Close(); err != nil {
@@ -62,11 +69,31 @@ func TestRepoCreate(t *testing.T) {
 	}
 
 	req := UserRequest{fake.Internet().Email(), fake.Company().JobTitle()}
-	_, err = repo.Create(ctx, wk.ID, req)
+	user, err := repo.Create(ctx, wk.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// placeholders can be invited again
+	again, err := repo.Create(ctx, wk.ID, UserRequest{req.EmailAddress, RoleAdmin})
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	if again.ID != user.ID || again.Role != RoleAdmin {
+		t.Errorf("Expected placeholder(%d) to be invited again as admin, got user(%d) as %s", user.ID, again.ID, again.Role)
+	}
+
+	reg := Registration{
+		fake.Person().FirstName(),
+		fake.Person().LastName(),
+		fake.Lorem().Word(),
+		fake.Phone().Number(),
+	}
+	if _, err := repo.Register(ctx, wk.ID, req.EmailAddress, reg); err != nil {
+		t.Fatal(err)
+	}
+
 	_, err = repo.Create(ctx, wk.ID, req)
 	if err == nil {
 		t.Fatalf("Expected duplicate create to fail")
@@ -95,6 +122,16 @@ func TestRepoCreateMany(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	reg := Registration{
+		fake.Person().FirstName(),
+		fake.Person().LastName(),
+		fake.Lorem().Word(),
+		fake.Phone().Number(),
+	}
+	if _, err := repo.Register(ctx, wk.ID, req.EmailAddress, reg); err != nil {
+		t.Fatal(err)
+	}
+
 	reqs := []UserRequest{
 		{fake.Internet().Email(), fake.Company().JobTitle()},
 		req,
@@ -109,6 +146,59 @@ func TestRepoCreateMany(t *testing.T) {
 	}
 }
 
+func TestRepoCreateManyExisting(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	wkRepo := workspaces.NewRepo(testDB)
+	wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wk2, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.Create(ctx, wk.ID, UserRequest{fake.Internet().Email(), RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := []UserRequest{
+		{fake.Internet().Email(), RoleMember},
+		{string(user.EmailAddress), RoleMember},
+	}
+	ux, err := repo.CreateMany(ctx, wk2.ID, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ux[1].ID != user.ID {
+		t.Errorf("Expected existing user(%d) to be attached, got user(%d)", user.ID, ux[1].ID)
+	}
+
+	members, err := repo.Memberships(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(members) != 2 {
+		t.Fatalf("Expected user to belong to 2 workspaces, got %d", len(members))
+	}
+
+	if members[0].Role != RoleAdmin || members[1].Role != RoleMember {
+		t.Errorf("Expected roles to be per workspace, got %s and %s", members[0].Role, members[1].Role)
+	}
+
+	if members[1].CompanyName != wk2.CompanyName {
+		t.Errorf("Expected membership to include company name %s, got %s", wk2.CompanyName, members[1].CompanyName)
+	}
+}
+
 func TestRepoRegister(t *testing.T) {
 	defer afterEach(t)
 
@@ -130,6 +220,17 @@ func TestRepoRegister(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// the first user is invited elsewhere too, along with someone only invited there
+	wk2, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outsider := UserRequest{fake.Internet().Email(), RoleMember}
+	if _, err := repo.CreateMany(ctx, wk2.ID, []UserRequest{reqs[0], outsider}); err != nil {
+		t.Fatal(err)
+	}
+
 	reg := Registration{
 		fake.Person().FirstName(),
 		fake.Person().LastName(),
@@ -137,7 +238,11 @@ func TestRepoRegister(t *testing.T) {
 		fake.Phone().Number(),
 	}
 
-	_, err = repo.Register(ctx, reqs[0].EmailAddress, reg)
+	if registered, err := repo.Register(ctx, wk.ID, outsider.EmailAddress, reg); err != nil || registered.ID != 0 {
+		t.Errorf("Expected a user not invited to the workspace not to be registered, got %v %v", registered, err)
+	}
+
+	_, err = repo.Register(ctx, wk.ID, reqs[0].EmailAddress, reg)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -148,8 +253,324 @@ func TestRepoRegister(t *testing.T) {
 		fake.Lorem().Word(),
 		reg.PhoneNumber,
 	}
-	_, err = repo.Register(ctx, reqs[1].EmailAddress, reg2)
+	_, err = repo.Register(ctx, wk.ID, reqs[1].EmailAddress, reg2)
 	if err != ErrExistingPhoneNumber {
 		t.Errorf("Expected registeration with \"%v\", got %v", ErrExistingPhoneNumber, err)
 	}
+
+	// only the registration that went through stops its invitations being pending, in
+	// every workspace, leaving the second user's and the outsider's
+	pending, err := testDB.
+		NewSelect().
+		Model((*Membership)(nil)).
+		Where("workspace IN (?)", bun.In([]uint{wk.ID, wk2.ID})).
+		Where("invited_at IS NOT NULL").
+		Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pending != 2 {
+		t.Errorf("Expected 2 pending invitations, got %d", pending)
+	}
+}
+
+func TestRepoResetPassword(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	wkRepo := workspaces.NewRepo(testDB)
+	wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := []UserRequest{
+		{fake.Internet().Email(), RoleMember},
+		{fake.Internet().Email(), RoleMember},
+	}
+	if _, err := repo.CreateMany(ctx, wk.ID, reqs); err != nil {
+		t.Fatal(err)
+	}
+
+	registered, err := repo.Register(ctx, wk.ID, reqs[0].EmailAddress, Registration{fake.Person().FirstName(), fake.Person().LastName(), fake.Lorem().Word(), fake.Phone().Number()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.ResetPassword(ctx, reqs[0].EmailAddress, "a-new-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user == nil || user.ID != registered.ID {
+		t.Errorf("Expected user(%d) to have their password reset, got %v", registered.ID, user)
+	}
+
+	// placeholders have no password to reset
+	for _, email := range []string{reqs[1].EmailAddress, fake.Internet().Email()} {
+		if user, err := repo.ResetPassword(ctx, email, "a-new-password"); err != nil || user != nil {
+			t.Errorf("Expected no user for %s, got %v %v", email, user, err)
+		}
+	}
+}
+
+func TestRepoEncryption(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	wkRepo := workspaces.NewRepo(testDB)
+	wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email := fake.Internet().Email()
+	user, err := repo.Create(ctx, wk.ID, UserRequest{email, RoleMember})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := Registration{fake.Person().FirstName(), fake.Person().LastName(), fake.Lorem().Word(), fake.Phone().Number()}
+	registered, err := repo.Register(ctx, wk.ID, email, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if registered.ID != user.ID || string(registered.EmailAddress) != email || string(registered.PhoneNumber) != reg.PhoneNumber {
+		t.Errorf("Expected the user to be found by their email and decrypted, got %+v", registered)
+	}
+
+	var raw struct {
+		EmailAddress string
+		FirstName    string
+		PhoneNumber  string
+	}
+	err = testDB.
+		NewSelect().
+		TableExpr("users").
+		Column("email_address", "first_name", "phone_number").
+		Where("id = ?", user.ID).
+		Scan(ctx, &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{raw.EmailAddress, raw.FirstName, raw.PhoneNumber} {
+		if !strings.HasPrefix(v, pii.Prefix) {
+			t.Errorf("Expected PII to be encrypted at rest, got %s", v)
+		}
+	}
+
+	// users from before encryption
+	_, err = testDB.
+		NewInsert().
+		TableExpr("users").
+		Value("email_address", "?", fake.Internet().Email()).
+		Value("first_name", "?", "Plain").
+		Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plaintext, err := HasPlaintext(ctx, testDB); err != nil || !plaintext {
+		t.Fatalf("Expected the old user to be in plaintext, got %v %v", plaintext, err)
+	}
+
+	n, err := Reencrypt(ctx, testDB, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Errorf("Expected only the old user to be encrypted, got %d", n)
+	}
+
+	if plaintext, err := HasPlaintext(ctx, testDB); err != nil || plaintext {
+		t.Errorf("Expected every user to be encrypted, got %v %v", plaintext, err)
+	}
+
+	if n, err := Reencrypt(ctx, testDB, 1, false); err != nil || n != 0 {
+		t.Errorf("Expected users encrypted with the active key to be left alone, got %d %v", n, err)
+	}
+}
+
+func TestRepoExpireInvitations(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	wkRepo := workspaces.NewRepo(testDB)
+	wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wk2, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := []UserRequest{
+		{fake.Internet().Email(), RoleMember},
+		{fake.Internet().Email(), RoleMember},
+		{fake.Internet().Email(), RoleMember},
+	}
+	ux, err := repo.CreateMany(ctx, wk.ID, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := Registration{
+		fake.Person().FirstName(),
+		fake.Person().LastName(),
+		fake.Lorem().Word(),
+		fake.Phone().Number(),
+	}
+	if _, err := repo.Register(ctx, wk.ID, reqs[1].EmailAddress, reg); err != nil {
+		t.Fatal(err)
+	}
+
+	// invited elsewhere later, so it shouldn't be removed from there
+	if _, err := repo.Create(ctx, wk2.ID, reqs[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := repo.ExpireInvitations(ctx, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expired) != 0 {
+		t.Fatalf("Expected recent invitations to be left alone, got %d expired", len(expired))
+	}
+
+	// age the first workspace's invitations
+	_, err = testDB.
+		NewUpdate().
+		Model((*Membership)(nil)).
+		Set("invited_at = invited_at - interval '30 days'").
+		Where("workspace = ?", wk.ID).
+		Where("invited_at IS NOT NULL").
+		Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err = repo.ExpireInvitations(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expired) != 2 {
+		t.Fatalf("Expected the 2 placeholders in workspace(%d) to expire, got %d", wk.ID, len(expired))
+	}
+
+	exists := func(id uint) bool {
+		ok, err := testDB.NewSelect().TableExpr("users").Where("id = ?", id).Exists(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if exists(ux[0].ID) {
+		t.Error("Expected placeholder without other invitations to be deleted")
+	}
+
+	if !exists(ux[1].ID) {
+		t.Error("Expected registered user to be kept")
+	}
+
+	if !exists(ux[2].ID) {
+		t.Error("Expected placeholder invited elsewhere to be kept")
+	}
+
+	if user, err := repo.Get(ctx, wk2.ID, ux[2].ID); err != nil {
+		t.Fatal(err)
+	} else if user == nil {
+		t.Error("Expected placeholder to still be invited to the other workspace")
+	}
+
+	// expired placeholders can be invited again from scratch
+	if _, err := repo.Create(ctx, wk.ID, reqs[0]); err != nil {
+		t.Errorf("Expected expired placeholder to be invited again, got %v", err)
+	}
+}
+
+func TestRepoTransferOwnership(t *testing.T) {
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	wkRepo := workspaces.NewRepo(testDB)
+
+	t.Run("swaps the roles of the owner and the nominee", func(t *testing.T) {
+		defer afterEach(t)
+
+		wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		owner, err := repo.Create(ctx, wk.ID, UserRequest{fake.Internet().Email(), RoleOwner})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		admin, err := repo.Create(ctx, wk.ID, UserRequest{fake.Internet().Email(), RoleAdmin})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		oldOwner, newOwner, err := repo.TransferOwnership(ctx, wk.ID, owner.ID, admin.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if oldOwner.Role != RoleAdmin {
+			t.Errorf("Expected former owner to become an admin, got %s", oldOwner.Role)
+		}
+
+		if newOwner.Role != RoleOwner {
+			t.Errorf("Expected nominee to become the owner, got %s", newOwner.Role)
+		}
+	})
+
+	t.Run("fails if the nominee is not an admin", func(t *testing.T) {
+		defer afterEach(t)
+
+		wk, err := wkRepo.Create(ctx, fake.Company().Name(), fake.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		owner, err := repo.Create(ctx, wk.ID, UserRequest{fake.Internet().Email(), RoleOwner})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		member, err := repo.Create(ctx, wk.ID, UserRequest{fake.Internet().Email(), RoleMember})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = repo.TransferOwnership(ctx, wk.ID, owner.ID, member.ID)
+		if err != ErrNotAdmin {
+			t.Errorf("Expected transfer to fail with \"%v\", got %v", ErrNotAdmin, err)
+		}
+
+		unchanged, err := repo.Get(ctx, wk.ID, owner.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if unchanged.Role != RoleOwner {
+			t.Errorf("Expected owner to keep their role, got %s", unchanged.Role)
+		}
+	})
 }
@@ -42,7 +42,7 @@ func NewResetToken(ctx context.Context, tStore tokens.Store, user *User) (ResetT
 	rToken := ResetToken{User: user.ID, Workspace: user.Workspace}
 
 	var err error
-	rToken.Key, err = tStore.Commission(ctx, resetTokenDuration, user.EmailAddress, rToken)
+	rToken.Key, err = tStore.Commission(ctx, resetTokenDuration, string(user.EmailAddress), rToken)
 	if err != nil {
 		return rToken, err
 	}
@@ -52,7 +52,7 @@ func NewResetToken(ctx context.Context, tStore tokens.Store, user *User) (ResetT
 	return rToken, nil
 }
 
-func SendResetToken(mailer notification.Mailer, route string, token ResetToken, user *User) error {
+func SendResetToken(ctx context.Context, mailer notification.Mailer, route string, token ResetToken, user *User) error {
 	var day string
 	if token.Expires.Day() == time.Now().Day() {
 		day = "today"
@@ -69,13 +69,13 @@ func SendResetToken(mailer notification.Mailer, route string, token ResetToken,
 		route,
 		token.Key,
 		fmt.Sprintf("%s %s", token.Expires.Format("3:04 pm"), day),
-		user.FirstName,
+		string(user.FirstName),
 	}
-	return mailer.Send(notification.TemplateMail{
+	return mailer.Send(ctx, notification.TemplateMail{
 		Sender:        notification.SenderPostmaster,
 		Subject:       "Reset your password",
 		ReceiverName:  fmt.Sprintf("%s %s", user.FirstName, user.LastName),
-		ReceiverEmail: user.EmailAddress,
+		ReceiverEmail: string(user.EmailAddress),
 		Template:      "password-reset",
 		TemplateData:  data,
 	})
@@ -0,0 +1,118 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"noxecane/go-starter/pkg/notification"
+
+	"github.com/noxecane/anansi/tokens"
+)
+
+var (
+	transferTokenDuration = time.Hour * 48
+
+	ErrTransferExpired = tokens.ErrTokenNotFound
+)
+
+// TransferToken is the pending handover of a workspace from its owner to one of
+// its admins. A workspace can only have one pending transfer at a time.
+type TransferToken struct {
+	Workspace uint      `json:"workspace"`
+	Owner     uint      `json:"owner"`
+	Nominee   uint      `json:"nominee"`
+	Key       string    `json:"-"`
+	Expires   time.Time `json:"-"`
+}
+
+func transferKey(wkID uint) string {
+	return fmt.Sprintf("ownership-transfer:%d", wkID)
+}
+
+// NewTransferToken nominates the given admin to take over the owner's workspace, replacing
+// any transfer that was pending for the workspace.
+func NewTransferToken(ctx context.Context, tStore tokens.Store, owner, nominee *User) (TransferToken, error) {
+	tToken := TransferToken{Workspace: owner.Workspace, Owner: owner.ID, Nominee: nominee.ID}
+
+	var err error
+	tToken.Key, err = tStore.Commission(ctx, transferTokenDuration, transferKey(owner.Workspace), tToken)
+	if err != nil {
+		return tToken, err
+	}
+
+	tToken.Expires = time.Now().Add(transferTokenDuration)
+
+	return tToken, nil
+}
+
+// ViewTransferToken loads the transfer referenced by the token without using it up.
+func ViewTransferToken(ctx context.Context, tStore tokens.Store, token string) (TransferToken, error) {
+	tToken := TransferToken{Key: token}
+	err := tStore.Peek(ctx, token, &tToken)
+
+	return tToken, err
+}
+
+// RevokeTransferToken cancels the pending transfer of the workspace.
+func RevokeTransferToken(ctx context.Context, tStore tokens.Store, wkID uint) error {
+	return tStore.Revoke(ctx, transferKey(wkID))
+}
+
+func SendTransferRequest(ctx context.Context, mailer notification.Mailer, route, companyName string, token TransferToken, owner, nominee *User) error {
+	data := struct {
+		Route       string
+		Token       string
+		CompanyName string
+		FirstName   string
+		OwnerName   string
+	}{
+		route,
+		token.Key,
+		companyName,
+		string(nominee.FirstName),
+		fmt.Sprintf("%s %s", owner.FirstName, owner.LastName),
+	}
+
+	return mailer.Send(ctx, notification.TemplateMail{
+		Sender:        notification.SenderPostmaster,
+		Subject:       fmt.Sprintf("Take over ownership of %s", companyName),
+		ReceiverName:  fmt.Sprintf("%s %s", nominee.FirstName, nominee.LastName),
+		ReceiverEmail: string(nominee.EmailAddress),
+		Template:      "ownership-transfer",
+		TemplateData:  data,
+	})
+}
+
+// SendTransferComplete lets both the former and the new owner know the transfer has gone through.
+func SendTransferComplete(ctx context.Context, mailer notification.Mailer, companyName string, oldOwner, newOwner *User) error {
+	newOwnerName := fmt.Sprintf("%s %s", newOwner.FirstName, newOwner.LastName)
+
+	for _, u := range []*User{oldOwner, newOwner} {
+		data := struct {
+			CompanyName  string
+			FirstName    string
+			NewOwnerName string
+			IsNewOwner   bool
+		}{
+			companyName,
+			string(u.FirstName),
+			newOwnerName,
+			u.ID == newOwner.ID,
+		}
+
+		err := mailer.Send(ctx, notification.TemplateMail{
+			Sender:        notification.SenderPostmaster,
+			Subject:       fmt.Sprintf("Ownership of %s has been transferred", companyName),
+			ReceiverName:  fmt.Sprintf("%s %s", u.FirstName, u.LastName),
+			ReceiverEmail: string(u.EmailAddress),
+			Template:      "ownership-transferred",
+			TemplateData:  data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
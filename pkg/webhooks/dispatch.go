@@ -0,0 +1,155 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"noxecane/go-starter/pkg/backoff"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it's marked as failed
+	MaxAttempts = 8
+
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-Id"
+	HeaderSignature = "X-Webhook-Signature"
+
+	batchSize       = 50
+	maxResponseBody = 1024
+	firstRetry      = 30 * time.Second
+	maxRetry        = 6 * time.Hour
+)
+
+// Sign computes the signature receivers should compare against the X-Webhook-Signature
+// header, which is sent as "t=<timestamp>,v1=<signature>". The timestamp is part of
+// what's signed so receivers can reject replayed requests.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is how long to wait before retrying a delivery that has failed attempts
+// times, doubling from 30s up to 6h.
+func Backoff(attempts int) time.Duration {
+	return backoff.Doubling(attempts, firstRetry, maxRetry)
+}
+
+type Dispatcher struct {
+	repo   *Repo
+	client *http.Client
+}
+
+// NewDispatcher creates a dispatcher that gives receivers timeout to respond. Only
+// public addresses are sent to unless allowPrivate is set, which is for development.
+func NewDispatcher(repo *Repo, timeout time.Duration, allowPrivate bool) *Dispatcher {
+	client := publicClient(timeout)
+	if allowPrivate {
+		client = &http.Client{Timeout: timeout}
+	}
+
+	return &Dispatcher{
+		repo:   repo,
+		client: client,
+	}
+}
+
+// Attempt sends the delivery once and saves the outcome, scheduling a retry if it
+// failed and there are attempts left.
+func (d *Dispatcher) Attempt(ctx context.Context, delivery *Delivery) error {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = nil
+	delivery.ResponseBody = ""
+	delivery.Error = ""
+
+	status, body, err := d.send(ctx, delivery, now)
+	switch {
+	case err != nil:
+		delivery.Error = err.Error()
+	default:
+		delivery.ResponseStatus = &status
+		delivery.ResponseBody = body
+		if status < 200 || status > 299 {
+			delivery.Error = fmt.Sprintf("receiver responded with %d", status)
+		}
+	}
+
+	switch {
+	case delivery.Error == "":
+		delivery.Status = StatusSucceeded
+	case delivery.Attempts >= MaxAttempts:
+		delivery.Status = StatusFailed
+	default:
+		delivery.Status = StatusPending
+		delivery.NextAttemptAt = now.Add(Backoff(delivery.Attempts))
+	}
+
+	return d.repo.Save(ctx, delivery)
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery *Delivery, now time.Time) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+
+	ts := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderID, delivery.EventID)
+	req.Header.Set(HeaderSignature, fmt.Sprintf("t=%d,v1=%s", ts, Sign(delivery.Secret, ts, delivery.Payload)))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	if err != nil {
+		return res.StatusCode, "", err
+	}
+
+	return res.StatusCode, string(body), nil
+}
+
+// Dispatch attempts a batch of due deliveries, returning how many were attempted.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	// long enough for every delivery in the batch to time out before anyone retries them
+	lease := d.client.Timeout*batchSize + time.Minute
+
+	deliveries, err := d.repo.Claim(ctx, batchSize, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range deliveries {
+		if err := d.Attempt(ctx, &deliveries[i]); err != nil {
+			return i, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// DispatchAll attempts due deliveries until there are none left.
+func (d *Dispatcher) DispatchAll(ctx context.Context) error {
+	for {
+		n, err := d.Dispatch(ctx)
+		if err != nil || n < batchSize {
+			return err
+		}
+	}
+}
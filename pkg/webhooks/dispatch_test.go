@@ -0,0 +1,201 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receiver accepts webhooks signed with the secret, failing the first failures requests.
+func receiver(t *testing.T, secret *string, failures int32) (*httptest.Server, *int32) {
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		var ts int64
+		var sig string
+		for _, part := range strings.Split(r.Header.Get(HeaderSignature), ",") {
+			k, v, _ := strings.Cut(part, "=")
+			switch k {
+			case "t":
+				ts, _ = strconv.ParseInt(v, 10, 64)
+			case "v1":
+				sig = v
+			}
+		}
+
+		if sig != Sign(*secret, ts, body) {
+			t.Errorf("Expected a valid signature, got %q", r.Header.Get(HeaderSignature))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if n <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprint(w, "ok")
+	}))
+
+	return srv, &calls
+}
+
+func TestDispatcherAttempt(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	dispatcher := NewDispatcher(repo, time.Second, true)
+	ctx := context.TODO()
+
+	var secret string
+	srv, calls := receiver(t, &secret, 1)
+	defer srv.Close()
+
+	wk := newWorkspace(t)
+	endpoint, err := repo.Create(ctx, wk.ID, EndpointRequest{URL: srv.URL, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret = endpoint.Secret
+
+	delivery, err := repo.Test(ctx, endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dispatcher.Attempt(ctx, delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery.Status != StatusPending || delivery.Attempts != 1 {
+		t.Fatalf("Expected failed delivery to be retried, got %s after %d attempts", delivery.Status, delivery.Attempts)
+	}
+
+	if wait := time.Until(delivery.NextAttemptAt); wait < Backoff(1)-time.Second {
+		t.Errorf("Expected retry to back off by %v, got %v", Backoff(1), wait)
+	}
+
+	if err := dispatcher.Attempt(ctx, delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery.Status != StatusSucceeded || delivery.ResponseBody != "ok" {
+		t.Errorf("Expected second attempt to succeed, got %s(%s)", delivery.Status, delivery.Error)
+	}
+
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("Expected receiver to be called twice, got %d", n)
+	}
+
+	deliveries, err := repo.Deliveries(ctx, wk.ID, endpoint.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 1 || deliveries[0].Status != StatusSucceeded || deliveries[0].Attempts != 2 {
+		t.Errorf("Expected delivery log to show success after 2 attempts, got %+v", deliveries)
+	}
+}
+
+func TestDispatcherDispatch(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	dispatcher := NewDispatcher(repo, time.Second, true)
+	ctx := context.TODO()
+
+	var secret string
+	srv, calls := receiver(t, &secret, 0)
+	defer srv.Close()
+
+	wk := newWorkspace(t)
+	endpoint, err := repo.Create(ctx, wk.ID, EndpointRequest{URL: srv.URL, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret = endpoint.Secret
+
+	for i := 0; i < 3; i++ {
+		if err := repo.Enqueue(ctx, wk.ID, EventUserJoined, map[string]int{"id": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := dispatcher.Dispatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 3 || atomic.LoadInt32(calls) != 3 {
+		t.Errorf("Expected 3 deliveries to be sent, attempted %d and received %d", n, atomic.LoadInt32(calls))
+	}
+
+	if n, err = dispatcher.Dispatch(ctx); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("Expected nothing left to send, attempted %d", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if Backoff(1) != 30*time.Second || Backoff(2) != time.Minute {
+		t.Errorf("Expected backoff to start at 30s and double, got %v and %v", Backoff(1), Backoff(2))
+	}
+
+	if Backoff(30) != 6*time.Hour {
+		t.Errorf("Expected backoff to be capped at 6h, got %v", Backoff(30))
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, u := range []string{
+		"http://localhost:8080/hooks",
+		"http://api.localhost/hooks",
+		"http://127.0.0.1/hooks",
+		"http://10.0.0.5/hooks",
+		"http://192.168.1.1/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.64.0.1/hooks",
+		"http://0.0.0.0/hooks",
+		"http://[::1]/hooks",
+		"http://[fe80::1]/hooks",
+		"http://[::ffff:127.0.0.1]/hooks",
+	} {
+		if err := CheckURL(u); err != ErrPrivateAddress {
+			t.Errorf("Expected %s to be rejected, got %v", u, err)
+		}
+	}
+
+	for _, u := range []string{"https://hooks.noxecane.com/in", "http://93.184.216.34/hooks"} {
+		if err := CheckURL(u); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", u, err)
+		}
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to reach a loopback address")
+	}))
+	defer srv.Close()
+
+	// names that resolve to private addresses are caught when dialling
+	u := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	if _, err := publicClient(time.Second).Get(u); err == nil || !strings.Contains(err.Error(), ErrPrivateAddress.Error()) {
+		t.Errorf("Expected the request to be refused, got %v", err)
+	}
+}
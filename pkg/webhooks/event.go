@@ -0,0 +1,64 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventUserInvited      = "user.invited"
+	EventUserJoined       = "user.joined"
+	EventUserRoleChanged  = "user.role_changed"
+	EventUserLeft         = "user.left"
+	EventWorkspaceDeleted = "workspace.deleted"
+	// EventTest is only sent to the endpoint being tested, it can't be subscribed to
+	EventTest = "webhook.test"
+)
+
+// Events is the catalogue of events endpoints can subscribe to.
+var Events = []string{
+	EventUserInvited,
+	EventUserJoined,
+	EventUserRoleChanged,
+	EventUserLeft,
+	EventWorkspaceDeleted,
+}
+
+// Payload is the body of every webhook request.
+type Payload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Workspace uint        `json:"workspace"`
+	Data      interface{} `json:"data"`
+}
+
+func newPayload(wkID uint, event string, data interface{}) (Payload, []byte, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return Payload{}, nil, err
+	}
+
+	p := Payload{
+		ID:        "evt_" + id,
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Workspace: wkID,
+		Data:      data,
+	}
+
+	raw, err := json.Marshal(p)
+
+	return p, raw, err
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,306 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	// StatusSending is a test delivery being sent straight away, which the dispatcher
+	// leaves alone
+	StatusSending = "sending"
+)
+
+// Endpoint is a URL a workspace wants its events sent to.
+type Endpoint struct {
+	bun.BaseModel `bun:"table:webhook_endpoints"`
+
+	ID          uint      `bun:",pk" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Workspace   uint      `json:"workspace"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Secret      string    `json:"-"`
+	Events      []string  `bun:",array" json:"events"`
+	Active      bool      `json:"active"`
+}
+
+// EndpointRequest is what can be set on an endpoint.
+type EndpointRequest struct {
+	URL         string
+	Description string
+	Events      []string
+	Active      bool
+}
+
+// Delivery is an event queued for an endpoint, along with the result of the last
+// attempt to send it.
+type Delivery struct {
+	bun.BaseModel `bun:"table:webhook_deliveries"`
+
+	ID             uint64          `bun:",pk" json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Endpoint       uint            `json:"endpoint"`
+	Workspace      uint            `json:"workspace"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `bun:"type:jsonb" json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	ResponseBody   string          `json:"response_body"`
+	Error          string          `json:"error"`
+	URL            string          `bun:",scanonly" json:"-"`
+	Secret         string          `bun:",scanonly" json:"-"`
+}
+
+type Repo struct {
+	db bun.IDB
+}
+
+func NewRepo(db bun.IDB) *Repo {
+	return &Repo{db}
+}
+
+// Create adds an endpoint to the workspace with a newly generated signing secret.
+func (r *Repo) Create(ctx context.Context, wkID uint, req EndpointRequest) (*Endpoint, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := &Endpoint{
+		Workspace:   wkID,
+		URL:         req.URL,
+		Description: req.Description,
+		Secret:      "whsec_" + secret,
+		Events:      req.Events,
+		Active:      req.Active,
+	}
+
+	if endpoint.Events == nil {
+		endpoint.Events = []string{}
+	}
+
+	_, err = r.db.
+		NewInsert().
+		Model(endpoint).
+		Column("workspace", "url", "description", "secret", "events", "active").
+		Returning("*").
+		Exec(ctx)
+
+	return endpoint, err
+}
+
+// Get returns the workspace's endpoint with the given ID, or nil if there's none.
+func (r *Repo) Get(ctx context.Context, wkID, id uint) (*Endpoint, error) {
+	endpoint := new(Endpoint)
+	err := r.db.
+		NewSelect().
+		Model(endpoint).
+		Where("id = ?", id).
+		Where("workspace = ?", wkID).
+		Scan(ctx)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return endpoint, err
+}
+
+// List returns all the workspace's endpoints, oldest first.
+func (r *Repo) List(ctx context.Context, wkID uint) ([]Endpoint, error) {
+	endpoints := []Endpoint{}
+	err := r.db.
+		NewSelect().
+		Model(&endpoints).
+		Where("workspace = ?", wkID).
+		Order("id").
+		Scan(ctx)
+
+	return endpoints, err
+}
+
+// Update replaces the endpoint's settings, keeping its secret. Returns nil if the
+// endpoint doesn't exist.
+func (r *Repo) Update(ctx context.Context, wkID, id uint, req EndpointRequest) (*Endpoint, error) {
+	endpoint := &Endpoint{
+		ID:          id,
+		UpdatedAt:   time.Now(),
+		URL:         req.URL,
+		Description: req.Description,
+		Events:      req.Events,
+		Active:      req.Active,
+	}
+
+	if endpoint.Events == nil {
+		endpoint.Events = []string{}
+	}
+
+	res, err := r.db.
+		NewUpdate().
+		Model(endpoint).
+		WherePK().
+		Where("workspace = ?", wkID).
+		Column("updated_at", "url", "description", "events", "active").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, nil
+	}
+
+	return endpoint, nil
+}
+
+// Delete removes the endpoint along with its deliveries.
+func (r *Repo) Delete(ctx context.Context, wkID, id uint) (bool, error) {
+	res, err := r.db.
+		NewDelete().
+		Model((*Endpoint)(nil)).
+		Where("id = ?", id).
+		Where("workspace = ?", wkID).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// Enqueue queues the event for every active endpoint in the workspace subscribed to it.
+// Use a Repo created from the transaction making the change so the event is only sent
+// if the change is kept.
+func (r *Repo) Enqueue(ctx context.Context, wkID uint, event string, data interface{}) error {
+	p, raw, err := newPayload(wkID, event, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.
+		NewRaw(`
+			INSERT INTO webhook_deliveries (endpoint, workspace, event_id, event, payload)
+			SELECT id, workspace, ?, ?, ?::jsonb
+			FROM webhook_endpoints
+			WHERE workspace = ? AND active AND (events = '{}' OR ? = ANY(events))`,
+			p.ID, p.Event, json.RawMessage(raw), wkID, p.Event,
+		).
+		Exec(ctx)
+
+	return err
+}
+
+// Test adds a test event for the endpoint, whether or not it's active. It's for the
+// caller to attempt, the dispatcher only picks it up if that attempt has to be retried.
+func (r *Repo) Test(ctx context.Context, endpoint *Endpoint) (*Delivery, error) {
+	data := map[string]string{"message": "This is a test event"}
+
+	p, raw, err := newPayload(endpoint.Workspace, EventTest, data)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &Delivery{
+		Endpoint:  endpoint.ID,
+		Workspace: endpoint.Workspace,
+		EventID:   p.ID,
+		Event:     p.Event,
+		Payload:   raw,
+		Status:    StatusSending,
+		URL:       endpoint.URL,
+		Secret:    endpoint.Secret,
+	}
+
+	_, err = r.db.
+		NewInsert().
+		Model(delivery).
+		Column("endpoint", "workspace", "event_id", "event", "payload", "status").
+		Returning("*").
+		Exec(ctx)
+
+	return delivery, err
+}
+
+// Deliveries lists the endpoint's deliveries, newest first. before only lists
+// deliveries older than the one with that ID.
+func (r *Repo) Deliveries(ctx context.Context, wkID, endpoint uint, before uint64, limit int) ([]Delivery, error) {
+	deliveries := []Delivery{}
+
+	q := r.db.
+		NewSelect().
+		Model(&deliveries).
+		Where("workspace = ?", wkID).
+		Where("endpoint = ?", endpoint).
+		Order("id DESC").
+		Limit(limit)
+
+	if before != 0 {
+		q = q.Where("id < ?", before)
+	}
+
+	err := q.Scan(ctx)
+
+	return deliveries, err
+}
+
+// Claim picks up to limit deliveries that are due and hides them from other claims
+// for the lease, so a dispatcher that dies mid-way doesn't lose them.
+func (r *Repo) Claim(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	var deliveries []Delivery
+
+	// deliveries for inactive endpoints wait until they're turned back on
+	err := r.db.
+		NewRaw(`
+			UPDATE webhook_deliveries AS d
+			SET next_attempt_at = ?
+			FROM webhook_endpoints AS e
+			WHERE e.id = d.endpoint AND d.id IN (
+				SELECT wd.id
+				FROM webhook_deliveries AS wd
+				JOIN webhook_endpoints AS we ON we.id = wd.endpoint
+				WHERE wd.status = ? AND wd.next_attempt_at <= ? AND we.active
+				ORDER BY wd.next_attempt_at
+				LIMIT ?
+				FOR UPDATE OF wd SKIP LOCKED
+			)
+			RETURNING d.*, e.url, e.secret`,
+			time.Now().Add(lease), StatusPending, time.Now(), limit,
+		).
+		Scan(ctx, &deliveries)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return deliveries, err
+}
+
+// Save records the outcome of an attempt to send the delivery.
+func (r *Repo) Save(ctx context.Context, d *Delivery) error {
+	_, err := r.db.
+		NewUpdate().
+		Model(d).
+		WherePK().
+		Column("status", "attempts", "next_attempt_at", "last_attempt_at", "response_status", "response_body", "error").
+		Exec(ctx)
+
+	return err
+}
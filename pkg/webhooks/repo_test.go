@@ -0,0 +1,150 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/workspaces"
+
+	"github.com/jaswdr/faker"
+	"github.com/noxecane/anansi"
+	"github.com/uptrace/bun"
+)
+
+var testDB *bun.DB
+var fake = faker.New()
+
+func afterEach(t *testing.T) {
+	if _, err := testDB.NewTruncateTable().Table("workspaces", "users").Cascade().Exec(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	var err error
+	var sqlDB *sql.DB
+
+	var env config.Env
+	if err = anansi.LoadEnv(&env); err != nil {
+		panic(err)
+	}
+
+	log := anansi.NewLogger(env.Name)
+
+	if sqlDB, testDB, err = config.SetupDB(env); err != nil {
+		panic(err)
+	}
+	log.Info().Msg("Successfully connected to postgres")
+
+	code := m.Run()
+
+	if err := sqlDB.Close(); err != nil {
+		log.Err(err).Msg("Failed to disconnect from postgres cleanly")
+	}
+
+	os.Exit(code)
+}
+
+func newWorkspace(t *testing.T) *workspaces.Workspace {
+	wk, err := workspaces.NewRepo(testDB).Create(context.TODO(), fake.Company().Name(), fake.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wk
+}
+
+func TestRepoEnqueue(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	wk := newWorkspace(t)
+	other := newWorkspace(t)
+
+	all, err := repo.Create(ctx, wk.ID, EndpointRequest{URL: fake.Internet().URL(), Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joins, err := repo.Create(ctx, wk.ID, EndpointRequest{URL: fake.Internet().URL(), Events: []string{EventUserJoined}, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inactive, err := repo.Create(ctx, wk.ID, EndpointRequest{URL: fake.Internet().URL(), Active: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elsewhere, err := repo.Create(ctx, other.ID, EndpointRequest{URL: fake.Internet().URL(), Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Enqueue(ctx, wk.ID, EventUserInvited, map[string]string{"email_address": fake.Internet().Email()}); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[uint]int{}
+	for _, e := range []*Endpoint{all, joins, inactive, elsewhere} {
+		deliveries, err := repo.Deliveries(ctx, e.Workspace, e.ID, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[e.ID] = len(deliveries)
+	}
+
+	if counts[all.ID] != 1 {
+		t.Errorf("Expected endpoint subscribed to everything to get 1 delivery, got %d", counts[all.ID])
+	}
+
+	if counts[joins.ID] != 0 || counts[inactive.ID] != 0 || counts[elsewhere.ID] != 0 {
+		t.Errorf("Expected only the subscribed endpoint to get the event, got %v", counts)
+	}
+}
+
+func TestRepoClaim(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	wk := newWorkspace(t)
+
+	endpoint, err := repo.Create(ctx, wk.ID, EndpointRequest{URL: fake.Internet().URL(), Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Enqueue(ctx, wk.ID, EventUserLeft, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := repo.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(claimed) != 1 {
+		t.Fatalf("Expected to claim 1 delivery, got %d", len(claimed))
+	}
+
+	if claimed[0].URL != endpoint.URL || claimed[0].Secret != endpoint.Secret {
+		t.Error("Expected claimed delivery to carry the endpoint's URL and secret")
+	}
+
+	again, err := repo.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(again) != 0 {
+		t.Errorf("Expected claimed deliveries to be hidden for the lease, got %d", len(again))
+	}
+}
@@ -0,0 +1,43 @@
+package workspaces
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/noxecane/anansi/tokens"
+	"github.com/rs/zerolog"
+)
+
+// Invitations revokes a workspace's invitation to an email, which invitations.Store does.
+type Invitations interface {
+	Revoke(ctx context.Context, wkID uint, email string) error
+}
+
+// PurgeExpired creates a job that purges deleted workspaces past their grace period,
+// revoking the invitations of the users it removed.
+func PurgeExpired(repo *Repo, invites Invitations, log zerolog.Logger) func(context.Context) error {
+	return func(ctx context.Context) error {
+		purges, err := repo.Purge(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+
+		for _, p := range purges {
+			// the purge can't be run again for these, so every one gets a try
+			for _, email := range p.Invited {
+				if err := invites.Revoke(ctx, p.Workspace, email); err != nil && !errors.Is(err, tokens.ErrTokenNotFound) {
+					log.Err(err).Uint("workspace", p.Workspace).Msg("failed to revoke the invitation of a purged user")
+				}
+			}
+
+			log.Info().
+				Uint("workspace", p.Workspace).
+				Int("users", p.UserCount).
+				Int("invitations", len(p.Invited)).
+				Msg("purged deleted workspace")
+		}
+
+		return nil
+	}
+}
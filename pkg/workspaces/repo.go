@@ -3,16 +3,50 @@ package workspaces
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
+	"noxecane/go-starter/pkg/pii"
+
+	"github.com/gosimple/slug"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/uptrace/bun"
 )
 
+var ErrExistingSlug = errors.New("slug already in use")
+var ErrInvalidSlug = errors.New("slug can only contain lowercase letters, numbers and hyphens")
+var ErrReservedSlug = errors.New("slug is reserved")
+var ErrDeleted = errors.New("workspace has already been deleted")
+var ErrNotDeleted = errors.New("workspace has not been deleted or has already been purged")
+
 type Workspace struct {
-	ID           uint      `bun:",pk" json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	CompanyName  string    `json:"company_name"`
-	EmailAddress string    `json:"email_address"`
+	ID           uint       `bun:",pk" json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	CompanyName  string     `json:"company_name"`
+	EmailAddress string     `json:"email_address"`
+	Slug         string     `json:"slug"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	PurgeAt      *time.Time `json:"purge_at,omitempty"`
+}
+
+// Purge is the anonymised record left behind after a workspace is permanently removed.
+type Purge struct {
+	ID                 uint      `bun:",pk" json:"id"`
+	Workspace          uint      `json:"workspace"`
+	WorkspaceCreatedAt time.Time `json:"workspace_created_at"`
+	DeletedAt          time.Time `json:"deleted_at"`
+	PurgedAt           time.Time `json:"purged_at"`
+	UserCount          int       `json:"user_count"`
+	// Invited are the emails of the users removed before accepting their invitation,
+	// whose invitations are still waiting in redis
+	Invited []string `bun:"-" json:"-"`
+}
+
+// IsDeleted checks whether the workspace is waiting to be purged
+func (w *Workspace) IsDeleted() bool {
+	return w.DeletedAt != nil
 }
 
 type Repo struct {
@@ -23,18 +57,85 @@ func NewRepo(db bun.IDB) *Repo {
 	return &Repo{db}
 }
 
-// Create a workspace
+// reservedSlugs can't be used by workspaces, as they're subdomains of our own.
+var reservedSlugs = map[string]bool{
+	"www":    true,
+	"api":    true,
+	"admin":  true,
+	"app":    true,
+	"docs":   true,
+	"mail":   true,
+	"status": true,
+}
+
+// maxSlugAttempts is how many times Create looks for a free slug when other
+// workspaces keep taking the ones it picks.
+const maxSlugAttempts = 5
+
+// Create a workspace, generating its slug from the company name. The slug is numbered
+// if it's taken or reserved, and picked again if another workspace takes it first.
 func (r *Repo) Create(ctx context.Context, name, email string) (*Workspace, error) {
-	workspace := &Workspace{CompanyName: name, EmailAddress: email}
+	for i := 0; i < maxSlugAttempts; i++ {
+		wkSlug, err := r.availableSlug(ctx, name)
+		if err != nil {
+			return nil, err
+		}
 
-	_, err := r.db.
-		NewInsert().
-		Model(workspace).
-		Column("company_name", "email_address").
-		Returning("*").
-		Exec(ctx)
+		workspace := &Workspace{CompanyName: name, EmailAddress: email, Slug: wkSlug}
 
-	return workspace, err
+		// no unique violation, as that would abort the transaction we might be in
+		res, err := r.db.
+			NewInsert().
+			Model(workspace).
+			Column("company_name", "email_address", "slug").
+			On("CONFLICT (slug) DO NOTHING").
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 1 {
+			return workspace, nil
+		}
+	}
+
+	return nil, ErrExistingSlug
+}
+
+// availableSlug creates a slug from the name, numbering it if another workspace
+// already uses it or it's reserved.
+func (r *Repo) availableSlug(ctx context.Context, name string) (string, error) {
+	base := slug.Make(name)
+	if base == "" {
+		base = "workspace"
+	}
+
+	var taken []string
+	err := r.db.
+		NewSelect().
+		Model((*Workspace)(nil)).
+		Column("slug").
+		Where("slug = ?", base).
+		WhereOr("slug LIKE ?", base+"-%").
+		Scan(ctx, &taken)
+	if err != nil {
+		return "", err
+	}
+
+	used := make(map[string]bool, len(taken))
+	for _, s := range taken {
+		used[s] = true
+	}
+
+	candidate := base
+	for i := 2; used[candidate] || reservedSlugs[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	return candidate, nil
 }
 
 // Get returns the workspace with the given ID. Returns nil if the workspace doesn't exist
@@ -49,6 +150,51 @@ func (r *Repo) Get(ctx context.Context, id uint) (*Workspace, error) {
 	return workspace, err
 }
 
+// GetBySlug returns the workspace with the given slug. Returns nil if the workspace doesn't exist
+func (r *Repo) GetBySlug(ctx context.Context, wkSlug string) (*Workspace, error) {
+	workspace := new(Workspace)
+	err := r.db.NewSelect().Model(workspace).Where("slug = ?", wkSlug).Scan(ctx)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return workspace, err
+}
+
+// ChangeSlug updates the slug of a workspace. Returns ErrInvalidSlug if it's not a
+// valid slug, ErrReservedSlug if it's one of ours and ErrExistingSlug if another
+// workspace already uses it.
+func (r *Repo) ChangeSlug(ctx context.Context, id uint, wkSlug string) (*Workspace, error) {
+	if !slug.IsSlug(wkSlug) {
+		return nil, ErrInvalidSlug
+	}
+
+	if reservedSlugs[wkSlug] {
+		return nil, ErrReservedSlug
+	}
+
+	workspace := &Workspace{
+		ID:   id,
+		Slug: wkSlug,
+	}
+
+	_, err := r.db.
+		NewUpdate().
+		Model(workspace).
+		WherePK().
+		Column("slug").
+		Returning("*").
+		Exec(ctx)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return nil, ErrExistingSlug
+	}
+
+	return workspace, err
+}
+
 // ChangeName updates the name of a workspace.
 func (r *Repo) ChangeName(ctx context.Context, id uint, name string) (*Workspace, error) {
 	workspace := &Workspace{
@@ -66,3 +212,165 @@ func (r *Repo) ChangeName(ctx context.Context, id uint, name string) (*Workspace
 
 	return workspace, err
 }
+
+// Delete marks the workspace as deleted, leaving it restorable until the grace period
+// runs out, after which it gets purged. Returns ErrDeleted if it was deleted already.
+func (r *Repo) Delete(ctx context.Context, id uint, grace time.Duration) (*Workspace, error) {
+	now := time.Now()
+	purgeAt := now.Add(grace)
+	workspace := &Workspace{
+		ID:        id,
+		DeletedAt: &now,
+		PurgeAt:   &purgeAt,
+	}
+
+	res, err := r.db.
+		NewUpdate().
+		Model(workspace).
+		WherePK().
+		Where("deleted_at IS NULL").
+		Column("deleted_at", "purge_at").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrDeleted
+	}
+
+	return workspace, nil
+}
+
+// Restore brings back a deleted workspace. Returns ErrNotDeleted if the workspace
+// isn't deleted or its grace period is over.
+func (r *Repo) Restore(ctx context.Context, id uint) (*Workspace, error) {
+	workspace := &Workspace{ID: id}
+
+	res, err := r.db.
+		NewUpdate().
+		Model(workspace).
+		WherePK().
+		Where("deleted_at IS NOT NULL").
+		Where("purge_at > current_timestamp").
+		Set("deleted_at = NULL").
+		Set("purge_at = NULL").
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotDeleted
+	}
+
+	return workspace, nil
+}
+
+// Purge permanently removes every workspace whose grace period ended before the given
+// time, along with its memberships and any users left without a workspace, leaving an
+// anonymised record for each. Users invited elsewhere are kept, so are their
+// invitations, which can't be accepted into a workspace that's gone anyway.
+func (r *Repo) Purge(ctx context.Context, before time.Time) ([]Purge, error) {
+	var purges []Purge
+
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var wx []Workspace
+		err := tx.
+			NewSelect().
+			Model(&wx).
+			Where("deleted_at IS NOT NULL").
+			Where("purge_at <= ?", before).
+			For("UPDATE SKIP LOCKED").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(wx) > 0 {
+			// audit events refuse deletes unless we say we're purging
+			if _, err := tx.ExecContext(ctx, "SET LOCAL app.purging = 'on'"); err != nil {
+				return err
+			}
+		}
+
+		for _, wk := range wx {
+			_, err := tx.
+				NewDelete().
+				TableExpr("audit_events").
+				Where("workspace = ?", wk.ID).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+
+			var members []uint
+			_, err = tx.
+				NewDelete().
+				TableExpr("memberships").
+				Where("workspace = ?", wk.ID).
+				Returning("user_id").
+				Exec(ctx, &members)
+			if err != nil {
+				return err
+			}
+
+			// users who belong to other workspaces stay behind
+			var removed []struct {
+				EmailAddress pii.Text
+				Password     []byte
+			}
+			if len(members) > 0 {
+				_, err = tx.
+					NewDelete().
+					TableExpr("users").
+					Where("id IN (?)", bun.In(members)).
+					Where("NOT EXISTS (SELECT 1 FROM memberships WHERE user_id = users.id)").
+					Returning("email_address, password").
+					Exec(ctx, &removed)
+				if err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.NewDelete().Model(&wk).WherePK().Exec(ctx); err != nil {
+				return err
+			}
+
+			purge := Purge{
+				Workspace:          wk.ID,
+				WorkspaceCreatedAt: wk.CreatedAt,
+				DeletedAt:          *wk.DeletedAt,
+				UserCount:          len(members),
+			}
+
+			for _, u := range removed {
+				if len(u.Password) == 0 {
+					purge.Invited = append(purge.Invited, string(u.EmailAddress))
+				}
+			}
+
+			_, err = tx.
+				NewInsert().
+				Model(&purge).
+				Column("workspace", "workspace_created_at", "deleted_at", "user_count").
+				Returning("*").
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+
+			purges = append(purges, purge)
+		}
+
+		return nil
+	})
+
+	return purges, err
+}
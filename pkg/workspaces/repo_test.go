@@ -4,9 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"noxecane/go-starter/pkg/config"
+	"noxecane/go-starter/pkg/pii"
 
 	"github.com/noxecane/anansi"
 	"github.com/uptrace/bun"
@@ -16,7 +19,7 @@ import (
 var testDB *bun.DB
 
 func afterEach(t *testing.T) {
-	if _, err := testDB.NewTruncateTable().Table("workspaces", "users").Cascade().Exec(context.TODO()); err != nil {
+	if _, err := testDB.NewTruncateTable().Table("workspaces", "users", "workspace_purges").Cascade().Exec(context.TODO()); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -37,6 +40,10 @@ func TestMain(m *testing.M) {
 	}
 	log.Info().Msg("Successfully connected to postgres")
 
+	if err = pii.Setup(context.TODO(), testDB, env.PIIKeys); err != nil {
+		panic(err)
+	}
+
 	code := m.Run()
 
 	if err := sqlDB.Close(); err != nil {
@@ -81,3 +88,244 @@ func TestRepoGetByID(t *testing.T) {
 		}
 	})
 }
+
+func TestRepoDelete(t *testing.T) {
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	t.Run("can restore a workspace within its grace period", func(t *testing.T) {
+		defer afterEach(t)
+
+		wk, err := repo.Create(ctx, faker.Company().Name(), faker.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deleted, err := repo.Delete(ctx, wk.ID, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !deleted.IsDeleted() {
+			t.Error("Expected workspace to be marked as deleted")
+		}
+
+		if _, err := repo.Delete(ctx, wk.ID, time.Hour); err != ErrDeleted {
+			t.Errorf("Expected deleting twice to fail with \"%v\", got %v", ErrDeleted, err)
+		}
+
+		restored, err := repo.Restore(ctx, wk.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if restored.IsDeleted() {
+			t.Error("Expected restored workspace to not be deleted")
+		}
+	})
+
+	t.Run("cannot restore a workspace past its grace period", func(t *testing.T) {
+		defer afterEach(t)
+
+		wk, err := repo.Create(ctx, faker.Company().Name(), faker.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := repo.Delete(ctx, wk.ID, -time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := repo.Restore(ctx, wk.ID); err != ErrNotDeleted {
+			t.Errorf("Expected restore to fail with \"%v\", got %v", ErrNotDeleted, err)
+		}
+	})
+}
+
+func TestRepoPurge(t *testing.T) {
+	defer afterEach(t)
+
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	expired, err := repo.Create(ctx, faker.Company().Name(), faker.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a placeholder, yet to accept their invitation
+	email := faker.Internet().Email()
+
+	var userID uint
+	err = testDB.
+		NewInsert().
+		TableExpr("users").
+		Value("email_address", "?", email).
+		Returning("id").
+		Scan(ctx, &userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = testDB.
+		NewInsert().
+		TableExpr("memberships").
+		Value("user_id", "?", userID).
+		Value("workspace", "?", expired.ID).
+		Value("role", "?", "owner").
+		Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Delete(ctx, expired.ID, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	grace, err := repo.Create(ctx, faker.Company().Name(), faker.Internet().Email())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Delete(ctx, grace.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	purges, err := repo.Purge(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(purges) != 1 || purges[0].Workspace != expired.ID {
+		t.Fatalf("Expected only workspace %d to be purged, got %v", expired.ID, purges)
+	}
+
+	if purges[0].UserCount != 1 {
+		t.Errorf("Expected purge to remove 1 user, got %d", purges[0].UserCount)
+	}
+
+	if len(purges[0].Invited) != 1 || purges[0].Invited[0] != email {
+		t.Errorf("Expected %s's invitation to be revoked, got %v", email, purges[0].Invited)
+	}
+
+	if wk, err := repo.Get(ctx, expired.ID); err != nil || wk != nil {
+		t.Errorf("Expected purged workspace to be gone, got %v(%v)", wk, err)
+	}
+
+	if wk, err := repo.Get(ctx, grace.ID); err != nil || wk == nil {
+		t.Errorf("Expected workspace in its grace period to remain, got %v(%v)", wk, err)
+	}
+}
+
+func TestRepoSlugs(t *testing.T) {
+	repo := NewRepo(testDB)
+	ctx := context.TODO()
+
+	t.Run("numbers slugs of workspaces with the same name", func(t *testing.T) {
+		defer afterEach(t)
+
+		wk, err := repo.Create(ctx, "Acme Corp", faker.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wk2, err := repo.Create(ctx, "Acme Corp", faker.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if wk.Slug != "acme-corp" {
+			t.Errorf("Expected slug to be acme-corp, got %s", wk.Slug)
+		}
+
+		if wk2.Slug != "acme-corp-2" {
+			t.Errorf("Expected slug to be acme-corp-2, got %s", wk2.Slug)
+		}
+
+		loaded, err := repo.GetBySlug(ctx, wk2.Slug)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded == nil || loaded.ID != wk2.ID {
+			t.Errorf("Expected to load workspace(%d) by its slug, got %v", wk2.ID, loaded)
+		}
+	})
+
+	t.Run("rejects slugs that are taken or invalid", func(t *testing.T) {
+		defer afterEach(t)
+
+		wk, err := repo.Create(ctx, faker.Company().Name(), faker.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wk2, err := repo.Create(ctx, faker.Company().Name(), faker.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := repo.ChangeSlug(ctx, wk2.ID, wk.Slug); err != ErrExistingSlug {
+			t.Errorf("Expected change to fail with \"%v\", got %v", ErrExistingSlug, err)
+		}
+
+		if _, err := repo.ChangeSlug(ctx, wk2.ID, "Not A Slug"); err != ErrInvalidSlug {
+			t.Errorf("Expected change to fail with \"%v\", got %v", ErrInvalidSlug, err)
+		}
+
+		if _, err := repo.ChangeSlug(ctx, wk2.ID, "admin"); err != ErrReservedSlug {
+			t.Errorf("Expected change to fail with \"%v\", got %v", ErrReservedSlug, err)
+		}
+	})
+
+	t.Run("numbers reserved slugs", func(t *testing.T) {
+		defer afterEach(t)
+
+		wk, err := repo.Create(ctx, "API", faker.Internet().Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if wk.Slug != "api-2" {
+			t.Errorf("Expected slug to be api-2, got %s", wk.Slug)
+		}
+	})
+
+	t.Run("picks another slug when one is taken first", func(t *testing.T) {
+		defer afterEach(t)
+
+		const n = 4
+		slugs := make(chan string, n)
+		errs := make(chan error, n)
+
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				wk, err := repo.Create(ctx, "Globex", faker.Internet().Email())
+				if err != nil {
+					errs <- err
+					return
+				}
+				slugs <- wk.Slug
+			}()
+		}
+		wg.Wait()
+		close(slugs)
+		close(errs)
+
+		for err := range errs {
+			t.Errorf("Expected every create to succeed, got %v", err)
+		}
+
+		seen := make(map[string]bool)
+		for s := range slugs {
+			if seen[s] {
+				t.Errorf("Expected every workspace to get its own slug, %s was given twice", s)
+			}
+			seen[s] = true
+		}
+	})
+}
@@ -0,0 +1,11 @@
+begin;
+
+drop table if exists workspace_purges;
+
+drop index if exists workspaces_purge_at_idx;
+
+alter table workspaces
+  drop column if exists purge_at,
+  drop column if exists deleted_at;
+
+commit;
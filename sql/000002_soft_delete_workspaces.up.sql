@@ -0,0 +1,18 @@
+begin;
+
+alter table workspaces
+  add column if not exists deleted_at timestamptz,
+  add column if not exists purge_at timestamptz;
+
+create index if not exists workspaces_purge_at_idx on workspaces (purge_at) where deleted_at is not null;
+
+create table if not exists workspace_purges (
+  id serial primary key,
+  workspace integer not null,
+  workspace_created_at timestamptz not null,
+  deleted_at timestamptz not null,
+  purged_at timestamptz not null default current_timestamp,
+  user_count integer not null
+);
+
+commit;
@@ -0,0 +1,25 @@
+begin;
+
+alter table users
+  add column if not exists workspace integer references workspaces(id),
+  add column if not exists role text;
+
+-- users keep the workspace they joined first
+update users u
+set workspace = m.workspace, role = m.role
+from (
+  select distinct on (user_id) user_id, workspace, role
+  from memberships
+  order by user_id, created_at
+) m
+where m.user_id = u.id;
+
+delete from users where workspace is null;
+
+alter table users
+  alter column workspace set not null,
+  alter column role set not null;
+
+drop table if exists memberships;
+
+commit;
@@ -0,0 +1,21 @@
+begin;
+
+create table if not exists memberships (
+  user_id integer not null references users(id) on delete cascade,
+  workspace integer not null references workspaces(id) on delete cascade,
+  created_at timestamptz not null default current_timestamp,
+  role text not null,
+  primary key (user_id, workspace)
+);
+
+create index if not exists memberships_workspace_idx on memberships (workspace);
+
+insert into memberships (user_id, workspace, created_at, role)
+select id, workspace, created_at, role from users
+on conflict do nothing;
+
+alter table users
+  drop column if exists workspace,
+  drop column if exists role;
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+alter table workspaces drop column if exists slug;
+
+commit;
@@ -0,0 +1,21 @@
+begin;
+
+alter table workspaces add column if not exists slug text;
+
+-- the first workspace to claim a name keeps the plain slug
+update workspaces w
+set slug = case when s.rank = 1 then s.base else s.base || '-' || w.id end
+from (
+  select id, base, row_number() over (partition by base order by id) as rank
+  from (
+    select id, coalesce(nullif(trim(both '-' from lower(regexp_replace(company_name, '[^a-zA-Z0-9]+', '-', 'g'))), ''), 'workspace') as base
+    from workspaces
+  ) b
+) s
+where s.id = w.id;
+
+alter table workspaces
+  alter column slug set not null,
+  add constraint workspaces_slug_key unique (slug);
+
+commit;
@@ -0,0 +1,6 @@
+begin;
+
+drop table if exists team_members;
+drop table if exists teams;
+
+commit;
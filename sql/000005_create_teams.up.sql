@@ -0,0 +1,22 @@
+begin;
+
+create table if not exists teams (
+  id serial primary key,
+  created_at timestamptz not null default current_timestamp,
+  workspace integer not null references workspaces(id) on delete cascade,
+  name text not null,
+  description text not null default '',
+  unique (workspace, name)
+);
+
+create table if not exists team_members (
+  team integer not null references teams(id) on delete cascade,
+  user_id integer not null references users(id) on delete cascade,
+  created_at timestamptz not null default current_timestamp,
+  role text not null,
+  primary key (team, user_id)
+);
+
+create index if not exists team_members_user_id_idx on team_members (user_id);
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+drop trigger if exists audit_events_append_only on audit_events;
+drop function if exists audit_events_append_only();
+drop table if exists audit_events;
+
+commit;
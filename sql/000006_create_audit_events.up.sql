@@ -0,0 +1,34 @@
+begin;
+
+create table if not exists audit_events (
+  id bigserial primary key,
+  created_at timestamptz not null default current_timestamp,
+  workspace integer not null,
+  actor integer,
+  action text not null,
+  target_type text not null,
+  target_id text not null,
+  ip_address text not null default '',
+  user_agent text not null default '',
+  changes jsonb
+);
+
+create index if not exists audit_events_workspace_idx on audit_events (workspace, id desc);
+
+-- events can only be removed when their workspace gets purged
+create or replace function audit_events_append_only() returns trigger as $$
+begin
+  if tg_op = 'DELETE' and current_setting('app.purging', true) = 'on' then
+    return old;
+  end if;
+
+  raise exception 'audit events are append-only';
+end;
+$$ language plpgsql;
+
+drop trigger if exists audit_events_append_only on audit_events;
+create trigger audit_events_append_only
+  before update or delete on audit_events
+  for each row execute function audit_events_append_only();
+
+commit;
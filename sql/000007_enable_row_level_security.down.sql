@@ -0,0 +1,31 @@
+begin;
+
+drop policy if exists audit_events_tenant on audit_events;
+alter table audit_events disable row level security;
+
+drop policy if exists team_members_tenant on team_members;
+alter table team_members disable row level security;
+
+drop policy if exists teams_tenant on teams;
+alter table teams disable row level security;
+
+drop policy if exists users_tenant on users;
+alter table users disable row level security;
+
+drop policy if exists memberships_tenant on memberships;
+alter table memberships disable row level security;
+
+drop policy if exists workspaces_tenant on workspaces;
+alter table workspaces disable row level security;
+
+drop function if exists current_workspace();
+
+alter default privileges in schema public revoke usage, select on sequences from app_tenant;
+alter default privileges in schema public revoke select, insert, update, delete on tables from app_tenant;
+revoke usage, select on all sequences in schema public from app_tenant;
+revoke select, insert, update, delete on all tables in schema public from app_tenant;
+revoke usage on schema public from app_tenant;
+
+-- app_tenant is shared by every database on the server, so it stays
+
+commit;
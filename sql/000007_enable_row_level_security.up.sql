@@ -0,0 +1,51 @@
+begin;
+
+-- superusers and table owners skip row level security, so tenant scoped
+-- transactions switch to this role with SET LOCAL ROLE
+do $$
+begin
+  if not exists (select 1 from pg_roles where rolname = 'app_tenant') then
+    create role app_tenant nologin;
+  end if;
+
+  execute format('grant app_tenant to %I', current_user);
+end;
+$$;
+
+grant usage on schema public to app_tenant;
+grant select, insert, update, delete on all tables in schema public to app_tenant;
+grant usage, select on all sequences in schema public to app_tenant;
+alter default privileges in schema public grant select, insert, update, delete on tables to app_tenant;
+alter default privileges in schema public grant usage, select on sequences to app_tenant;
+
+-- null when no workspace has been set, which hides every row
+create or replace function current_workspace() returns integer as $$
+  select nullif(current_setting('app.current_workspace', true), '')::integer
+$$ language sql stable;
+
+alter table workspaces enable row level security;
+create policy workspaces_tenant on workspaces
+  using (id = current_workspace());
+
+alter table memberships enable row level security;
+create policy memberships_tenant on memberships
+  using (workspace = current_workspace());
+
+-- users can belong to many workspaces, so they're only visible through a membership
+alter table users enable row level security;
+create policy users_tenant on users
+  using (exists (select 1 from memberships m where m.user_id = users.id and m.workspace = current_workspace()));
+
+alter table teams enable row level security;
+create policy teams_tenant on teams
+  using (workspace = current_workspace());
+
+alter table team_members enable row level security;
+create policy team_members_tenant on team_members
+  using (team in (select id from teams where workspace = current_workspace()));
+
+alter table audit_events enable row level security;
+create policy audit_events_tenant on audit_events
+  using (workspace = current_workspace());
+
+commit;
@@ -0,0 +1,6 @@
+begin;
+
+drop table if exists webhook_deliveries;
+drop table if exists webhook_endpoints;
+
+commit;
@@ -0,0 +1,47 @@
+begin;
+
+create table if not exists webhook_endpoints (
+  id serial primary key,
+  created_at timestamptz not null default current_timestamp,
+  updated_at timestamptz not null default current_timestamp,
+  workspace integer not null references workspaces(id) on delete cascade,
+  url text not null,
+  description text not null default '',
+  secret text not null,
+  -- empty means every event
+  events text[] not null default '{}',
+  active boolean not null default true
+);
+
+create index if not exists webhook_endpoints_workspace_idx on webhook_endpoints (workspace);
+
+create table if not exists webhook_deliveries (
+  id bigserial primary key,
+  created_at timestamptz not null default current_timestamp,
+  endpoint integer not null references webhook_endpoints(id) on delete cascade,
+  workspace integer not null,
+  event_id text not null,
+  event text not null,
+  payload jsonb not null,
+  status text not null default 'pending',
+  attempts integer not null default 0,
+  next_attempt_at timestamptz not null default current_timestamp,
+  last_attempt_at timestamptz,
+  response_status integer,
+  response_body text not null default '',
+  error text not null default ''
+);
+
+create index if not exists webhook_deliveries_pending_idx on webhook_deliveries (next_attempt_at)
+  where status = 'pending';
+create index if not exists webhook_deliveries_endpoint_idx on webhook_deliveries (endpoint, id desc);
+
+alter table webhook_endpoints enable row level security;
+create policy webhook_endpoints_tenant on webhook_endpoints
+  using (workspace = current_workspace());
+
+alter table webhook_deliveries enable row level security;
+create policy webhook_deliveries_tenant on webhook_deliveries
+  using (workspace = current_workspace());
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists memberships_invited_at_idx;
+
+alter table memberships drop column if exists invited_at;
+
+commit;
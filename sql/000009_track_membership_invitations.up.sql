@@ -0,0 +1,13 @@
+begin;
+
+-- when the member was last invited, so placeholders that never accepted can be cleaned up
+alter table memberships add column if not exists invited_at timestamptz;
+
+update memberships m
+set invited_at = m.created_at
+from users u
+where u.id = m.user_id and u.password is null;
+
+create index if not exists memberships_invited_at_idx on memberships (invited_at) where invited_at is not null;
+
+commit;
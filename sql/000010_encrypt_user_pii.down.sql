@@ -0,0 +1,13 @@
+begin;
+
+-- only works while the columns are still in plaintext, there's no decrypting here
+alter table users
+  drop column if exists phone_index,
+  drop column if exists email_index,
+  alter column phone_number type varchar(20),
+  add constraint users_email_address_key unique (email_address),
+  add constraint users_phone_number_key unique (phone_number);
+
+drop table if exists pii_keys;
+
+commit;
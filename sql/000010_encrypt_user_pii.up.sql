@@ -0,0 +1,38 @@
+begin;
+
+-- data keys encrypt PII columns and the index key makes their blind indexes, each
+-- wrapped by a master key from PII_KEYS, named in master_key. New data keys are only
+-- used for encrypting once they're activated.
+create table if not exists pii_keys (
+  id serial primary key,
+  created_at timestamptz not null default current_timestamp,
+  activated_at timestamptz,
+  purpose text not null,
+  master_key text not null,
+  wrapped bytea not null
+);
+
+-- encrypted values are longer and never the same twice, so the blind indexes take
+-- over uniqueness. Rows from before are filled in by `pii reencrypt`.
+alter table users
+  drop constraint if exists users_email_address_key,
+  drop constraint if exists users_phone_number_key,
+  alter column phone_number type text,
+  add column if not exists email_index bytea unique,
+  add column if not exists phone_index bytea unique;
+
+-- audit events and webhook payloads only keep user IDs and roles from now on, drop
+-- the copies of personal data they already hold
+alter table audit_events disable trigger audit_events_append_only;
+
+update audit_events
+  set changes = changes - array['first_name', 'last_name', 'email_address', 'phone_number']
+  where target_type = 'user' and changes ?| array['first_name', 'last_name', 'email_address', 'phone_number'];
+
+alter table audit_events enable trigger audit_events_append_only;
+
+update webhook_deliveries
+  set payload = jsonb_set(payload, '{data}', (payload->'data') - array['first_name', 'last_name', 'email_address', 'phone_number'])
+  where event like 'user.%' and jsonb_typeof(payload->'data') = 'object';
+
+commit;